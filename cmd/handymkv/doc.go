@@ -24,7 +24,27 @@ If any of these applications are not found in the $PATH then an error is returne
 
 If the -c flag is provided then the application will run the setup process. This process will create the configuration files needed for the application to run.
 
-If the -d flag is provided then the application will rip the disc with the specified index. If no index is provided then the application will rip disc 0.
+If the -d flag is provided then the application will rip the disc with the specified index. If no index is provided then the application will rip disc 0. A device path or a drive alias from the configuration file can be provided instead of an index.
+
+If the -t flag is provided then titles will be selected using the provided title selection instead of prompting the user. If standard input is not a terminal and no title selection is provided then the application exits with an error.
+
+If the resume command is provided then the run in the given directory is resumed from its journal instead of reading titles from a disc. Example: handymkv resume handymkv_2024-01-01_12-00-00
+
+If the serve command is provided then an HTTP API is served which lists drives, scans discs and runs rip and encode jobs submitted to it. Requests must carry the bearer token from the serve section of the configuration file. A web dashboard built on the API is served at the root of the listen address, and Prometheus metrics are served at /metrics. If the mqtt section of the configuration file sets a broker, the state of the drives and jobs is also published to it and eject and start commands are taken from its command topic.
+
+If the session command is provided then the discs inserted into the drive given with the -d flag are ripped one after another into a single output directory, with the user asked for the next disc once each disc is ripped.
+
+If the test-notifications command is provided then a test notification is sent to every webhook in the notifications section of the configuration file. Webhooks are notified when a run starts, a disc finishes ripping, a title is encoded and a run completes or fails.
+
+If the watch command is provided then the application watches the drives for newly inserted discs and rips, encodes and ejects each of them without prompting. Prometheus metrics are served at /metrics on the metrics_address from the watch section of the configuration file, if set.
+
+If the -l flag is provided then every drive attached to the system is listed along with its state and the disc inserted in it.
+
+If the -i flag is provided then the titles of the discs given with the -d flag are printed without being ripped.
+
+If --output json is provided then the drive listing, disc info, configuration, version and run summary are printed to standard output as JSON, and all other output is written to standard error.
+
+If the -p flag is provided then the settings of the named profile from the configuration file replace the base settings.
 
 If the -q flag is provided then the application will rip the disc with the specified quality. If no quality is provided then the application will rip with the quality specificed in the config file.
 
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
-	"slices"
-	"strconv"
 	"strings"
 
 	"github.com/dmars8047/handymkv/internal/hmkv"
@@ -20,18 +22,65 @@ func main() {
 	var readConfig bool
 	var configure bool
 	var listDiscs bool
+	var showInfo bool
+	var titleSelections string
+	var encodeWorkers int
+	var continueOnError bool
+	var profile string
+	var output string
 
 	flag.BoolVar(&version, "v", false, "Version. Prints the version of the application.")
 	flag.BoolVar(&configure, "c", false, "Configure. Runs the configuration wizard.")
 	flag.BoolVar(&readConfig, "r", false, "Read. Reads and outputs the first encountered configuration file. The current working directory is searched first, then the user-level configuration.")
-	flag.BoolVar(&listDiscs, "l", false, "List. Lists the available discs. The disc index is required to rip a disc. Drives without a valid disc inserted will not be listed.")
-	flag.StringVar(&discIds, "d", "0", "Discs. A comma delimited list of disc indexes to rip. Example: -d 0,1,2")
+	flag.BoolVar(&listDiscs, "l", false, "List. Lists every drive along with its state and the disc inserted in it. The disc index is required to rip a disc.")
+	flag.BoolVar(&showInfo, "i", false, "Info. Prints the titles and streams of the discs given with the -d flag without ripping them.")
+	flag.StringVar(&discIds, "d", "0", "Discs. A comma delimited list of the drives to rip from. Each drive is a disc index, a device path or a drive alias from the configuration file. Example: -d 0,/dev/sr1,top")
+	flag.StringVar(&titleSelections, "t", "", "Titles. Selects titles without prompting. A comma delimited list of title IDs, ID ranges (0-5), 'all', 'longest' or 'min-length=<duration>'. Prefix an entry with '!' to exclude it. Applies to every disc unless prefixed with a disc index, device path or drive alias. Separate per-disc selections with a semicolon. Example: -t \"0:1,2;/dev/sr1:longest\"")
+	flag.BoolVar(&continueOnError, "k", false, "Keep going. A title which fails to rip or encode is marked as failed and the remaining titles continue to be processed. Failures are listed when processing completes.")
+	flag.StringVar(&profile, "p", "", "Profile. The name of a profile from the configuration file whose settings replace the base settings for this run.")
+	flag.StringVar(&output, "output", "text", "Output. The output format, either 'text' or 'json'. In json mode the drive listing, disc info, configuration, version and run summary are printed as JSON and all other messages are written to standard error. Example: --output json")
+	flag.IntVar(&encodeWorkers, "w", 0, "Workers. The number of titles to encode concurrently. Overrides the encode_workers configuration value.")
+
+	flag.Usage = printUsage
+
+	// The first argument may be a command, in which case the flags follow it
+	args := os.Args[1:]
+	var command string
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	flag.CommandLine.Parse(args)
+
+	if command != "" && command != "resume" && command != "watch" && command != "session" && command != "serve" && command != "test-notifications" {
+		fmt.Printf("Unknown command '%s'.\n\n", command)
+		printUsage()
+		os.Exit(2)
+	}
+
+	if output != "text" && output != "json" {
+		fmt.Printf("Invalid output format '%s'. Valid formats are 'text' and 'json'.\n\n", output)
+		os.Exit(2)
+	}
 
-	flag.Parse()
+	// In json mode standard output only receives JSON, everything else is written to standard error
+	var jsonOutput io.Writer
+
+	if output == "json" {
+		jsonOutput = os.Stdout
+		os.Stdout = os.Stderr
+	}
 
 	hmkv.PrintLogo()
 
 	if version {
+		if jsonOutput != nil {
+			printJSON(jsonOutput, map[string]string{"version": applicationVersion})
+			return
+		}
+
 		fmt.Printf("HandyMKV version %s\n\n", applicationVersion)
 		return
 	}
@@ -66,11 +115,24 @@ func main() {
 			return
 		}
 
+		if jsonOutput != nil {
+			printJSON(jsonOutput, config.Redacted())
+			return
+		}
+
 		fmt.Printf("Configuration file found.\n\n%+v\n", config)
 
 		return
 	}
 
+	// Notifications are sent without makemkvcon or HandBrakeCLI
+	if command == "test-notifications" {
+		err := hmkv.TestNotifications()
+
+		handleExecError(err)
+		return
+	}
+
 	err := checkForPrerequisites()
 
 	if err != nil {
@@ -79,77 +141,239 @@ func main() {
 	}
 
 	if listDiscs {
-		fmt.Printf("Detecting available discs...\n\n")
+		fmt.Printf("Detecting drives...\n\n")
 
-		discs, err := hmkv.ListDiscs()
+		drives, err := hmkv.ListDrives()
 
 		if err != nil {
-			fmt.Printf("An error occurred while listing the discs.\n\nError: %v\n", err)
+			fmt.Printf("An error occurred while listing the drives.\n\nError: %v\n", err)
+			os.Exit(1)
+		}
+
+		if jsonOutput != nil {
+			printJSON(jsonOutput, drives)
 			return
 		}
 
-		if len(discs) < 1 {
-			fmt.Printf("No discs found.\n\n")
+		if len(drives) < 1 {
+			fmt.Printf("No drives found.\n\n")
 			return
 		}
 
-		fmt.Printf("Available discs:\n\n")
+		fmt.Printf("Drives:\n\n")
+
+		for _, drive := range drives {
+			if drive.Name != "" {
+				fmt.Printf("Disc - %d - %s\n", drive.Index, drive.Name)
+			} else if drive.HasDisc() {
+				fmt.Printf("Disc - %d - Unnamed disc\n", drive.Index)
+			} else {
+				fmt.Printf("Disc - %d - None\n", drive.Index)
+			}
+
+			fmt.Printf("    Drive: %s, Device: %s, State: %s\n", drive.Model, drive.DevicePath, drive.State)
 
-		for _, disc := range discs {
-			fmt.Printf("Disc - %d - %s\n", disc.Index, disc.Name)
+			if drive.MediaType != "" {
+				fmt.Printf("    Media: %s (%s)\n", drive.MediaType, strings.Join(drive.Flags, ", "))
+			}
 		}
 
 		fmt.Printf("\n")
 		return
 	}
 
-	discIdSet := make(map[int]struct{})
+	if command == "serve" {
+		err = hmkv.Serve()
 
-	for _, rawDiscId := range strings.Split(strings.ReplaceAll(discIds, " ", ""), ",") {
+		handleExecError(err)
+		return
+	}
 
-		id, err := strconv.Atoi(rawDiscId)
+	discIdInts, err := hmkv.ResolveDrives(strings.Split(discIds, ","))
 
-		if err != nil || id < 0 {
-			fmt.Printf("Invalid disc index value detected.\n\nExiting.\n\n")
-			return
+	if err != nil {
+		fmt.Printf("Invalid disc value detected - %v\n\nExiting.\n\n", err)
+		os.Exit(1)
+	}
+
+	if len(discIdInts) < 1 {
+		fmt.Printf("No valid disc parameters detected.\n\nExiting.\n\n")
+		return
+	}
+
+	if showInfo {
+		printDiscInfo(discIdInts, jsonOutput)
+		return
+	}
+
+	options, err := hmkv.ParseTitleSelections(titleSelections)
+
+	if err != nil {
+		fmt.Printf("Invalid title selection value detected - %v\n\nExiting.\n\n", err)
+		os.Exit(1)
+	}
+
+	if encodeWorkers < 0 {
+		fmt.Printf("Invalid encode worker count detected.\n\nExiting.\n\n")
+		os.Exit(1)
+	}
+
+	options.EncodeWorkers = encodeWorkers
+	options.ContinueOnError = continueOnError
+	options.Profile = profile
+	options.SummaryOutput = jsonOutput
+
+	if command == "session" {
+		if len(discIdInts) != 1 {
+			fmt.Printf("Session mode uses a single drive. Provide one disc index with the -d flag.\n\nExiting.\n\n")
+			os.Exit(2)
 		}
 
-		discIdSet[id] = struct{}{}
+		err = hmkv.Session(discIdInts[0], options)
+
+		handleExecError(err)
+		return
 	}
 
-	if len(discIdSet) < 1 {
-		fmt.Printf("No valid disc parameters detected.\n\nExiting.\n\n")
+	if command == "watch" {
+		err = hmkv.Watch(options)
+
+		handleExecError(err)
 		return
 	}
 
-	discIdInts := make([]int, 0, len(discIdSet))
+	if command == "resume" {
+		if flag.NArg() < 1 {
+			fmt.Printf("The directory of the run to resume is required. Example: handymkv resume handymkv_2024-01-01_12-00-00\n\n")
+			os.Exit(2)
+		}
+
+		err = hmkv.Resume(flag.Arg(0), options)
 
-	for id := range discIdSet {
-		discIdInts = append(discIdInts, id)
+		if err == hmkv.ErrJournalNotFound {
+			fmt.Printf("No run journal was found in %s. Only runs started by this version of HandyMKV or later can be resumed.\n\n", flag.Arg(0))
+			os.Exit(1)
+		}
+
+		handleExecError(err)
+		return
 	}
 
-	slices.Sort(discIdInts)
+	err = hmkv.Exec(discIdInts, options)
 
-	err = hmkv.Exec(discIdInts)
+	handleExecError(err)
+}
 
-	if err != nil {
-		if err == hmkv.ErrConfigNotFound {
-			fmt.Printf("Config file not found. Please run the configuration wizard with 'handymkv -c'.\n\n")
-			return
-		} else if discErr, ok := err.(*hmkv.DiscError); ok {
-			fmt.Printf("An error occurred while reading titles from disc %d. Please ensure the disc is inserted and try again.\n\n", discErr.DiscId)
-			return
+// Prints the error returned from a run and exits with a non-zero status where appropriate.
+func handleExecError(err error) {
+	if err == nil {
+		return
+	}
+
+	if err == hmkv.ErrConfigNotFound {
+		fmt.Printf("Config file not found. Please run the configuration wizard with 'handymkv -c'.\n\n")
+		return
+	} else if errors.Is(err, hmkv.ErrTitlesFailed) {
+		// The failures have already been listed in the summary
+		fmt.Printf("%v\n\n", err)
+		os.Exit(1)
+	} else if err == hmkv.ErrServeTokenRequired {
+		fmt.Printf("Serve mode requires an access token. Set the 'token' in the 'serve' section of the configuration file.\n\n")
+		os.Exit(1)
+	} else if err == hmkv.ErrNotificationsFailed {
+		fmt.Printf("\n%v.\n\n", err)
+		os.Exit(1)
+	} else if err == hmkv.ErrSessionNeedsTerminal {
+		fmt.Printf("Session mode asks for each disc and must be run from an interactive terminal.\n\n")
+		os.Exit(1)
+	} else if errors.Is(err, hmkv.ErrProfileNotFound) {
+		fmt.Printf("%v. Profiles are configured in the 'profiles' section of the configuration file.\n\n", err)
+		os.Exit(1)
+	} else if err == hmkv.ErrInterrupted {
+		// What finished and what was abandoned has already been listed
+		os.Exit(hmkv.InterruptExitStatus)
+	} else if err == hmkv.ErrNoTitleSelection {
+		fmt.Printf("Standard input is not a terminal so titles cannot be selected interactively. Provide a title selection with the -t flag.\n\n")
+		os.Exit(1)
+	} else if discErr, ok := err.(*hmkv.DiscError); ok {
+		fmt.Printf("An error occurred while reading disc %d - %s. Please ensure the disc is inserted and try again.\n\n", discErr.DiscId, discErr.Msg)
+		return
+	}
+
+	fmt.Printf("\nAn error occurred during handymkv execution process.\n\nError - %v\n\n", err)
+
+	// If the error is an ExternalProcessError, print the process output
+	expErr, isExternalProcessErr := err.(*hmkv.ExternalProcessError)
+
+	if isExternalProcessErr && expErr.ProcessOuput != "" {
+		fmt.Print(expErr.ProcessOuput)
+	}
+
+	os.Exit(1)
+}
+
+// Prints the titles of each disc, as JSON if jsonOutput is set.
+func printDiscInfo(discIds []int, jsonOutput io.Writer) {
+	allTitles := make([]hmkv.TitleInfo, 0)
+
+	for i, discId := range discIds {
+		fmt.Printf("Reading titles from disc %d...\n\n", discId)
+
+		titles, err := hmkv.ReadTitles(discId)
+
+		if err != nil {
+			handleExecError(err)
+			os.Exit(1)
 		}
 
-		fmt.Printf("\nAn error occurred during handymkv execution process.\n\nError - %v\n\n", err)
+		allTitles = append(allTitles, titles...)
+
+		if jsonOutput != nil {
+			continue
+		}
+
+		fmt.Printf("Disc %d - %s", discId, titles[0].DiscTitle)
+
+		if titles[0].DiscType != "" {
+			fmt.Printf(" (%s)", titles[0].DiscType)
+		}
 
-		// If the error is an ExternalProcessError, print the process output
-		expErr, isExternalProcessErr := err.(*hmkv.ExternalProcessError)
+		fmt.Printf("\n\n")
+		hmkv.PrintTitles(titles)
 
-		if isExternalProcessErr && expErr.ProcessOuput != "" {
-			fmt.Print(expErr.ProcessOuput)
+		if i < len(discIds)-1 {
+			fmt.Println()
 		}
 	}
+
+	if jsonOutput != nil {
+		printJSON(jsonOutput, allTitles)
+		return
+	}
+
+	fmt.Println()
+}
+
+// Prints the value as indented JSON. Exits if it cannot be written.
+func printJSON(w io.Writer, v any) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(v); err != nil {
+		fmt.Printf("An error occurred while writing the output.\n\nError: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Prints the available commands and flags.
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: handymkv [command] [flags]\n\nCommands:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  resume <run directory>\n        Resumes an interrupted run. Titles which were already ripped or encoded are not processed again.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  serve\n        Serves an HTTP API for listing drives, scanning discs and submitting, cancelling and following rip and encode jobs.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  session\n        Rips several discs one after another from a single drive into one output directory. Asks for the next disc once a disc is ripped.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  test-notifications\n        Sends a test notification to every webhook in the notifications section of the configuration file.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  watch\n        Watches the drives for newly inserted discs. Each disc is ripped and encoded without prompting and then ejected.\n\nFlags:\n")
+	flag.PrintDefaults()
 }
 
 // Checks for application prerequisites. Returns an error if a prerequisite is not found.
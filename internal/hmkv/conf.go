@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 )
 
@@ -26,10 +28,23 @@ var ErrConfigNotFound = errors.New("config file not found")
 type configFileLocation int
 
 type handyMKVConfig struct {
-	EncodeConfig       EncodingParams `json:"encoding_params"`
-	MKVOutputDirectory string         `json:"mkv_output_directory"`
-	HBOutputDirectory  string         `json:"handbrake_output_directory"`
-	DeleteRawMKVFiles  bool           `json:"delete_raw_mkv_files"`
+	EncodeConfig       EncodingParams      `json:"encoding_params"`
+	MKVOutputDirectory string              `json:"mkv_output_directory"`
+	HBOutputDirectory  string              `json:"handbrake_output_directory"`
+	DeleteRawMKVFiles  bool                `json:"delete_raw_mkv_files"`
+	TitleFilters       titleFilters        `json:"title_filters"`
+	EncodeWorkers      int                 `json:"encode_workers"`
+	ContinueOnError    bool                `json:"continue_on_error"`
+	Retry              retryConfig         `json:"retry"`
+	KeepPartialFiles   bool                `json:"keep_partial_files"`
+	EjectAfterRip      bool                `json:"eject_after_rip"`
+	EjectCommand       string              `json:"eject_command,omitempty"`
+	Drives             map[string]string   `json:"drives,omitempty"`
+	Watch              watchConfig         `json:"watch"`
+	Serve              serveConfig         `json:"serve"`
+	Notifications      notificationsConfig `json:"notifications"`
+	MQTT               mqttConfig          `json:"mqtt"`
+	Profiles           map[string]profile  `json:"profiles,omitempty"`
 }
 
 func (config *handyMKVConfig) String() string {
@@ -72,10 +87,141 @@ func (config *handyMKVConfig) String() string {
 	sb.WriteString(fmt.Sprintf("MKV Output Directory: %s\n", config.MKVOutputDirectory))
 	sb.WriteString(fmt.Sprintf("HandBrake Output Directory: %s\n", config.HBOutputDirectory))
 	sb.WriteString(fmt.Sprintf("Automatically Delete Raw MKV Files: %t\n", config.DeleteRawMKVFiles))
+	sb.WriteString(fmt.Sprintf("Concurrent Encode Workers: %d\n", max(config.EncodeWorkers, 1)))
+	sb.WriteString(fmt.Sprintf("Continue On Error: %t\n", config.ContinueOnError))
+	sb.WriteString(fmt.Sprintf("Keep Partial Files: %t\n", config.KeepPartialFiles))
+	sb.WriteString(fmt.Sprintf("Eject After Rip: %t\n", config.EjectAfterRip))
+
+	if config.EjectCommand != "" {
+		sb.WriteString(fmt.Sprintf("Eject Command: %s\n", config.EjectCommand))
+	}
+
+	sb.WriteString(fmt.Sprintf("Rip Attempts: %d\n", config.Retry.Rip.attempts()))
+
+	if config.Retry.Rip.attempts() > 1 && config.Retry.Rip.Delay != "" {
+		sb.WriteString(fmt.Sprintf("Rip Retry Delay: %s\n", config.Retry.Rip.Delay))
+	}
+
+	sb.WriteString(fmt.Sprintf("Encode Attempts: %d\n", config.Retry.Encode.attempts()))
+
+	if config.Retry.Encode.attempts() > 1 && config.Retry.Encode.Delay != "" {
+		sb.WriteString(fmt.Sprintf("Encode Retry Delay: %s\n", config.Retry.Encode.Delay))
+	}
+
+	if config.TitleFilters.enabled() {
+		sb.WriteString("\n")
+		sb.WriteString("Title Filters\n\n")
+
+		if config.TitleFilters.MinDuration != "" {
+			sb.WriteString(fmt.Sprintf("Minimum Duration: %s\n", config.TitleFilters.MinDuration))
+		}
+
+		if config.TitleFilters.MaxDuration != "" {
+			sb.WriteString(fmt.Sprintf("Maximum Duration: %s\n", config.TitleFilters.MaxDuration))
+		}
+
+		if config.TitleFilters.MinChapters > 0 {
+			sb.WriteString(fmt.Sprintf("Minimum Chapters: %d\n", config.TitleFilters.MinChapters))
+		}
+
+		if config.TitleFilters.MinSizeMB > 0 {
+			sb.WriteString(fmt.Sprintf("Minimum Size: %d MB\n", config.TitleFilters.MinSizeMB))
+		}
+
+		if config.TitleFilters.TopNByLength > 0 {
+			sb.WriteString(fmt.Sprintf("Top N By Length: %d\n", config.TitleFilters.TopNByLength))
+		}
+	}
+
+	if len(config.Drives) > 0 {
+		sb.WriteString("\n")
+		sb.WriteString("Drive Aliases\n\n")
+
+		for _, alias := range slices.Sorted(maps.Keys(config.Drives)) {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", alias, config.Drives[alias]))
+		}
+	}
+
+	if config.Serve.ListenAddress != "" || config.Serve.Token != "" {
+		sb.WriteString("\n")
+		sb.WriteString("Serve Settings\n\n")
+		sb.WriteString(fmt.Sprintf("Listen Address: %s\n", config.Serve.address()))
+		sb.WriteString(fmt.Sprintf("Token Set: %t\n", config.Serve.Token != ""))
+	}
+
+	if len(config.Notifications.Webhooks) > 0 {
+		sb.WriteString("\n")
+		sb.WriteString("Notifications\n\n")
+
+		for _, webhook := range config.Notifications.Webhooks {
+			events := "all events"
+
+			if len(webhook.Events) > 0 {
+				events = fmt.Sprint(webhook.Events)
+			}
+
+			sb.WriteString(fmt.Sprintf("Webhook: %s (%s)\n", webhook.redactedURL(), events))
+		}
+	}
+
+	if config.MQTT.Broker != "" {
+		topics := config.MQTT.topics()
+
+		sb.WriteString("\n")
+		sb.WriteString("MQTT Settings\n\n")
+		sb.WriteString(fmt.Sprintf("Broker: %s\n", config.MQTT.Broker))
+		sb.WriteString(fmt.Sprintf("Client ID: %s\n", config.MQTT.clientId()))
+		sb.WriteString(fmt.Sprintf("Status Topic: %s\n", topics.status))
+		sb.WriteString(fmt.Sprintf("Command Topic: %s\n", topics.command))
+		sb.WriteString(fmt.Sprintf("Home Assistant Discovery: %t\n", config.MQTT.HomeAssistantDiscovery))
+	}
+
+	if len(config.Profiles) > 0 {
+		sb.WriteString("\n")
+		sb.WriteString(fmt.Sprintf("Profiles: %s\n", strings.Join(config.profileNames(), ", ")))
+	}
 
 	return sb.String()
 }
 
+// Replaces secrets in configuration output.
+const redactedValue = "[redacted]"
+
+// Returns a copy of the configuration with its secrets replaced, for output which may be shared or logged.
+// The serve token, MQTT password, webhook URLs and the values of webhook headers, which usually hold credentials,
+// are redacted. Only the scheme and host of webhook URLs are kept.
+func (config *handyMKVConfig) Redacted() *handyMKVConfig {
+	redacted := *config
+
+	if redacted.Serve.Token != "" {
+		redacted.Serve.Token = redactedValue
+	}
+
+	if redacted.MQTT.Password != "" {
+		redacted.MQTT.Password = redactedValue
+	}
+
+	redacted.Notifications.Webhooks = slices.Clone(config.Notifications.Webhooks)
+
+	for i, webhook := range redacted.Notifications.Webhooks {
+		redacted.Notifications.Webhooks[i].URL = webhook.redactedURL()
+
+		if len(webhook.Headers) == 0 {
+			continue
+		}
+
+		headers := make(map[string]string, len(webhook.Headers))
+
+		for name := range webhook.Headers {
+			headers[name] = redactedValue
+		}
+
+		redacted.Notifications.Webhooks[i].Headers = headers
+	}
+
+	return &redacted
+}
+
 func getUserConfigPath() (string, error) {
 	if runtime.GOOS == "windows" {
 		appData := os.Getenv("APPDATA")
@@ -129,40 +275,83 @@ func readConfigFile(filePath string) (*handyMKVConfig, error) {
 		return nil, fmt.Errorf("error parsing config file - %w", err)
 	}
 
-	if cfg.EncodeConfig.PresetFile != "" {
-		presetFile, err := readPresetFile(cfg.EncodeConfig.PresetFile)
+	if cfg.EncodeWorkers < 0 {
+		return nil, fmt.Errorf("error parsing config file - %w: encode_workers cannot be negative", ErrInvalidInput)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("error reading HandBrake preset file - %w", err)
-		}
+	if err := cfg.Retry.Rip.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - %w", err)
+	}
 
-		if len(presetFile.PresetList) < 1 {
-			return nil, fmt.Errorf("no presets found in the HandBrake preset file - %s", cfg.EncodeConfig.PresetFile)
-		}
+	if err := cfg.Retry.Encode.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - %w", err)
+	}
 
-		if len(presetFile.PresetList) > 0 {
-			cfg.EncodeConfig.Preset = presetFile.PresetList[0].PresetName
+	if err := cfg.TitleFilters.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - %w", err)
+	}
 
-			var format string
+	if err := cfg.Watch.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - %w", err)
+	}
 
-			switch presetFile.PresetList[0].FileFormat {
-			case "av_mp4":
-				format = "mp4"
-			case "av_mkv":
-				format = "mkv"
-			case "av_webm":
-				format = "webm"
-			default:
-				format = "mkv"
-			}
+	if err := cfg.Notifications.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - notifications - %w", err)
+	}
+
+	if err := cfg.MQTT.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config file - %w", err)
+	}
+
+	if err := applyPresetFile(&cfg.EncodeConfig); err != nil {
+		return nil, err
+	}
 
-			cfg.EncodeConfig.OutputFileFormat = format
+	for name, profile := range cfg.Profiles {
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing config file - profile '%s' - %w", name, err)
 		}
 	}
 
 	return &cfg, nil
 }
 
+// Sets the preset name and output file format of the encoding params from their HandBrake preset file, if any.
+func applyPresetFile(params *EncodingParams) error {
+	if params.PresetFile == "" {
+		return nil
+	}
+
+	presetFile, err := readPresetFile(params.PresetFile)
+
+	if err != nil {
+		return fmt.Errorf("error reading HandBrake preset file - %w", err)
+	}
+
+	if len(presetFile.PresetList) < 1 {
+		return fmt.Errorf("no presets found in the HandBrake preset file - %s", params.PresetFile)
+	}
+
+	params.Preset = presetFile.PresetList[0].PresetName
+
+	var format string
+
+	switch presetFile.PresetList[0].FileFormat {
+	case "av_mp4":
+		format = "mp4"
+	case "av_mkv":
+		format = "mkv"
+	case "av_webm":
+		format = "webm"
+	default:
+		format = "mkv"
+	}
+
+	params.OutputFileFormat = format
+
+	return nil
+}
+
 // Reads a HandBrake preset file and returns a struct containing the contained presets.
 func readPresetFile(filePath string) (*HandBrakePresetFile, error) {
 	var presetFile HandBrakePresetFile
@@ -418,6 +607,8 @@ func promptForConfig(configLocationSelection int) (*handyMKVConfig, error) {
 
 	clear()
 
+	config.EncodeWorkers = 1
+
 	config.DeleteRawMKVFiles = promptForBool("Automatically delete raw unencoded files after ripping/encoding operations? [y/N]",
 		"If enabled, raw unencoded mkv files will be deleted after the ripping/encoding operation completes. If disabled, raw unencoded files will be retained. Leaving this option enabled is recommended as it will save space on the disk.",
 		true)
@@ -47,6 +47,8 @@ var ErrTitlesDiscRead = errors.New("cannot read titles from disc")
 type ExternalProcessError struct {
 	Err          error
 	ProcessOuput string
+	// The path of the log file the process output was written to, if any.
+	LogPath string
 }
 
 func NewExternalProcessError(err error, output string) *ExternalProcessError {
@@ -59,3 +61,9 @@ func NewExternalProcessError(err error, output string) *ExternalProcessError {
 func (e *ExternalProcessError) Error() string {
 	return e.Err.Error()
 }
+
+func (e *ExternalProcessError) Unwrap() error {
+	return e.Err
+}
+
+var ErrTitlesFailed = errors.New("one or more titles failed to process")
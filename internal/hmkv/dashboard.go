@@ -0,0 +1,24 @@
+package hmkv
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The files of the web dashboard served by serve mode.
+//
+//go:embed web
+var dashboardFiles embed.FS
+
+// Returns the handler which serves the web dashboard.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "web")
+
+	// The directory is embedded at build time, so it is always present
+	if err != nil {
+		panic(err)
+	}
+
+	return http.FileServerFS(files)
+}
@@ -10,4 +10,6 @@ Its two main functions provide are to setup the configuration files and to execu
 Setup() - This function is called by the main package to setup configuration files. Configuration files store settings for the ripping and encoding process and various other runtime settings.
 
 Exec() - This function is called by the main package to execute the Ripping and Encoding of the files from a specified disc. Ripping and Encoding are two separate processes that are executed with concurrency (where possible).
+
+SetCommandRunner() - All makemkvcon and HandBrakeCLI invocations go through a CommandRunner. The default runner executes real processes; a fake runner can be set to replay recorded or scripted tool output.
 */
@@ -0,0 +1,103 @@
+package hmkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
+)
+
+var ErrDriveNotFound = errors.New("drive not found")
+
+// Resolves drive identifiers to the makemkvcon drive indexes currently assigned to them. An identifier is either a
+// drive index, a device path such as /dev/sr0, or an alias from the drives section of the configuration file.
+// Drive indexes can change when drives are attached or detached, so device paths and aliases are resolved against the
+// drives attached at the time of the call. The returned indexes are sorted and free of duplicates.
+func ResolveDrives(ids []string) ([]int, error) {
+	indexes := make([]int, 0, len(ids))
+
+	var aliases map[string]string
+	var drives []mkvrobot.Drive
+
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+
+		if id == "" {
+			continue
+		}
+
+		if index, err := strconv.Atoi(id); err == nil {
+			if index < 0 {
+				return nil, fmt.Errorf("%w: disc index cannot be negative", ErrInvalidInput)
+			}
+
+			indexes = append(indexes, index)
+			continue
+		}
+
+		// Only read the configuration and query makemkvcon when something needs resolving
+		if drives == nil {
+			if config, err := ReadConfig(); err == nil {
+				aliases = config.Drives
+			} else if err != ErrConfigNotFound {
+				return nil, err
+			}
+
+			var err error
+			drives, err = listDrives()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		devicePath := id
+
+		if aliasPath, ok := aliases[id]; ok {
+			devicePath = aliasPath
+		}
+
+		index, err := findDriveIndex(drives, devicePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	slices.Sort(indexes)
+
+	return slices.Compact(indexes), nil
+}
+
+// Returns the index of the drive with the given device path. Symbolic links, such as the stable names
+// under /dev/disk/by-id, are followed before comparing.
+func findDriveIndex(drives []mkvrobot.Drive, devicePath string) (int, error) {
+	resolvedPath := resolveDevicePath(devicePath)
+
+	for _, drive := range drives {
+		if drive.DevicePath == "" {
+			continue
+		}
+
+		if drive.DevicePath == devicePath || resolveDevicePath(drive.DevicePath) == resolvedPath {
+			return drive.Index, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: no attached drive has the device path or alias '%s'", ErrDriveNotFound, devicePath)
+}
+
+// Follows symbolic links in the device path. The path is returned unchanged if it cannot be resolved.
+func resolveDevicePath(devicePath string) string {
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		return resolved
+	}
+
+	return devicePath
+}
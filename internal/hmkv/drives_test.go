@@ -0,0 +1,127 @@
+package hmkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Creates stand-ins for the device files of two drives, a stable symbolic link to the second and a configuration
+// with aliases for them, and answers drive listings with the drives. A third drive has no device path.
+// Returns the directory containing the device files.
+func useTestDrives(t *testing.T) string {
+	t.Helper()
+
+	dir := useTestConfig(t, testConfig)
+
+	for _, name := range []string{"sr0", "sr1"} {
+		writeTestFile(t, filepath.Join(dir, "dev", name), 0)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "dev", "by-id"), 0740); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(dir, "dev", "sr1"), filepath.Join(dir, "dev", "by-id", "bluray-drive")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`{
+		"encoding_params": {"encoder": "x264", "quality": 20},
+		"mkv_output_directory": "mkv",
+		"handbrake_output_directory": "hb",
+		"drives": {
+			"dvd": %q,
+			"bluray": %q,
+			"gone": %q
+		}
+	}`, filepath.Join(dir, "dev", "sr0"), filepath.Join(dir, "dev", "by-id", "bluray-drive"), filepath.Join(dir, "dev", "sr7"))
+
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newFakeRunner(t, fakeInfo(fmt.Sprintf(`DRV:0,2,999,12,"DVD-RW","A DISC",%q
+DRV:1,0,999,12,"BD-RE","",%q
+DRV:2,256,999,0,"","",""
+`, filepath.Join(dir, "dev", "sr0"), filepath.Join(dir, "dev", "sr1"))))
+
+	return dir
+}
+
+func TestResolveDrives(t *testing.T) {
+	dir := useTestDrives(t)
+
+	tests := []struct {
+		name string
+		ids  []string
+		want []int
+	}{
+		{name: "index", ids: []string{"1"}, want: []int{1}},
+		{name: "index of a drive which is not attached", ids: []string{"5"}, want: []int{5}},
+		{name: "device path", ids: []string{filepath.Join(dir, "dev", "sr1")}, want: []int{1}},
+		{name: "symbolic link", ids: []string{filepath.Join(dir, "dev", "by-id", "bluray-drive")}, want: []int{1}},
+		{name: "alias", ids: []string{"dvd"}, want: []int{0}},
+		{name: "alias of a symbolic link", ids: []string{"bluray"}, want: []int{1}},
+		{name: "sorted", ids: []string{"bluray", " dvd "}, want: []int{0, 1}},
+		{name: "duplicates", ids: []string{"0", "dvd", filepath.Join(dir, "dev", "sr0"), "", "bluray", "1"}, want: []int{0, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveDrives(test.ids)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("ResolveDrives(%q) = %v, want %v", test.ids, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveDrivesInvalid(t *testing.T) {
+	dir := useTestDrives(t)
+
+	tests := []struct {
+		name string
+		ids  []string
+		want error
+	}{
+		{name: "negative index", ids: []string{"-1"}, want: ErrInvalidInput},
+		{name: "unknown alias", ids: []string{"0", "cdrom"}, want: ErrDriveNotFound},
+		{name: "alias of a missing drive", ids: []string{"gone"}, want: ErrDriveNotFound},
+		{name: "unknown device path", ids: []string{filepath.Join(dir, "dev", "sr2")}, want: ErrDriveNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveDrives(test.ids)
+
+			if !errors.Is(err, test.want) {
+				t.Errorf("ResolveDrives(%q) = %v, %v, want %v", test.ids, got, err, test.want)
+			}
+		})
+	}
+}
+
+// Checks that drive indexes are resolved without reading the configuration or listing the drives.
+func TestResolveDriveIndexes(t *testing.T) {
+	useTestConfig(t, `not json`)
+	r := newFakeRunner(t)
+
+	got, err := ResolveDrives([]string{"2", "0", "2"})
+
+	if err != nil || !slices.Equal(got, []int{0, 2}) {
+		t.Errorf("ResolveDrives() = %v, %v, want [0 2]", got, err)
+	}
+
+	if calls := r.callsTo("makemkvcon"); len(calls) != 0 {
+		t.Errorf("makemkvcon calls = %v, want none", calls)
+	}
+}
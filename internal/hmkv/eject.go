@@ -0,0 +1,98 @@
+package hmkv
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// Replaced with the device path of the drive in a configured eject command.
+const ejectDevicePlaceholder = "{device}"
+
+// Returns the command used to eject the disc in the drive with the given device path on the current platform.
+func defaultEjectCommand(devicePath string) (string, []string) {
+	switch runtime.GOOS {
+	case "darwin":
+		// drutil works on the first drive unless told otherwise, device paths reported by makemkvcon are not accepted
+		return "drutil", []string{"eject"}
+	case "windows":
+		// makemkvcon reports drive letters such as D: on Windows
+		script := fmt.Sprintf("(New-Object -ComObject Shell.Application).Namespace(17).ParseName('%s').InvokeVerb('Eject')", devicePath)
+		return "powershell", []string{"-NoProfile", "-Command", script}
+	default:
+		return "eject", []string{devicePath}
+	}
+}
+
+// Splits a configured eject command into its name and arguments, replacing the device placeholder with the device path.
+// Arguments containing spaces can be wrapped in double or single quotes. The device path is substituted after the command
+// is split, so it is always passed as part of a single argument. The platform default is used if the command is empty.
+func parseEjectCommand(command, devicePath string) (string, []string) {
+	fields := splitCommandLine(command)
+
+	if len(fields) < 1 {
+		return defaultEjectCommand(devicePath)
+	}
+
+	for i, field := range fields {
+		fields[i] = strings.ReplaceAll(field, ejectDevicePlaceholder, devicePath)
+	}
+
+	return fields[0], fields[1:]
+}
+
+// Ejects the disc in the drive with the given device path using the configured eject command, or the platform default if none is configured.
+func ejectDisc(ctx context.Context, command, devicePath string) error {
+	if devicePath == "" {
+		return fmt.Errorf("cannot eject disc - %w: makemkvcon did not report a device path for the drive", ErrInvalidInput)
+	}
+
+	name, args := parseEjectCommand(command, devicePath)
+
+	output, err := runCombinedOutput(ctx, name, args...)
+
+	if err != nil {
+		return NewExternalProcessError(fmt.Errorf("an error occurred while ejecting %s - %s failure: %w", devicePath, name, err), string(output))
+	}
+
+	return nil
+}
+
+// Splits a command line into fields separated by whitespace. Quotes group whitespace into a field and are removed.
+// Backslashes are kept as they are, as they separate directories on Windows. An unterminated quote runs to the end.
+func splitCommandLine(command string) []string {
+	fields := make([]string, 0)
+
+	var field strings.Builder
+	var quote rune
+	inField := false
+
+	for _, r := range command {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
@@ -0,0 +1,74 @@
+package hmkv
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestParseEjectCommand(t *testing.T) {
+	tests := []struct {
+		command    string
+		devicePath string
+		wantName   string
+		wantArgs   []string
+	}{
+		{command: "eject {device}", devicePath: "/dev/sr0", wantName: "eject", wantArgs: []string{"/dev/sr0"}},
+		{command: "  eject   -T  {device} ", devicePath: "/dev/sr1", wantName: "eject", wantArgs: []string{"-T", "/dev/sr1"}},
+		{command: "eject", devicePath: "/dev/sr0", wantName: "eject", wantArgs: []string{}},
+		{command: "ssh host eject --device={device}", devicePath: "/dev/sr0", wantName: "ssh", wantArgs: []string{"host", "eject", "--device=/dev/sr0"}},
+		{command: "eject {device} {device}", devicePath: "/dev/sr0", wantName: "eject", wantArgs: []string{"/dev/sr0", "/dev/sr0"}},
+		// The device path stays one argument even if it contains spaces
+		{command: "eject {device}", devicePath: "/Volumes/My Disc", wantName: "eject", wantArgs: []string{"/Volumes/My Disc"}},
+		{
+			command:    `"C:\Program Files\Tools\eject.exe" '{device}' --label "My Disc" ""`,
+			devicePath: "D:",
+			wantName:   `C:\Program Files\Tools\eject.exe`,
+			wantArgs:   []string{"D:", "--label", "My Disc", ""},
+		},
+		{command: `sh -c "it's {device}"`, devicePath: "/dev/sr0", wantName: "sh", wantArgs: []string{"-c", "it's /dev/sr0"}},
+		{command: `eject "{device}`, devicePath: "/dev/sr0", wantName: "eject", wantArgs: []string{"/dev/sr0"}},
+		{command: `pre"fix"ed{device}`, devicePath: "/dev/sr0", wantName: "prefixed/dev/sr0", wantArgs: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			name, args := parseEjectCommand(test.command, test.devicePath)
+
+			if name != test.wantName || !slices.Equal(args, test.wantArgs) {
+				t.Errorf("parseEjectCommand() = %q %q, want %q %q", name, args, test.wantName, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseEjectCommandDefault(t *testing.T) {
+	wantName, wantArgs := defaultEjectCommand("/dev/sr0")
+
+	for _, command := range []string{"", "   "} {
+		if name, args := parseEjectCommand(command, "/dev/sr0"); name != wantName || !slices.Equal(args, wantArgs) {
+			t.Errorf("parseEjectCommand(%q) = %q %q, want the default %q %q", command, name, args, wantName, wantArgs)
+		}
+	}
+
+	if runtime.GOOS == "linux" && (wantName != "eject" || !slices.Equal(wantArgs, []string{"/dev/sr0"})) {
+		t.Errorf("defaultEjectCommand() = %q %q, want eject of the device", wantName, wantArgs)
+	}
+}
+
+func TestEjectDisc(t *testing.T) {
+	r := newFakeRunner(t, &fakeCommand{name: "fake-eject"})
+
+	if err := ejectDisc(context.Background(), "fake-eject '{device}'", "/dev/sr0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := r.callsTo("fake-eject"); len(calls) != 1 || !slices.Equal(calls[0], []string{"/dev/sr0"}) {
+		t.Errorf("fake-eject calls = %q, want the device ejected", calls)
+	}
+
+	if err := ejectDisc(context.Background(), "fake-eject {device}", ""); err == nil {
+		t.Error("ejectDisc() without a device path succeeded, want an error")
+	}
+}
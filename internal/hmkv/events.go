@@ -0,0 +1,106 @@
+package hmkv
+
+import (
+	"sync"
+	"time"
+)
+
+// The kind of a run event.
+type runEventKind uint8
+
+const (
+	// A run was started. Sent once the titles of the run are known.
+	eventRunStarted runEventKind = iota
+	// A title was ripped.
+	eventTitleRipped
+	// Every title selected from a disc was ripped, or failed to rip, and the drive is no longer needed by the run.
+	eventDiscRipped
+	// A title was encoded.
+	eventTitleEncoded
+	// A title failed to rip or encode. Titles stopped by an interruption or cancellation are not reported.
+	eventTitleFailed
+	// A run completed or stopped. The summary of the run is attached.
+	eventRunFinished
+)
+
+// Something which happened during a run. Which fields are set depends on the kind of event.
+type runEvent struct {
+	kind runEventKind
+	run  *pipeline
+	time time.Time
+	// The title or, for disc events, the first title of the disc.
+	title TitleInfo
+	// The stage of the title which failed.
+	stage processStage
+	// The encoder of the title, as used to label metrics. See encoderName.
+	encoder string
+	// The time spent ripping or encoding the title, including retries.
+	duration time.Duration
+	// The size of the ripped or encoded file.
+	bytes int64
+	// The average encoding speed in frames per second.
+	avgFPS  float64
+	err     error
+	summary *RunSummary
+}
+
+// Receives the events of every run in the process. Events are delivered synchronously from the goroutine
+// processing the run, so sinks must return quickly and must not call back into the run.
+type eventSink interface {
+	handleEvent(event runEvent)
+}
+
+var (
+	eventSinksMutex sync.Mutex
+	eventSinks      []eventSink
+)
+
+// Adds a sink which receives the events of every run started from now on.
+func addEventSink(sink eventSink) {
+	eventSinksMutex.Lock()
+	defer eventSinksMutex.Unlock()
+
+	eventSinks = append(eventSinks, sink)
+}
+
+// Sends an event about the run to every sink and to the run's notifier.
+func (p *pipeline) emit(event runEvent) {
+	event.run = p
+	event.time = time.Now()
+
+	eventSinksMutex.Lock()
+	sinks := eventSinks
+	eventSinksMutex.Unlock()
+
+	for _, sink := range sinks {
+		sink.handleEvent(event)
+	}
+
+	if p.notifier != nil {
+		p.notifier.handleEvent(event)
+	}
+}
+
+// Returns the title of the run with the given disc and title index.
+func (p *pipeline) title(discId, titleIndex int) TitleInfo {
+	p.journal.mutex.Lock()
+	defer p.journal.mutex.Unlock()
+
+	for _, entry := range p.journal.Entries {
+		if entry.Title.DiscId == discId && entry.Title.Index == titleIndex {
+			return entry.title()
+		}
+	}
+
+	return TitleInfo{DiscId: discId, Index: titleIndex}
+}
+
+// Returns the name of the encoder used by the encoding parameters. Titles encoded with a HandBrake preset
+// are named after the preset, as it decides the encoder.
+func encoderName(params *EncodingParams) string {
+	if params.Preset != "" {
+		return params.Preset
+	}
+
+	return params.Encoder
+}
@@ -3,11 +3,61 @@ package hmkv
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+const (
+	// Marks the temporary files HandBrakeCLI writes to before they are renamed to their final name.
+	tempEncodeMarker = ".handymkv-tmp"
+	// Temporary files which have not been written to for this long are considered to be left behind by a dead run.
+	staleTempFileAge = 30 * time.Minute
+)
+
+// Returns the path of the hidden temporary file an encode is written to before being renamed to the final path.
+// The file extension is kept so HandBrakeCLI still picks the container format from it.
+func tempEncodePath(finalPath string) string {
+	ext := filepath.Ext(finalPath)
+	name := strings.TrimSuffix(filepath.Base(finalPath), ext)
+
+	return filepath.Join(filepath.Dir(finalPath), "."+name+tempEncodeMarker+ext)
+}
+
+// Reports whether the file name is that of a temporary encode file.
+func isTempEncodeFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempEncodeMarker)
+}
+
+// Deletes temporary encode files left behind in the directory tree by runs which died before cleaning up.
+// Files which were written to recently are left alone as they may belong to a run which is still going.
+// Returns the number of files deleted.
+func removeStaleTempFiles(dir string) int {
+	removed := 0
+
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isTempEncodeFile(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+
+		if err != nil || time.Since(info.ModTime()) < staleTempFileAge {
+			return nil
+		}
+
+		if os.Remove(path) == nil {
+			removed++
+		}
+
+		return nil
+	})
+
+	return removed
+}
+
 // Reads the size of the specified file and returns it in bytes.
 // If the file does not exist or an error occurs, it returns an error.
 func getFileSize(filePath string) (int64, error) {
@@ -24,6 +74,23 @@ func getFileSize(filePath string) (int64, error) {
 	return fileInfo.Size(), nil
 }
 
+// Appends the content to the log file at the given path, creating it if it does not exist.
+func appendToLogFile(logFilePath, content string) error {
+	f, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("an error occured while creating log file - %w", err)
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write to log file: %w", err)
+	}
+
+	return nil
+}
+
 func deleteRawFiles(config *handyMKVConfig) {
 	fmt.Printf("\nDeleting raw unencoded files...\n\n")
 
@@ -42,9 +109,9 @@ func calculateTotalFileSizes(titles []TitleInfo, config *handyMKVConfig) (int64,
 	var totalSizeRaw, totalSizeEncoded int64
 
 	for _, title := range titles {
-		rawFilePath := filepath.Join(config.MKVOutputDirectory, title.Subdirectory(), title.FileName)
+		params := encodingParamsFor(&title, config)
 
-		rawFileSize, err := getFileSize(rawFilePath)
+		rawFileSize, err := getFileSize(params.MKVOutputPath)
 
 		if err != nil {
 			return 0, 0, err
@@ -52,9 +119,7 @@ func calculateTotalFileSizes(titles []TitleInfo, config *handyMKVConfig) (int64,
 
 		totalSizeRaw += rawFileSize
 
-		encodedFilePath := filepath.Join(config.HBOutputDirectory, title.Subdirectory(), strings.ReplaceAll(title.FileName, " ", "_"))
-
-		encodedFileSize, err := getFileSize(encodedFilePath)
+		encodedFileSize, err := getFileSize(params.HandBrakeOutputPath)
 
 		if err != nil {
 			return 0, 0, err
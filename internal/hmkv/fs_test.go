@@ -0,0 +1,67 @@
+package hmkv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTempEncodePath(t *testing.T) {
+	got := tempEncodePath(filepath.Join("hb", "MY_DISC", "My_Disc_t01.mp4"))
+	want := filepath.Join("hb", "MY_DISC", ".My_Disc_t01"+tempEncodeMarker+".mp4")
+
+	if got != want {
+		t.Errorf("tempEncodePath() = %q, want %q", got, want)
+	}
+
+	if !isTempEncodeFile(filepath.Base(got)) {
+		t.Errorf("isTempEncodeFile(%q) = false, want true", filepath.Base(got))
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := time.Now().Add(-staleTempFileAge - time.Minute)
+	recent := time.Now().Add(-staleTempFileAge + time.Minute)
+
+	files := []struct {
+		path    string
+		modTime time.Time
+		removed bool
+	}{
+		{path: filepath.Join("run", "MY_DISC", ".feature"+tempEncodeMarker+".mkv"), modTime: stale, removed: true},
+		{path: filepath.Join("run", ".extra"+tempEncodeMarker+".mp4"), modTime: stale, removed: true},
+		// May belong to a run which is still encoding
+		{path: filepath.Join("run", "MY_DISC", ".bonus"+tempEncodeMarker+".mkv"), modTime: recent},
+		// Encoded files and hidden files of other programs are never temporary encode files
+		{path: filepath.Join("run", "MY_DISC", "feature.mkv"), modTime: stale},
+		{path: filepath.Join("run", "feature"+tempEncodeMarker+".mkv"), modTime: stale},
+		{path: filepath.Join("run", ".DS_Store"), modTime: stale},
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.path)
+		writeTestFile(t, path, 16)
+
+		if err := os.Chtimes(path, file.modTime, file.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if removed := removeStaleTempFiles(dir); removed != 2 {
+		t.Errorf("removeStaleTempFiles() = %d, want 2", removed)
+	}
+
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join(dir, file.path))
+
+		if exists := err == nil; exists == file.removed {
+			t.Errorf("%s exists: %t, want %t", file.path, exists, !file.removed)
+		}
+	}
+
+	if removed := removeStaleTempFiles(filepath.Join(dir, "missing")); removed != 0 {
+		t.Errorf("removeStaleTempFiles() of a missing directory = %d, want 0", removed)
+	}
+}
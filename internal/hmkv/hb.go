@@ -2,14 +2,19 @@ package hmkv
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type EncodingParams struct {
+	DiscId                      int      `json:"-"`
 	TitleIndex                  int      `json:"-"`
 	MKVOutputPath               string   `json:"-"`
 	HandBrakeOutputPath         string   `json:"-"`
@@ -34,10 +39,66 @@ type HandBrakePreset struct {
 	FileFormat string `json:"FileFormat"`
 }
 
-func encode(ctx context.Context, params *EncodingParams) error {
+// Progress of an encode as reported by HandBrakeCLI.
+type encodeProgress struct {
+	// The overall progress of the encode as a percentage.
+	Percent float64
+	// The current encoding speed in frames per second.
+	FPS float64
+	// The average encoding speed in frames per second.
+	AvgFPS float64
+	// The estimated time remaining.
+	ETA time.Duration
+}
+
+// Matches HandBrakeCLI progress lines.
+// Example: Encoding: task 1 of 1, 45.23 % (87.1 fps, avg 90.2 fps, ETA 00h12m03s)
+// The portion in parentheses is absent while HandBrake is still gathering timing information.
+var encodeProgressRegex = regexp.MustCompile(`Encoding: task (\d+) of (\d+), ([\d.]+) %(?: \(([\d.]+) fps, avg ([\d.]+) fps, ETA (\d+)h(\d+)m(\d+)s\))?`)
+
+// Parses a HandBrakeCLI progress line. Returns false if the line is not a progress line.
+func parseEncodeProgress(line string) (encodeProgress, bool) {
+	var progress encodeProgress
+
+	matches := encodeProgressRegex.FindStringSubmatch(line)
+
+	if matches == nil {
+		return progress, false
+	}
+
+	task, _ := strconv.Atoi(matches[1])
+	taskCount, _ := strconv.Atoi(matches[2])
+	taskPercent, _ := strconv.ParseFloat(matches[3], 64)
+
+	// Multi-pass encodes are reported as several tasks, combine them into a single overall percentage
+	if taskCount > 0 && task > 0 {
+		progress.Percent = (float64(task-1)*100 + taskPercent) / float64(taskCount)
+	} else {
+		progress.Percent = taskPercent
+	}
+
+	if matches[4] != "" {
+		progress.FPS, _ = strconv.ParseFloat(matches[4], 64)
+		progress.AvgFPS, _ = strconv.ParseFloat(matches[5], 64)
+
+		hours, _ := strconv.Atoi(matches[6])
+		minutes, _ := strconv.Atoi(matches[7])
+		seconds, _ := strconv.Atoi(matches[8])
+
+		progress.ETA = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	}
+
+	return progress, true
+}
+
+// Encodes the title described by the params. The attempt number is recorded in the error log if encoding fails.
+func encode(ctx context.Context, params *EncodingParams, attempt int, onProgress func(encodeProgress)) error {
+	// Media servers watching the output directory must never see a half-written file
+	tempOutputPath := tempEncodePath(params.HandBrakeOutputPath)
+
 	var args []string = []string{
 		"--input", params.MKVOutputPath,
-		"--output", params.HandBrakeOutputPath,
+		"--output", tempOutputPath,
 	}
 
 	if params.Preset != "" {
@@ -72,26 +133,66 @@ func encode(ctx context.Context, params *EncodingParams) error {
 		}
 	}
 
-	cmd := exec.CommandContext(ctx, "HandBrakeCLI",
-		args...,
-	)
+	// Progress is written to stdout, everything else HandBrakeCLI logs goes to stderr
+	var output bytes.Buffer
+
+	lw := newLineWriter(func(line string) {
+		if progress, ok := parseEncodeProgress(line); ok {
+			onProgress(progress)
+		}
+	})
+
+	err := runner.Run(ctx, "HandBrakeCLI", args, lw, &output)
+
+	lw.Flush()
 
-	// Capture the combined output
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return NewExternalProcessError(fmt.Errorf("an error occurred while encoding %s - handbrakecli failure: %w", params.MKVOutputPath, err),
-			string(fmt.Sprintf("HandBrakeCLI Output\n----------------\n%s----------------\n\n", output)))
+		processErr := NewExternalProcessError(fmt.Errorf("an error occurred while encoding %s - handbrakecli failure: %w", params.MKVOutputPath, err),
+			string(fmt.Sprintf("HandBrakeCLI Output\n----------------\n%s----------------\n\n", output.String())))
+
+		// Files left by an interrupted encode are cleaned up once processing has stopped
+		if ctx.Err() == nil {
+			os.Remove(tempOutputPath)
+			logEncodeFailure(params, attempt, processErr)
+		}
+
+		return processErr
+	}
+
+	// HandBrakeCLI can exit successfully without producing usable output, for example when no video track was found
+	if size, err := getFileSize(tempOutputPath); err != nil || size == 0 {
+		os.Remove(tempOutputPath)
+
+		processErr := NewExternalProcessError(fmt.Errorf("an error occurred while encoding %s - handbrakecli did not produce an output file", params.MKVOutputPath),
+			string(fmt.Sprintf("HandBrakeCLI Output\n----------------\n%s----------------\n\n", output.String())))
+
+		logEncodeFailure(params, attempt, processErr)
+
+		return processErr
+	}
+
+	if err := os.Rename(tempOutputPath, params.HandBrakeOutputPath); err != nil {
+		os.Remove(tempOutputPath)
+		return fmt.Errorf("an error occurred while moving the encoded file to %s - %w", params.HandBrakeOutputPath, err)
 	}
 
 	return nil
 }
 
+// Appends the output of a failed encode attempt to the encode log and points the error at the log.
+// The log is kept next to the raw input file so that it survives the terminal being redrawn.
+func logEncodeFailure(params *EncodingParams, attempt int, processErr *ExternalProcessError) {
+	logFilePath := filepath.Join(filepath.Dir(params.MKVOutputPath), "encode_err.log")
+
+	if appendToLogFile(logFilePath, fmt.Sprintf("%s - Attempt %d\n%s", params.MKVOutputPath, attempt, processErr.ProcessOuput)) == nil {
+		processErr.LogPath = logFilePath
+	}
+}
+
 func getPossiblePresets() ([]string, error) {
 	var presets []string
 
-	cmd := exec.Command("HandBrakeCLI", "--preset-list")
-
-	output, err := cmd.CombinedOutput()
+	output, err := runCombinedOutput(context.Background(), "HandBrakeCLI", "--preset-list")
 
 	if err != nil {
 		return presets, fmt.Errorf("handbrakecli failure: %w", err)
@@ -118,9 +219,7 @@ func getPossiblePresets() ([]string, error) {
 func getPossibleEncoders() ([]string, error) {
 	var encoders []string
 
-	cmd := exec.Command("HandBrakeCLI", "--help")
-
-	output, err := cmd.Output()
+	output, err := runOutput(context.Background(), "HandBrakeCLI", "--help")
 
 	if err != nil {
 		return encoders, fmt.Errorf("handbrakecli failure: %w", err)
@@ -156,9 +255,7 @@ func getPossibleEncoders() ([]string, error) {
 func getPossibleEncoderPresets(encoder string) ([]string, error) {
 	var qualityPresets []string
 
-	cmd := exec.Command("HandBrakeCLI", "--encoder-preset-list", encoder)
-
-	output, err := cmd.CombinedOutput()
+	output, err := runCombinedOutput(context.Background(), "HandBrakeCLI", "--encoder-preset-list", encoder)
 
 	if err != nil {
 		return qualityPresets, fmt.Errorf("handbrakecli failure: %w", err)
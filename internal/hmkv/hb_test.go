@@ -0,0 +1,185 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEncodeProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		want encodeProgress
+		ok   bool
+	}{
+		{
+			line: "Encoding: task 1 of 1, 45.23 % (87.10 fps, avg 90.20 fps, ETA 00h12m03s)",
+			want: encodeProgress{Percent: 45.23, FPS: 87.1, AvgFPS: 90.2, ETA: 12*time.Minute + 3*time.Second},
+			ok:   true,
+		},
+		{
+			line: "Encoding: task 2 of 2, 50.00 %",
+			want: encodeProgress{Percent: 75},
+			ok:   true,
+		},
+		{
+			line: "[12:00:00] starting job",
+			ok:   false,
+		},
+	}
+
+	for _, test := range tests {
+		got, ok := parseEncodeProgress(test.line)
+
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseEncodeProgress(%q) = %+v, %t, want %+v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// Returns encoding parameters for a raw file in a temporary directory.
+func testEncodingParams(t *testing.T) *EncodingParams {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "t00.mkv")
+
+	if err := os.WriteFile(input, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &EncodingParams{
+		MKVOutputPath:       input,
+		HandBrakeOutputPath: filepath.Join(dir, "encoded.mkv"),
+		Encoder:             "x264",
+		Quality:             20,
+		AudioLanguages:      []string{"eng"},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	params := testEncodingParams(t)
+	r := newFakeRunner(t, fakeEncode())
+
+	var progress []encodeProgress
+
+	err := encode(context.Background(), params, 1, func(p encodeProgress) {
+		progress = append(progress, p)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := r.callsTo("HandBrakeCLI")
+
+	if len(calls) != 1 {
+		t.Fatalf("HandBrakeCLI calls = %v, want one", calls)
+	}
+
+	args := calls[0]
+
+	if argValue(args, "--input") != params.MKVOutputPath || argValue(args, "--encoder") != "x264" || argValue(args, "--quality") != "20" || argValue(args, "--audio-lang-list") != "eng" {
+		t.Errorf("HandBrakeCLI arguments = %v, want the input, encoder, quality and audio languages", args)
+	}
+
+	// The encode is written to a temporary file which is renamed once it is complete
+	if output := argValue(args, "--output"); output == params.HandBrakeOutputPath || !isTempEncodeFile(filepath.Base(output)) {
+		t.Errorf("HandBrakeCLI output = %q, want a temporary file", output)
+	}
+
+	if _, err := os.Stat(params.HandBrakeOutputPath); err != nil {
+		t.Errorf("encoded file was not moved into place - %v", err)
+	}
+
+	if len(progress) != 2 || progress[1].Percent != 100 || progress[1].AvgFPS != 90.5 {
+		t.Errorf("progress = %+v, want both progress lines", progress)
+	}
+}
+
+func TestEncodeWithPreset(t *testing.T) {
+	params := testEncodingParams(t)
+	params.Preset = "My Preset"
+	params.PresetFile = "presets.json"
+
+	r := newFakeRunner(t, fakeEncode())
+
+	if err := encode(context.Background(), params, 1, func(encodeProgress) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	args := r.callsTo("HandBrakeCLI")[0]
+
+	if argValue(args, "--preset") != "My Preset" || argValue(args, "--preset-import-file") != "presets.json" || slices.Contains(args, "--encoder") {
+		t.Errorf("HandBrakeCLI arguments = %v, want the preset and preset file only", args)
+	}
+}
+
+func TestEncodeFailure(t *testing.T) {
+	params := testEncodingParams(t)
+	newFakeRunner(t, &fakeCommand{
+		name:   "HandBrakeCLI",
+		stderr: "x264 [error]: malloc failed\n",
+		err:    errors.New("exit status 3"),
+		effect: func(args []string) error {
+			// A partial file is left behind by the failed encode
+			return os.WriteFile(argValue(args, "--output"), []byte("partial"), 0644)
+		},
+	})
+
+	err := encode(context.Background(), params, 2, func(encodeProgress) {})
+
+	var processErr *ExternalProcessError
+
+	if !errors.As(err, &processErr) {
+		t.Fatalf("encode() error = %v, want an ExternalProcessError", err)
+	}
+
+	if !strings.Contains(processErr.ProcessOuput, "malloc failed") {
+		t.Errorf("ProcessOuput = %q, want the HandBrakeCLI output", processErr.ProcessOuput)
+	}
+
+	log, err := os.ReadFile(processErr.LogPath)
+
+	if err != nil {
+		t.Fatalf("error log was not written - %v", err)
+	}
+
+	if !strings.Contains(string(log), "Attempt 2") {
+		t.Errorf("log does not record the attempt:\n%s", log)
+	}
+
+	if _, err := os.Stat(tempEncodePath(params.HandBrakeOutputPath)); !os.IsNotExist(err) {
+		t.Errorf("the partial encode was not removed")
+	}
+
+	if _, err := os.Stat(params.HandBrakeOutputPath); !os.IsNotExist(err) {
+		t.Errorf("an encoded file exists after a failed encode")
+	}
+}
+
+func TestEncodeWithoutOutput(t *testing.T) {
+	params := testEncodingParams(t)
+	newFakeRunner(t, &fakeCommand{name: "HandBrakeCLI", stderr: "No title found.\n"})
+
+	err := encode(context.Background(), params, 1, func(encodeProgress) {})
+
+	var processErr *ExternalProcessError
+
+	if !errors.As(err, &processErr) || !strings.Contains(err.Error(), "did not produce an output file") {
+		t.Fatalf("encode() error = %v, want an error about the missing output file", err)
+	}
+
+	log, err := os.ReadFile(processErr.LogPath)
+
+	if err != nil {
+		t.Fatalf("error log was not written - %v", err)
+	}
+
+	if !strings.Contains(string(log), "Attempt 1") || !strings.Contains(string(log), "No title found.") {
+		t.Errorf("log does not record the attempt's output:\n%s", log)
+	}
+}
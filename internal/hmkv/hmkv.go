@@ -2,32 +2,95 @@ package hmkv
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
 )
 
+// Options which control a single execution of the rip and encode process.
+type ExecOptions struct {
+	// Title selections keyed by disc index. Discs without an entry use DefaultTitleSelection.
+	TitleSelections map[int]string
+	// The title selection used for discs without an entry in TitleSelections. If empty, the user is prompted for a selection.
+	DefaultTitleSelection string
+	// The number of concurrent encode workers. Overrides the configured value when greater than 0.
+	EncodeWorkers int
+	// If true, a title which fails to rip or encode is marked as failed and processing continues with the remaining titles.
+	// Enabled if either this or the configured value is true.
+	ContinueOnError bool
+	// The name of the configured profile whose settings replace those of the base configuration. Empty for none.
+	Profile string
+	// If set, a JSON summary of each run is written to it once processing completes.
+	SummaryOutput io.Writer
+	// Set by serve mode to control and observe the run of a job.
+	hooks *runHooks
+	// Set by the commands which handle interrupts themselves. Processing is cancelled once it receives a signal.
+	interrupts *interruptHandler
+}
+
+// Returned when the user enters no title selection at the prompt.
+var errSelectionCancelled = errors.New("title selection cancelled")
+
+// Returns the title selection for the given disc, or an empty string if the user should be prompted.
+func (o *ExecOptions) titleSelection(discId int) string {
+	if selection, ok := o.TitleSelections[discId]; ok {
+		return selection
+	}
+
+	return o.DefaultTitleSelection
+}
+
 // Executes the main functionality of the program.
-// Reads the configuration file, reads titles from the disc, prompts the user for which titles they want to rip,
-// and processes the selected titles.
-func Exec(discIds []int) error {
-	config, err := ReadConfig()
+// Reads the configuration file, reads titles from the disc, prompts the user for which titles they want to rip
+// (unless a title selection was provided in the options), and processes the selected titles.
+func Exec(discIds []int, options ExecOptions) error {
+	config, err := readRunConfig(&options)
 
 	if err != nil {
-		if err == ErrConfigNotFound {
-			return err
+		return err
+	}
+
+	err = createOutputDirectories(config)
+
+	if err != nil {
+		return err
+	}
+
+	printStaleTempFileCleanup(config.HBOutputDirectory)
+
+	processTitles, err := readAndSelectTitles(context.Background(), config, discIds, options)
+
+	if err != nil {
+		if err == errSelectionCancelled {
+			return nil
 		}
 
-		return fmt.Errorf("an unexpected error occurred while reading the configuration file: %w", err)
+		return err
+	}
+
+	if len(processTitles) < 1 {
+		fmt.Printf("\nNo titles to process. Exiting.\n\n")
+		return nil
 	}
 
-	// Make sure the output directories exist
-	err = os.MkdirAll(config.MKVOutputDirectory, 0740)
+	options.interrupts = handleInterrupts()
+	defer options.interrupts.stop()
+
+	return startRun(config, processTitles, options)
+}
+
+// Makes sure the configured output directories exist.
+func createOutputDirectories(config *handyMKVConfig) error {
+	err := os.MkdirAll(config.MKVOutputDirectory, 0740)
 
 	if err != nil {
 		return fmt.Errorf("an error occurred while creating the mkv output directory: %w", err)
@@ -39,73 +102,96 @@ func Exec(discIds []int) error {
 		return fmt.Errorf("an error occurred while creating the handbrake output directory: %w", err)
 	}
 
+	return nil
+}
+
+// Reads the titles from each disc, applies the title filters and selects the titles to process using the
+// title selection from the options or by prompting the user. Returns errSelectionCancelled if the user entered no selection
+// or the context was done while prompting.
+func readAndSelectTitles(ctx context.Context, config *handyMKVConfig, discIds []int, options ExecOptions) ([]TitleInfo, error) {
 	processTitles := make([]TitleInfo, 0)
 
 	for i, discId := range discIds {
 
 		fmt.Printf("Reading titles from disc %d...\n\n", discId)
 
-		titles, err := getTitles(discId)
+		titles, err := getTitles(ctx, discId)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		fmt.Printf("The following titles were read from the disc - %s\n\n", titles[0].DiscTitle)
+		fmt.Printf("The following titles were read from the disc - %s", titles[0].DiscTitle)
 
-		for _, title := range titles {
-			fmt.Printf("ID: %d, Title Name: %s, Size: %s, Length: %s\n", title.Index, title.FileName, title.FileSize, title.Length)
+		if titles[0].DiscType != "" {
+			fmt.Printf(" (%s)", titles[0].DiscType)
 		}
 
-		var titleSelections string
+		fmt.Printf("\n\n")
 
-		// Prompt the user for input
-		fmt.Print("\nEnter the IDs of the titles to process (0,1,2...) or enter 'all' to process all titles: \n\n")
-		fmt.Scanln(&titleSelections)
+		titles, hiddenTitles, err := config.TitleFilters.apply(titles)
 
-		// Remove invalid characters
-		titleSelections = strings.ReplaceAll(titleSelections, " ", "")
-		titleSelections = strings.Trim(titleSelections, ",")
-		titleSelections = strings.ReplaceAll(titleSelections, "(", "")
-		titleSelections = strings.ReplaceAll(titleSelections, ")", "")
+		if err != nil {
+			return nil, err
+		}
 
-		if titleSelections == "" {
-			fmt.Printf("No title selections detected. Exiting.\n\n")
-			return nil
+		for _, title := range titles {
+			printTitleInfo(&title)
 		}
 
-		// If the user entered 'all', don't filter the titles
-		if titleSelections != "all" {
-			rawIds := strings.Split(titleSelections, ",")
-			selectedIds := make([]int, 0)
+		if len(hiddenTitles) > 0 {
+			fmt.Printf("\nThe following titles were hidden by the configured title filters:\n\n")
 
-			for _, rawIds := range rawIds {
-				id, err := strconv.Atoi(rawIds)
+			for _, hidden := range hiddenTitles {
+				fmt.Printf("ID: %d, Title Name: %s, Size: %s, Length: %s - %s\n", hidden.Title.Index, hidden.Title.FileName, hidden.Title.FileSize, hidden.Title.Length, hidden.Reason)
+			}
+		}
 
-				if err != nil {
-					fmt.Printf("\nInvalid title selection input detected.\n\n")
-					return nil
-				}
+		if len(titles) < 1 {
+			fmt.Printf("\nAll titles on disc %d were hidden by the configured title filters.\n", discId)
 
-				selectedIds = append(selectedIds, id)
+			if i < len(discIds)-1 {
+				fmt.Println()
 			}
 
-			if len(selectedIds) < 1 {
-				fmt.Printf("\nNo selected titles detected.\n\n")
-				return nil
+			continue
+		}
+
+		titleSelections := options.titleSelection(discId)
+
+		if titleSelections != "" {
+			titles, err = selectTitles(titleSelections, titles)
+
+			if err != nil {
+				return nil, err
 			}
 
-			titles = slices.DeleteFunc(titles, func(x TitleInfo) bool {
-				for _, sd := range selectedIds {
-					if sd == x.Index {
-						return false
-					}
+			if len(titles) < 1 {
+				fmt.Printf("\nNo titles on disc %d matched the title selection '%s'.\n", discId, titleSelections)
+			} else {
+				fmt.Printf("\nSelected titles on disc %d:", discId)
+
+				for _, title := range titles {
+					fmt.Printf(" %d", title.Index)
 				}
 
-				return true
-			})
+				fmt.Println()
+			}
+		} else {
+			// Prompting would block forever if nobody is there to answer
+			if !isTerminal(os.Stdin) {
+				return nil, ErrNoTitleSelection
+			}
+
+			titles, err = promptForTitleSelection(ctx, titles)
+
+			if err != nil {
+				return nil, err
+			}
 		}
 
+		printMissingLanguageWarnings(titles, &config.EncodeConfig)
+
 		processTitles = append(processTitles, titles...)
 
 		if i < len(discIds)-1 {
@@ -113,11 +199,11 @@ func Exec(discIds []int) error {
 		}
 	}
 
-	if len(processTitles) < 1 {
-		fmt.Printf("\nNo titles to process. Exiting.\n\n")
-		return nil
-	}
+	return processTitles, nil
+}
 
+// Creates the output directories and journal for a new run and processes the titles.
+func startRun(config *handyMKVConfig, processTitles []TitleInfo, options ExecOptions) error {
 	// If there any titles that have an identical disc title to another disc, set prependDiscToSub to true for those titles
 	var discNames = make(map[string]int)
 
@@ -125,38 +211,58 @@ func Exec(discIds []int) error {
 		discNames[strings.ToLower(title.DiscTitle)]++
 	}
 
-	for _, title := range processTitles {
+	for i, title := range processTitles {
 		if discNames[strings.ToLower(title.DiscTitle)] > 1 {
-			title.SetPrependDiscToSubdirectory(true)
+			processTitles[i].SetPrependDiscToSubdirectory(true)
 		}
 	}
 
-	// Titles progress tracking
-	tracker := progressTracker{
-		statuses: make([]titleStatus, len(processTitles)),
+	err := createRunDirectories(config)
+
+	if err != nil {
+		return err
 	}
 
-	for i, title := range processTitles {
-		tracker.statuses[i] = titleStatus{
-			TitleIndex: title.Index,
-			Title:      title.FileName,
-			DiscId:     title.DiscId,
-			Ripping:    Pending,
-			Encoding:   Pending,
-		}
+	jrnl, err := newJournal(config, processTitles)
+
+	if err != nil {
+		return fmt.Errorf("an error occurred while creating the run journal: %w", err)
 	}
 
+	fmt.Println()
+
+	return process(config, jrnl, options)
+}
+
+// Creates the timestamped output directories of a new run inside the configured output directories
+// and points the configuration at them.
+func createRunDirectories(config *handyMKVConfig) error {
 	// Create output directory dirSlug with timestamp
-	dirSlug := fmt.Sprintf("handymkv_%s", time.Now().Format("2006-01-02_15-04-05"))
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	dirSlug := fmt.Sprintf("handymkv_%s", timestamp)
 
-	config.MKVOutputDirectory = filepath.Join(config.MKVOutputDirectory, dirSlug)
+	err := os.MkdirAll(config.MKVOutputDirectory, 0740)
+
+	if err != nil {
+		return fmt.Errorf("an error occurred while creating the mkv output directory: %w", err)
+	}
 
-	err = os.MkdirAll(config.MKVOutputDirectory, 0740)
+	// Runs started within the same second, such as jobs submitted to the server together, each need their own directory
+	for suffix := 2; ; suffix++ {
+		err = os.Mkdir(filepath.Join(config.MKVOutputDirectory, dirSlug), 0740)
+
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+
+		dirSlug = fmt.Sprintf("handymkv_%s_%d", timestamp, suffix)
+	}
 
 	if err != nil {
 		return fmt.Errorf("an error occurred while creating the mkv output directory: %w", err)
 	}
 
+	config.MKVOutputDirectory = filepath.Join(config.MKVOutputDirectory, dirSlug)
 	config.HBOutputDirectory = filepath.Join(config.HBOutputDirectory, dirSlug)
 
 	err = os.MkdirAll(config.HBOutputDirectory, 0740)
@@ -165,184 +271,249 @@ func Exec(discIds []int) error {
 		return fmt.Errorf("an error occurred while creating the handbrake output directory: %w", err)
 	}
 
-	fmt.Println()
+	return nil
+}
 
-	ctx, cancelProcessing := context.WithCancel(context.Background())
-	var encChannel = make(chan EncodingParams, len(processTitles))
-	var processWaitGroup sync.WaitGroup
+// Resumes an interrupted or partially failed run from the journal in its mkv output directory.
+// Titles which were already encoded are skipped and titles which were already ripped are only encoded.
+// The run directory may be a path or the name of a run directory inside the configured mkv output directory.
+func Resume(runDirectory string, options ExecOptions) error {
+	config, err := readRunConfig(&options)
 
-	processStartTime := time.Now()
+	if err != nil {
+		return err
+	}
 
-	// MKV
-	processWaitGroup.Add(1)
+	if _, err := os.Stat(runDirectory); err != nil && !filepath.IsAbs(runDirectory) {
+		runDirectory = filepath.Join(config.MKVOutputDirectory, runDirectory)
+	}
 
-	// For each disc rip the titles
-	go func() {
-		defer close(encChannel)
-		defer processWaitGroup.Done()
+	jrnl, err := readJournal(runDirectory)
 
-		var rippingWaitGroup sync.WaitGroup
+	if err != nil {
+		return err
+	}
 
-		for _, discId := range discIds {
-			var discTitles []TitleInfo
+	printStaleTempFileCleanup(config.HBOutputDirectory)
 
-			for _, title := range processTitles {
-				if title.DiscId == discId {
-					discTitles = append(discTitles, title)
-				}
-			}
+	config.MKVOutputDirectory = jrnl.MKVOutputDirectory
+	config.HBOutputDirectory = jrnl.HBOutputDirectory
 
-			if len(discTitles) < 1 {
-				continue
-			}
+	remaining := jrnl.verify(config)
 
-			// Make sure the subdirectories exists
-			os.MkdirAll(filepath.Join(config.MKVOutputDirectory, discTitles[0].Subdirectory()), 0740)
-			os.MkdirAll(filepath.Join(config.HBOutputDirectory, discTitles[0].Subdirectory()), 0740)
+	fmt.Printf("Resuming run started %s - %d of %d titles remaining.\n", jrnl.CreatedAt.Format("2006-01-02 15:04:05"), remaining, len(jrnl.Entries))
 
-			rippingWaitGroup.Add(1)
+	if remaining < 1 {
+		fmt.Printf("\nAll titles have already been processed. Encoded files are located in: %s\n\n", config.HBOutputDirectory)
+		return nil
+	}
 
-			go func() {
-				defer rippingWaitGroup.Done()
-				ripTitles(ctx, &tracker, discTitles, config, encChannel, cancelProcessing)
-			}()
-		}
+	err = checkJournalDiscs(jrnl, config)
 
-		rippingWaitGroup.Wait()
-	}()
+	if err != nil {
+		return err
+	}
 
-	// HB
-	processWaitGroup.Add(1)
+	err = jrnl.save()
 
-	go func() {
-		defer processWaitGroup.Done()
-		for {
-			select {
-			case params, ok := <-encChannel:
-				if !ok {
-					return
-				}
+	if err != nil {
+		return err
+	}
 
-				applyInProgress := func(status *titleStatus) {
-					status.Encoding = InProgress
-				}
+	fmt.Println()
 
-				tracker.applyChangeAndDisplay(params.TitleIndex, applyInProgress)
+	options.interrupts = handleInterrupts()
+	defer options.interrupts.stop()
 
-				// Make sure the input file exists
-				if _, err := os.Stat(params.MKVOutputPath); os.IsNotExist(err) {
-					tracker.setError(fmt.Errorf("encoding input file %s does not exist", params.MKVOutputPath))
-					cancelProcessing()
-					return
-				}
+	return process(config, jrnl, options)
+}
 
-				encErr := encode(ctx, &params)
+// Removes temporary encode files left behind by dead runs and tells the user if any were found.
+func printStaleTempFileCleanup(dir string) {
+	if removed := removeStaleTempFiles(dir); removed > 0 {
+		fmt.Printf("Removed %d temporary encode files left behind by earlier runs.\n\n", removed)
+	}
+}
 
-				if encErr != nil {
-					tracker.setError(encErr)
-					cancelProcessing()
-					return
-				}
+// Checks that every drive with titles left to rip holds the first disc the titles are ripped from. Any further discs of
+// a resumed session are asked for once the disc before them has been ripped.
+// Drives can be renumbered by makemkvcon when they are plugged in again or the system restarts, so the titles are first
+// pointed at the current index of the drive with their recorded device path.
+func checkJournalDiscs(jrnl *journal, config *handyMKVConfig) error {
+	if !slices.ContainsFunc(jrnl.Entries, func(entry journalEntry) bool { return entry.Ripping != Complete }) {
+		return nil
+	}
 
-				applyComplete := func(status *titleStatus) {
-					status.Encoding = Complete
-				}
+	drives, err := listDrives()
+
+	if err != nil {
+		return err
+	}
 
-				// Update progress for encoding completion
-				tracker.applyChangeAndDisplay(params.TitleIndex, applyComplete)
-			case <-ctx.Done():
-				return
+	err = resolveJournalDrives(jrnl, drives)
+
+	if err != nil {
+		return err
+	}
+
+	discNames := make(map[int]string)
+	firstDiscIds := make(map[int]int)
+	multipleDiscs := false
+
+	for _, entry := range jrnl.Entries {
+		if entry.Ripping == Complete {
+			continue
+		}
+
+		driveIndex := entry.Title.DriveIndex
+
+		if discId, ok := firstDiscIds[driveIndex]; ok && discId != entry.Title.DiscId {
+			multipleDiscs = true
+
+			if discId < entry.Title.DiscId {
+				continue
 			}
 		}
-	}()
 
-	processWaitGroup.Wait()
+		firstDiscIds[driveIndex] = entry.Title.DiscId
+		discNames[driveIndex] = entry.Title.DiscTitle
+	}
 
-	if tracker.err != nil {
-		return tracker.err
+	// Waiting for the discs to be swapped uses the watch intervals
+	if multipleDiscs {
+		if _, _, err := config.Watch.intervals(); err != nil {
+			return err
+		}
 	}
 
-	processDuration := time.Since(processStartTime).Round(time.Second)
+	for driveIndex, discName := range discNames {
+		if err := checkInsertedDisc(drives, driveIndex, discName); err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("\nOperation Complete. Time Elapsed - %s\n", formatTimeElapsedString(processDuration))
+	return nil
+}
 
-	totalSizeRaw, totalSizeEncoded, err := calculateTotalFileSizes(processTitles, config)
+// Points the titles left to rip at the current index of the drive with their recorded device path.
+// The recorded index is kept for titles recorded without a device path.
+func resolveJournalDrives(jrnl *journal, drives []mkvrobot.Drive) error {
+	for i := range jrnl.Entries {
+		entry := &jrnl.Entries[i]
 
-	if err != nil {
-		fmt.Printf("An error occurred while calculating total sizes - %v\n", err)
-	}
+		if entry.Ripping == Complete || entry.Title.DevicePath == "" {
+			continue
+		}
 
-	fmt.Printf("\nTotal size of raw unencoded files - %s\n", formatSavedSpace(totalSizeRaw))
-	fmt.Printf("Total size of encoded files - %s\n", formatSavedSpace(totalSizeEncoded))
+		index, err := findDriveIndex(drives, entry.Title.DevicePath)
 
-	savedSpace := totalSizeRaw - totalSizeEncoded
-	if savedSpace > 0 {
-		fmt.Printf("Total disk space saved via encoding - %s\n", formatSavedSpace(totalSizeRaw-totalSizeEncoded))
+		if err != nil {
+			return fmt.Errorf("disc '%s' is needed to resume the run but its drive was not found - %w", entry.Title.DiscTitle, err)
+		}
+
+		entry.Title.DriveIndex = index
 	}
 
-	if config.DeleteRawMKVFiles {
-		deleteRawFiles(config)
+	return nil
+}
+
+// Checks that the named disc is inserted in the drive.
+func checkInsertedDisc(drives []mkvrobot.Drive, driveIndex int, discName string) error {
+	idx := slices.IndexFunc(drives, func(drive mkvrobot.Drive) bool {
+		return drive.Index == driveIndex
+	})
+
+	if idx < 0 || drives[idx].DiscName == "" {
+		return NewDiscError(driveIndex, fmt.Sprintf("disc '%s' is needed to resume the run but no disc is inserted", discName))
 	}
 
-	// Tell the user where the encoded files are located
-	fmt.Printf("\nEncoded files are located in: %s\n\n", config.HBOutputDirectory)
+	if drives[idx].DiscName != discName {
+		return NewDiscError(driveIndex, fmt.Sprintf("disc '%s' is needed to resume the run but '%s' is inserted", discName, drives[idx].DiscName))
+	}
 
 	return nil
 }
 
-func ripTitles(
-	ctx context.Context,
-	tracker *progressTracker,
-	processTitles []TitleInfo,
-	config *handyMKVConfig,
-	encChannel chan EncodingParams,
-	cancelProcessing context.CancelFunc) {
+// Prompts the user to select titles until a valid selection is entered.
+// Returns errSelectionCancelled if the user enters nothing or the context is done first.
+func promptForTitleSelection(ctx context.Context, titles []TitleInfo) ([]TitleInfo, error) {
+	fmt.Print("\nEnter the IDs of the titles to process (0,1,2...), ranges (0-5) or 'all'. Prefix an entry with '!' to exclude it (all,!3): \n\n")
 
-	for _, title := range processTitles {
-		applyInProgress := func(status *titleStatus) {
-			status.Ripping = InProgress
+	for {
+		input, ok := readLineContext(ctx)
+
+		if !ok {
+			return nil, errSelectionCancelled
 		}
 
-		tracker.applyChangeAndDisplay(title.Index, applyInProgress)
+		input = strings.TrimSpace(input)
 
-		var mkvOutputDirectory string = filepath.Join(config.MKVOutputDirectory, title.Subdirectory())
+		if input == "" {
+			fmt.Printf("No title selections detected. Exiting.\n\n")
+			return nil, errSelectionCancelled
+		}
 
-		ripErr := ripTitle(ctx, &title, mkvOutputDirectory)
+		selected, err := selectTitles(input, titles)
 
-		if ripErr != nil {
-			tracker.setError(ripErr)
-			cancelProcessing()
-			return
+		if err != nil {
+			fmt.Printf("\nInvalid title selection - %v. Please try again.\n\n", err)
+			continue
 		}
 
-		applyComplete := func(status *titleStatus) {
-			status.Ripping = Complete
+		if len(selected) < 1 {
+			fmt.Printf("\nThe selection did not match any titles. Please try again.\n\n")
+			continue
 		}
 
-		// Update progress for ripping completion
-		tracker.applyChangeAndDisplay(title.Index, applyComplete)
+		return selected, nil
+	}
+}
 
-		// Replace spaces with underscores for encoding run.
-		encodingOutputFileName := strings.ReplaceAll(title.FileName, " ", "_")
+// Prints the titles read from a disc along with their streams.
+func PrintTitles(titles []TitleInfo) {
+	for _, title := range titles {
+		printTitleInfo(&title)
+	}
+}
+
+// Prints a title and its streams for title selection.
+func printTitleInfo(title *TitleInfo) {
+	fmt.Printf("ID: %d, Title Name: %s, Size: %s, Length: %s, Chapters: %d", title.Index, title.FileName, title.FileSize, title.Length, title.Chapters)
 
-		if config.EncodeConfig.OutputFileFormat != "" && config.EncodeConfig.OutputFileFormat != "mkv" {
-			encodingOutputFileName = fmt.Sprintf("%s.%s", strings.TrimSuffix(encodingOutputFileName, ".mkv"), config.EncodeConfig.OutputFileFormat)
+	if title.SourceFileName != "" {
+		fmt.Printf(", Source: %s", title.SourceFileName)
+	}
+
+	fmt.Println()
+
+	for _, stream := range title.Streams {
+		fmt.Printf("    %s\n", stream.String())
+	}
+}
+
+// Prints a warning for each selected title which does not contain the audio or subtitle languages requested by the encode settings.
+func printMissingLanguageWarnings(titles []TitleInfo, params *EncodingParams) {
+	// Presets control their own track selection
+	if params.Preset != "" {
+		return
+	}
+
+	for _, title := range titles {
+		// Titles without stream information cannot be checked
+		if len(title.Streams) < 1 {
+			continue
 		}
 
-		var hbOutputDir string = filepath.Join(config.HBOutputDirectory, title.Subdirectory())
-
-		encChannel <- EncodingParams{
-			TitleIndex:          title.Index,
-			MKVOutputPath:       filepath.Join(mkvOutputDirectory, title.FileName),
-			HandBrakeOutputPath: filepath.Join(hbOutputDir, encodingOutputFileName),
-			Quality:             config.EncodeConfig.Quality,
-			Encoder:             config.EncodeConfig.Encoder,
-			EncoderPreset:       config.EncodeConfig.EncoderPreset,
-			OutputFileFormat:    config.EncodeConfig.OutputFileFormat,
-			Preset:              config.EncodeConfig.Preset,
-			PresetFile:          config.EncodeConfig.PresetFile,
-			SubtitleLanguages:   config.EncodeConfig.SubtitleLanguages,
-			AudioLanguages:      config.EncodeConfig.AudioLanguages,
+		for _, lang := range params.AudioLanguages {
+			if lang != "" && lang != "any" && !title.HasLanguage(AudioStream, lang) {
+				fmt.Printf("\nWarning: title %d (%s) has no '%s' audio track.\n", title.Index, title.FileName, lang)
+			}
+		}
+
+		for _, lang := range params.SubtitleLanguages {
+			if lang != "" && lang != "any" && !title.HasLanguage(SubtitleStream, lang) {
+				fmt.Printf("\nWarning: title %d (%s) has no '%s' subtitle track.\n", title.Index, title.FileName, lang)
+			}
 		}
 	}
 }
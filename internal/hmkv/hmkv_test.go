@@ -0,0 +1,597 @@
+package hmkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Runs Exec against disc 0 of the fake disc info, selecting every title, and returns the error and the run summary.
+func execTestRun(t *testing.T, options ExecOptions) (error, RunSummary) {
+	t.Helper()
+
+	var summaryOutput bytes.Buffer
+
+	options.DefaultTitleSelection = "all"
+	options.SummaryOutput = &summaryOutput
+
+	err := Exec([]int{0}, options)
+
+	var summary RunSummary
+
+	if jsonErr := json.Unmarshal(summaryOutput.Bytes(), &summary); jsonErr != nil {
+		t.Fatalf("the run summary is not valid JSON - %v\n%s", jsonErr, summaryOutput.String())
+	}
+
+	return err, summary
+}
+
+func TestExec(t *testing.T) {
+	useTestConfig(t, testConfig)
+	r := newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	err, summary := execTestRun(t, ExecOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Status != runCompleted || len(summary.Titles) != 2 {
+		t.Fatalf("summary = %+v, want a completed run of two titles", summary)
+	}
+
+	for _, title := range summary.Titles {
+		if title.Ripping != Complete || title.Encoding != Complete {
+			t.Errorf("title %d ripping %s, encoding %s, want both complete", title.TitleIndex, title.Ripping, title.Encoding)
+		}
+
+		if _, err := os.Stat(title.EncodedPath); err != nil {
+			t.Errorf("encoded file of title %d is missing - %v", title.TitleIndex, err)
+		}
+	}
+
+	if summary.RawSizeBytes != 2*4096 || summary.EncodedSizeBytes != 2*1024 {
+		t.Errorf("raw size %d, encoded size %d, want the sizes of the fake files", summary.RawSizeBytes, summary.EncodedSizeBytes)
+	}
+
+	if rips := r.callsTo("makemkvcon"); len(rips) != 3 {
+		t.Errorf("makemkvcon calls = %v, want an info call and two rips", rips)
+	}
+
+	if encodes := r.callsTo("HandBrakeCLI"); len(encodes) != 2 {
+		t.Errorf("HandBrakeCLI calls = %v, want two encodes", encodes)
+	}
+}
+
+// Checks that several encode workers share the titles of a run, encoding each title exactly once.
+func TestExecEncodeWorkers(t *testing.T) {
+	useTestConfig(t, testConfig)
+
+	const workers = 2
+
+	// The fake disc with two more titles
+	discInfo := strings.Replace(fakeDiscInfo, "TCOUNT:2", "TCOUNT:4", 1) +
+		`TINFO:2,27,0,"My Disc_t02.mkv"` + "\n" +
+		`TINFO:3,27,0,"My Disc_t03.mkv"` + "\n"
+
+	// The first encodes do not finish until every worker is encoding at once
+	var mutex sync.Mutex
+	var active, maxActive int
+	allBusy := make(chan struct{})
+	var allBusyOnce sync.Once
+
+	encode := fakeEncode()
+	writeEncode := encode.effect
+	encode.effect = func(args []string) error {
+		mutex.Lock()
+		active++
+		maxActive = max(maxActive, active)
+
+		if active == workers {
+			allBusyOnce.Do(func() { close(allBusy) })
+		}
+
+		mutex.Unlock()
+
+		select {
+		case <-allBusy:
+		case <-time.After(5 * time.Second):
+		}
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		return writeEncode(args)
+	}
+
+	r := newFakeRunner(t,
+		fakeInfo(discInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeRip(2, "My Disc_t02.mkv"),
+		fakeRip(3, "My Disc_t03.mkv"),
+		encode,
+	)
+
+	err, summary := execTestRun(t, ExecOptions{EncodeWorkers: workers})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Status != runCompleted || len(summary.Titles) != 4 {
+		t.Fatalf("summary = %+v, want a completed run of four titles", summary)
+	}
+
+	if maxActive != workers {
+		t.Errorf("at most %d encodes ran at once, want %d", maxActive, workers)
+	}
+
+	inputs := make(map[string]int)
+
+	for _, encode := range r.callsTo("HandBrakeCLI") {
+		inputs[argValue(encode, "--input")]++
+	}
+
+	for _, title := range summary.Titles {
+		if title.Encoding != Complete {
+			t.Errorf("title %d encoding %s, want it complete", title.TitleIndex, title.Encoding)
+		}
+	}
+
+	if len(inputs) != 4 {
+		t.Errorf("encoded files = %v, want each of the four titles", inputs)
+	}
+
+	for input, count := range inputs {
+		if count != 1 {
+			t.Errorf("%s was encoded %d times, want once", input, count)
+		}
+	}
+}
+
+func TestExecTitleNotOnDisc(t *testing.T) {
+	useTestConfig(t, testConfig)
+	r := newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	err := Exec([]int{0}, ExecOptions{TitleSelections: map[int]string{0: "99"}})
+
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Exec() = %v, want ErrInvalidInput for a title which is not on the disc", err)
+	}
+
+	if calls := r.callsTo("makemkvcon"); len(calls) != 1 {
+		t.Errorf("makemkvcon calls = %v, want only the disc read", calls)
+	}
+}
+
+func TestExecRipFailure(t *testing.T) {
+	useTestConfig(t, testConfig)
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRipFailure(0),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	err, summary := execTestRun(t, ExecOptions{})
+
+	var processErr *ExternalProcessError
+
+	if !errors.As(err, &processErr) {
+		t.Fatalf("Exec() error = %v, want the rip error", err)
+	}
+
+	if summary.Status != runFailed || summary.Error == "" {
+		t.Errorf("summary status %s, error %q, want a failed run with its error", summary.Status, summary.Error)
+	}
+
+	if len(summary.Titles) != 2 {
+		t.Fatalf("summary titles = %+v, want both titles", summary.Titles)
+	}
+
+	if failed := summary.Titles[0]; failed.Ripping != Failed || failed.Error == "" || failed.LogPath == "" {
+		t.Errorf("title 0 = %+v, want the title which stopped the run marked as failed", failed)
+	}
+}
+
+// Checks that the summary of a run stopped by a failure points at the log of the title which failed.
+func TestExecRipFailureSummaryLogPath(t *testing.T) {
+	useTestConfig(t, testConfig)
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRipFailure(0),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	_, summary := execTestRun(t, ExecOptions{})
+
+	failed := summary.Titles[0]
+
+	if filepath.Base(failed.LogPath) != "rip_err.log" {
+		t.Fatalf("log path = %q, want the rip log of the failed title", failed.LogPath)
+	}
+
+	log, err := os.ReadFile(failed.LogPath)
+
+	if err != nil || !strings.Contains(string(log), "Failed to save title") {
+		t.Errorf("log = %q, %v, want the output of the failed rip", log, err)
+	}
+}
+
+// Checks that the partial files of the titles still being processed when a failure stops the run are deleted.
+func TestExecRipFailureCleansUpPartialFiles(t *testing.T) {
+	dir := useTestConfig(t, testConfig)
+
+	// Title 0 is still encoding, having written part of its output, when the rip of title 1 fails
+	encoding := make(chan struct{})
+
+	encode := fakeEncode()
+	encode.wait = make(chan struct{})
+	encode.effect = func(args []string) error {
+		defer close(encoding)
+		return os.WriteFile(argValue(args, "--output"), make([]byte, 512), 0644)
+	}
+
+	ripFailure := fakeRipFailure(1)
+	ripFailure.effect = func(args []string) error {
+		<-encoding
+		return nil
+	}
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		ripFailure,
+		encode,
+	)
+
+	err, summary := execTestRun(t, ExecOptions{})
+
+	if err == nil || summary.Status != runFailed {
+		t.Fatalf("Exec() error = %v, status %s, want a failed run", err, summary.Status)
+	}
+
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && (isTempEncodeFile(entry.Name()) || strings.HasSuffix(path, ".partial")) {
+			t.Errorf("partial file %s was left behind", path)
+		}
+
+		return nil
+	})
+}
+
+func TestExecContinueOnError(t *testing.T) {
+	useTestConfig(t, testConfig)
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRipFailure(0),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	err, summary := execTestRun(t, ExecOptions{ContinueOnError: true})
+
+	if !errors.Is(err, ErrTitlesFailed) {
+		t.Fatalf("Exec() error = %v, want ErrTitlesFailed", err)
+	}
+
+	if summary.Status != runCompletedWithFailures || len(summary.Titles) != 2 {
+		t.Fatalf("summary = %+v, want a run completed with failures", summary)
+	}
+
+	failed, encoded := summary.Titles[0], summary.Titles[1]
+
+	if failed.Ripping != Failed || failed.Error == "" || failed.LogPath == "" {
+		t.Errorf("title 0 = %+v, want a failed rip with its error and log", failed)
+	}
+
+	if encoded.Encoding != Complete {
+		t.Errorf("title 1 = %+v, want it encoded", encoded)
+	}
+}
+
+func TestExecRetriesRip(t *testing.T) {
+	useTestConfig(t, `{
+		"encoding_params": {"encoder": "x264", "quality": 20},
+		"mkv_output_directory": "mkv",
+		"handbrake_output_directory": "hb",
+		"retry": {"rip": {"max_attempts": 2, "delay": "1ms"}}
+	}`)
+
+	failOnce := fakeRipFailure(0)
+	failOnce.times = 1
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		failOnce,
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	err, summary := execTestRun(t, ExecOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Titles[0].RipAttempts != 2 || summary.Titles[0].Encoding != Complete {
+		t.Errorf("title 0 = %+v, want it encoded after a second rip attempt", summary.Titles[0])
+	}
+}
+
+// Checks that an encode waiting to be retried reports the failed attempt, the delay and the error.
+func TestExecRetriesEncode(t *testing.T) {
+	useTestConfig(t, `{
+		"encoding_params": {"encoder": "x264", "quality": 20},
+		"mkv_output_directory": "mkv",
+		"handbrake_output_directory": "hb",
+		"retry": {"encode": {"max_attempts": 2, "delay": "1ms"}}
+	}`)
+
+	failOnce := &fakeCommand{name: "HandBrakeCLI", err: errors.New("exit status 3"), times: 1}
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		failOnce,
+		fakeEncode(),
+	)
+
+	var mutex sync.Mutex
+	var retries []string
+
+	options := ExecOptions{
+		TitleSelections: map[int]string{0: "0"},
+		hooks: &runHooks{
+			ctx: context.Background(),
+			onStatus: func(status titleStatus) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if status.Encoding == Retrying {
+					retries = append(retries, status.EncodeOperation)
+				}
+			},
+		},
+	}
+
+	if err := Exec([]int{0}, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(retries) != 1 || !strings.HasPrefix(retries[0], "Attempt 1 failed, retrying in 1ms - ") || !strings.Contains(retries[0], "exit status 3") {
+		t.Errorf("retrying statuses = %q, want the failed attempt, the delay and the error", retries)
+	}
+}
+
+func TestResume(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, jrnl := newTestJournal(t)
+
+	// Title 0 is finished, the raw file of title 1 was cut short by a crash after its rip was recorded
+	encodeTestTitle(t, config, jrnl, 0)
+	params := ripTestTitle(t, config, jrnl, 1)
+	writeTestFile(t, params.MKVOutputPath, 100)
+
+	r := newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rips := r.callsTo("makemkvcon"); len(rips) != 2 || !slices.Contains(rips[1], "1") {
+		t.Errorf("makemkvcon calls = %v, want the disc checked and only title 1 ripped again", rips)
+	}
+
+	if encodes := r.callsTo("HandBrakeCLI"); len(encodes) != 1 || argValue(encodes[0], "--input") != params.MKVOutputPath {
+		t.Errorf("HandBrakeCLI calls = %v, want only title 1 encoded", encodes)
+	}
+
+	resumed, err := readJournal(config.MKVOutputDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range resumed.Entries {
+		if entry.Ripping != Complete || entry.Encoding != Complete {
+			t.Errorf("title %d ripping %s, encoding %s, want both complete", entry.Title.Index, entry.Ripping, entry.Encoding)
+		}
+	}
+}
+
+// Checks that a run is resumed from the drive with the recorded device path after makemkvcon has renumbered the drives.
+func TestResumeFindsRenumberedDrive(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, jrnl := newTestJournal(t)
+
+	encodeTestTitle(t, config, jrnl, 0)
+
+	// The drive at /dev/sr0 is now drive 1
+	renumbered := strings.NewReplacer(
+		`DRV:0,2,`, `DRV:1,2,`,
+		`DRV:1,256,`, `DRV:0,256,`,
+	).Replace(fakeDiscInfo)
+
+	r := newFakeRunner(t,
+		fakeInfo(renumbered),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rips := r.callsTo("makemkvcon")
+
+	if rip := rips[len(rips)-1]; !slices.Contains(rip, "mkv") || !slices.Contains(rip, "disc:1") {
+		t.Errorf("last makemkvcon call = %v, want title 1 ripped from drive 1", rip)
+	}
+
+	resumed, err := readJournal(config.MKVOutputDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if title := resumed.Entries[1].Title; title.DriveIndex != 1 || resumed.Entries[1].Encoding != Complete {
+		t.Errorf("title 1 = drive %d, encoding %s, want it encoded from drive 1", title.DriveIndex, resumed.Entries[1].Encoding)
+	}
+}
+
+func TestResumeMissingDrive(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, _ := newTestJournal(t)
+
+	newFakeRunner(t, fakeInfo(`DRV:0,2,999,12,"BD-RE","MY DISC","/dev/sr1"`+"\n"))
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); !errors.Is(err, ErrDriveNotFound) {
+		t.Errorf("Resume() without the recorded drive attached = %v, want ErrDriveNotFound", err)
+	}
+}
+
+func TestResumeEncodesMissingFile(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, jrnl := newTestJournal(t)
+
+	encodeTestTitle(t, config, jrnl, 0)
+	params := encodeTestTitle(t, config, jrnl, 1)
+	os.Remove(params.HandBrakeOutputPath)
+
+	// No disc is needed as both titles are already ripped
+	r := newFakeRunner(t, fakeEncode())
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if encodes := r.callsTo("HandBrakeCLI"); len(encodes) != 1 || argValue(encodes[0], "--input") != params.MKVOutputPath {
+		t.Errorf("HandBrakeCLI calls = %v, want only title 1 encoded again", encodes)
+	}
+
+	if _, err := os.Stat(params.HandBrakeOutputPath); err != nil {
+		t.Errorf("the encoded file of title 1 is missing - %v", err)
+	}
+}
+
+func TestResumeFinishedRun(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, jrnl := newTestJournal(t)
+
+	encodeTestTitle(t, config, jrnl, 0)
+	encodeTestTitle(t, config, jrnl, 1)
+
+	r := newFakeRunner(t)
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.calls) != 0 {
+		t.Errorf("calls = %v, want nothing run for a finished run", r.calls)
+	}
+}
+
+func TestResumeInvalidJournal(t *testing.T) {
+	useTestConfig(t, testConfig)
+	config, _ := newTestJournal(t)
+
+	if err := Resume("missing", ExecOptions{}); !errors.Is(err, ErrJournalNotFound) {
+		t.Errorf("Resume() of a run without a journal = %v, want ErrJournalNotFound", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(config.MKVOutputDirectory, journalFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err == nil || errors.Is(err, ErrJournalNotFound) {
+		t.Errorf("Resume() of a run with a corrupt journal = %v, want a parse error", err)
+	}
+}
+
+func TestCheckJournalDiscs(t *testing.T) {
+	tests := []struct {
+		name     string
+		discInfo string
+		wantErr  bool
+	}{
+		{name: "disc inserted", discInfo: fakeDiscInfo},
+		{name: "other disc inserted", discInfo: strings.Replace(fakeDiscInfo, `"MY DISC"`, `"OTHER DISC"`, 1), wantErr: true},
+		{name: "no disc inserted", discInfo: `DRV:0,0,999,0,"BD-RE HL-DT-ST BD-RE  BH16NS40 1.05","","/dev/sr0"` + "\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestConfig(t, testConfig)
+			_, jrnl := newTestJournal(t)
+
+			newFakeRunner(t, fakeInfo(test.discInfo))
+
+			err := checkJournalDiscs(jrnl, &handyMKVConfig{})
+
+			var discErr *DiscError
+
+			if test.wantErr != errors.As(err, &discErr) {
+				t.Errorf("checkJournalDiscs() = %v, want a disc error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPromptForTitleSelection(t *testing.T) {
+	titles := []TitleInfo{{Index: 0}, {Index: 1}, {Index: 2}}
+
+	t.Run("selection", func(t *testing.T) {
+		// An invalid selection is asked for again
+		useTestInput(t, "5-2", "1-2")
+
+		selected, err := promptForTitleSelection(context.Background(), titles)
+
+		if err != nil || len(selected) != 2 || selected[0].Index != 1 {
+			t.Errorf("promptForTitleSelection() = %+v, %v, want titles 1 and 2", selected, err)
+		}
+	})
+
+	t.Run("nothing entered", func(t *testing.T) {
+		useTestInput(t, "")
+
+		if _, err := promptForTitleSelection(context.Background(), titles); err != errSelectionCancelled {
+			t.Errorf("promptForTitleSelection() = %v, want errSelectionCancelled", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		// Nothing is ever entered
+		useTestInput(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := promptForTitleSelection(ctx, titles); err != errSelectionCancelled {
+			t.Errorf("promptForTitleSelection() = %v, want errSelectionCancelled once the context is done", err)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package hmkv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalFileName = "handymkv_journal.json"
+)
+
+var ErrJournalNotFound = errors.New("journal file not found")
+
+// Records the rip and encode state of every title in a run so that an interrupted run can be resumed.
+// The journal is stored in the run's mkv output directory and is rewritten whenever the state of a title changes.
+type journal struct {
+	path  string
+	mutex sync.Mutex
+	// The first error encountered while writing the journal file.
+	writeErr error
+
+	CreatedAt          time.Time      `json:"created_at"`
+	MKVOutputDirectory string         `json:"mkv_output_directory"`
+	HBOutputDirectory  string         `json:"handbrake_output_directory"`
+	Entries            []journalEntry `json:"titles"`
+}
+
+// The recorded state of a single title.
+type journalEntry struct {
+	Title                     TitleInfo   `json:"title"`
+	PrependDiscToSubdirectory bool        `json:"prepend_disc_to_subdirectory"`
+	Ripping                   statusValue `json:"ripping"`
+	Encoding                  statusValue `json:"encoding"`
+	// The size of the raw mkv file once ripping completed. Used to verify the file before trusting the journal.
+	RawFileSize int64 `json:"raw_file_size,omitempty"`
+}
+
+// Returns the title with its subdirectory settings restored.
+func (e *journalEntry) title() TitleInfo {
+	title := e.Title
+	title.SetPrependDiscToSubdirectory(e.PrependDiscToSubdirectory)
+
+	return title
+}
+
+// Creates a journal for a new run and writes it to the run's mkv output directory.
+func newJournal(config *handyMKVConfig, titles []TitleInfo) (*journal, error) {
+	j := &journal{
+		path:               filepath.Join(config.MKVOutputDirectory, journalFileName),
+		CreatedAt:          time.Now(),
+		MKVOutputDirectory: config.MKVOutputDirectory,
+		HBOutputDirectory:  config.HBOutputDirectory,
+		Entries:            make([]journalEntry, len(titles)),
+	}
+
+	for i, title := range titles {
+		j.Entries[i] = newJournalEntry(title)
+	}
+
+	if err := j.save(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Returns the entry of a title which has not been processed yet.
+func newJournalEntry(title TitleInfo) journalEntry {
+	return journalEntry{
+		Title:                     title,
+		PrependDiscToSubdirectory: title.prependDiscToSub,
+		Ripping:                   Pending,
+		Encoding:                  Pending,
+	}
+}
+
+// Reads the journal from the given run directory.
+func readJournal(runDirectory string) (*journal, error) {
+	path := filepath.Join(runDirectory, journalFileName)
+
+	fileData, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrJournalNotFound
+		}
+
+		return nil, fmt.Errorf("error reading journal file - %w", err)
+	}
+
+	var j journal
+
+	if err := json.Unmarshal(fileData, &j); err != nil {
+		return nil, fmt.Errorf("error parsing journal file - %w", err)
+	}
+
+	j.path = path
+
+	return &j, nil
+}
+
+// Applies a change to the entry of the given title and writes the journal.
+// Write errors do not stop processing, the first one is kept and can be retrieved with err.
+func (j *journal) update(discId, titleIndex int, applyChangeFunc func(*journalEntry)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for i, entry := range j.Entries {
+		if entry.Title.DiscId == discId && entry.Title.Index == titleIndex {
+			applyChangeFunc(&j.Entries[i])
+			break
+		}
+	}
+
+	if err := j.saveLocked(); err != nil && j.writeErr == nil {
+		j.writeErr = err
+	}
+}
+
+// Adds entries for titles which were added to the run after it started and writes the journal.
+func (j *journal) addEntries(titles []TitleInfo) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, title := range titles {
+		j.Entries = append(j.Entries, newJournalEntry(title))
+	}
+
+	if err := j.saveLocked(); err != nil && j.writeErr == nil {
+		j.writeErr = err
+	}
+}
+
+// Returns the first error encountered while writing the journal file.
+func (j *journal) err() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.writeErr
+}
+
+func (j *journal) save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.saveLocked()
+}
+
+// Writes the journal to a temporary file and renames it over the journal file so that
+// a crash mid-write never leaves a truncated journal behind.
+func (j *journal) saveLocked() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("error marshaling journal to JSON: %w", err)
+	}
+
+	tmpPath := j.path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("error writing journal file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("error writing journal file: %w", err)
+	}
+
+	return nil
+}
+
+// Checks the journal against the files on disk. Titles whose raw mkv file is missing or does not have the
+// recorded size are reset so they are ripped again, and titles whose encoded file is missing are reset so they are encoded again.
+// Failed and interrupted titles are reset as well. Returns the number of titles which still need processing.
+func (j *journal) verify(config *handyMKVConfig) int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	remaining := 0
+
+	for i := range j.Entries {
+		entry := &j.Entries[i]
+		title := entry.title()
+		params := encodingParamsFor(&title, config)
+
+		if entry.Encoding == Complete {
+			if _, err := os.Stat(params.HandBrakeOutputPath); err == nil {
+				continue
+			}
+		}
+
+		entry.Encoding = Pending
+
+		if entry.Ripping == Complete {
+			size, err := getFileSize(params.MKVOutputPath)
+
+			if err != nil || size != entry.RawFileSize {
+				entry.Ripping = Pending
+				entry.RawFileSize = 0
+			}
+		} else {
+			entry.Ripping = Pending
+		}
+
+		remaining++
+	}
+
+	return remaining
+}
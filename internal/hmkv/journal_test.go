@@ -0,0 +1,203 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates the directories and journal of a run of both titles on the fake disc, with neither title processed yet,
+// and returns the run's configuration and journal. The test configuration must be in use.
+func newTestJournal(t *testing.T) (*handyMKVConfig, *journal) {
+	t.Helper()
+
+	config, err := ReadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createRunDirectories(config); err != nil {
+		t.Fatal(err)
+	}
+
+	newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	titles, err := getTitlesFromDisc(context.Background(), 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jrnl, err := newJournal(config, titles)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config, jrnl
+}
+
+// Writes a file of the given size, creating its directory.
+func writeTestFile(t *testing.T, path string, size int) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0740); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Records the title as ripped in the journal and writes its raw file with the recorded size.
+func ripTestTitle(t *testing.T, config *handyMKVConfig, jrnl *journal, index int) EncodingParams {
+	t.Helper()
+
+	title := jrnl.Entries[index].title()
+	params := encodingParamsFor(&title, config)
+
+	writeTestFile(t, params.MKVOutputPath, 4096)
+
+	jrnl.update(title.DiscId, title.Index, func(entry *journalEntry) {
+		entry.Ripping = Complete
+		entry.RawFileSize = 4096
+	})
+
+	return params
+}
+
+// Records the title as ripped and encoded in the journal and writes both of its files.
+func encodeTestTitle(t *testing.T, config *handyMKVConfig, jrnl *journal, index int) EncodingParams {
+	t.Helper()
+
+	params := ripTestTitle(t, config, jrnl, index)
+
+	writeTestFile(t, params.HandBrakeOutputPath, 1024)
+
+	jrnl.update(params.DiscId, params.TitleIndex, func(entry *journalEntry) {
+		entry.Encoding = Complete
+	})
+
+	return params
+}
+
+func TestJournalVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// Brings title 0 into the state being verified. Title 1 is never processed.
+		setup        func(t *testing.T, config *handyMKVConfig, jrnl *journal)
+		wantRipping  statusValue
+		wantEncoding statusValue
+		wantRawSize  int64
+		// The number of titles left to process, including title 1.
+		wantRemaining int
+	}{
+		{
+			name: "encoded",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				encodeTestTitle(t, config, jrnl, 0)
+			},
+			wantRipping:   Complete,
+			wantEncoding:  Complete,
+			wantRawSize:   4096,
+			wantRemaining: 1,
+		},
+		{
+			name: "encoded file missing",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				params := encodeTestTitle(t, config, jrnl, 0)
+				os.Remove(params.HandBrakeOutputPath)
+			},
+			wantRipping:   Complete,
+			wantEncoding:  Pending,
+			wantRawSize:   4096,
+			wantRemaining: 2,
+		},
+		{
+			name: "encoded and raw files missing",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				params := encodeTestTitle(t, config, jrnl, 0)
+				os.Remove(params.HandBrakeOutputPath)
+				os.Remove(params.MKVOutputPath)
+			},
+			wantRipping:   Pending,
+			wantEncoding:  Pending,
+			wantRemaining: 2,
+		},
+		{
+			name: "raw file of the wrong size",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				params := ripTestTitle(t, config, jrnl, 0)
+				writeTestFile(t, params.MKVOutputPath, 100)
+			},
+			wantRipping:   Pending,
+			wantEncoding:  Pending,
+			wantRemaining: 2,
+		},
+		{
+			name: "encoding failed",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				ripTestTitle(t, config, jrnl, 0)
+				jrnl.Entries[0].Encoding = Failed
+			},
+			wantRipping:   Complete,
+			wantEncoding:  Pending,
+			wantRawSize:   4096,
+			wantRemaining: 2,
+		},
+		{
+			name: "interrupted while ripping",
+			setup: func(t *testing.T, config *handyMKVConfig, jrnl *journal) {
+				jrnl.Entries[0].Ripping = InProgress
+			},
+			wantRipping:   Pending,
+			wantEncoding:  Pending,
+			wantRemaining: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestConfig(t, testConfig)
+			config, jrnl := newTestJournal(t)
+
+			test.setup(t, config, jrnl)
+
+			remaining := jrnl.verify(config)
+			entry := jrnl.Entries[0]
+
+			if remaining != test.wantRemaining {
+				t.Errorf("verify() = %d, want %d", remaining, test.wantRemaining)
+			}
+
+			if entry.Ripping != test.wantRipping || entry.Encoding != test.wantEncoding || entry.RawFileSize != test.wantRawSize {
+				t.Errorf("title 0 ripping %s, encoding %s, raw size %d, want %s, %s, %d", entry.Ripping, entry.Encoding,
+					entry.RawFileSize, test.wantRipping, test.wantEncoding, test.wantRawSize)
+			}
+
+			if other := jrnl.Entries[1]; other.Ripping != Pending || other.Encoding != Pending {
+				t.Errorf("title 1 ripping %s, encoding %s, want it left pending", other.Ripping, other.Encoding)
+			}
+		})
+	}
+}
+
+func TestReadJournalInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := readJournal(dir); !errors.Is(err, ErrJournalNotFound) {
+		t.Errorf("readJournal() of a run without a journal = %v, want ErrJournalNotFound", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, journalFileName), []byte(`{"titles": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readJournal(dir); err == nil || errors.Is(err, ErrJournalNotFound) {
+		t.Errorf("readJournal() of a corrupt journal = %v, want a parse error", err)
+	}
+}
@@ -0,0 +1,131 @@
+package hmkv
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the JSON output")
+
+// Returns the absolute path of the golden file in testdata. Tests which use a test configuration change the working
+// directory, so the path is resolved before they do.
+func goldenJSONPath(t *testing.T, name string) string {
+	t.Helper()
+
+	path, err := filepath.Abs(filepath.Join("testdata", name+".golden.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Writes the value as JSON, replaces the given old and new string pairs and compares the result with the golden file.
+// The field names of the documents written under --output json are relied on by scripts, so a change to a golden file
+// is a breaking change to the output. Run with -update to rewrite the golden files after an intended change.
+func checkGoldenJSON(t *testing.T, goldenPath string, v any, oldnew ...string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if err := writeJSON(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.NewReplacer(oldnew...).Replace(buf.String())
+
+	if *update {
+		if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	want, err := os.ReadFile(goldenPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != string(want) {
+		t.Errorf("JSON output does not match %s\ngot:\n%s\nwant:\n%s", goldenPath, got, want)
+	}
+}
+
+func TestDrivesJSON(t *testing.T) {
+	newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	drives, err := ListDrives()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGoldenJSON(t, goldenJSONPath(t, "drives"), drives)
+}
+
+func TestTitlesJSON(t *testing.T) {
+	newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	titles, err := ReadTitles(0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGoldenJSON(t, goldenJSONPath(t, "titles"), titles)
+}
+
+func TestConfigJSON(t *testing.T) {
+	goldenPath := goldenJSONPath(t, "config")
+
+	useTestConfig(t, `{
+	"encoding_params": {"encoder": "x264", "quality": 20},
+	"mkv_output_directory": "mkv",
+	"handbrake_output_directory": "hb",
+	"serve": {"listen_address": ":8080", "token": "serve-secret"},
+	"mqtt": {"broker": "tcp://localhost:1883", "username": "handymkv", "password": "mqtt-secret"},
+	"notifications": {"webhooks": [{"url": "https://hooks.example.com/services/T000/path-secret", "headers": {"Authorization": "Bearer webhook-secret"}}]}
+}`)
+
+	config, err := ReadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGoldenJSON(t, goldenPath, config.Redacted())
+}
+
+func TestRunSummaryJSON(t *testing.T) {
+	goldenPath := goldenJSONPath(t, "summary")
+	useTestConfig(t, testConfig)
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRipFailure(1),
+		fakeEncode(),
+	)
+
+	_, summary := execTestRun(t, ExecOptions{ContinueOnError: true})
+
+	// The times of the run, and the run directory named after its start time, differ between runs
+	summary.StartedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	summary.FinishedAt = summary.StartedAt.Add(time.Minute)
+	summary.DurationSeconds = 60
+
+	for i := range summary.Titles {
+		summary.Titles[i].RipSeconds = 0
+		summary.Titles[i].EncodeSeconds = 0
+	}
+
+	checkGoldenJSON(t, goldenPath, summary, filepath.Base(summary.MKVOutputDirectory), "handymkv_2024-01-02_03-04-05")
+}
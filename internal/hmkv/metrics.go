@@ -0,0 +1,364 @@
+package hmkv
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The buckets of the rip and encode duration histograms in seconds. Titles range from short extras to feature
+// length films, which can take several hours to encode.
+var durationBuckets = []float64{60, 300, 600, 900, 1800, 2700, 3600, 5400, 7200, 10800, 14400, 21600}
+
+// The content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The kind of a metric family as written in its TYPE line.
+type metricKind string
+
+const (
+	counterMetric   metricKind = "counter"
+	gaugeMetric     metricKind = "gauge"
+	histogramMetric metricKind = "histogram"
+)
+
+// A metric and its series, one for each combination of label values.
+type metricFamily struct {
+	name       string
+	help       string
+	kind       metricKind
+	labelNames []string
+	// The upper bounds of the buckets of a histogram, in increasing order.
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// The value of a metric for one combination of label values.
+type metricSeries struct {
+	labelValues []string
+	// The value of a counter or gauge.
+	value float64
+	// The number of observations in each bucket of a histogram. Not cumulative.
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+// Creates an empty metric family.
+func newMetricFamily(name, help string, kind metricKind, labelNames ...string) *metricFamily {
+	return &metricFamily{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+}
+
+// Creates an empty histogram with the given buckets.
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *metricFamily {
+	family := newMetricFamily(name, help, histogramMetric, labelNames...)
+	family.buckets = buckets
+
+	return family
+}
+
+// Returns the series for the label values, creating it if it does not exist yet.
+func (f *metricFamily) with(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+
+	series, ok := f.series[key]
+
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+
+		if f.kind == histogramMetric {
+			series.bucketCounts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[key] = series
+	}
+
+	return series
+}
+
+// Adds the value to a counter or gauge.
+func (f *metricFamily) add(value float64, labelValues ...string) {
+	f.with(labelValues...).value += value
+}
+
+// Records an observation of a histogram.
+func (f *metricFamily) observe(value float64, labelValues ...string) {
+	series := f.with(labelValues...)
+	series.sum += value
+	series.count++
+
+	for i, bound := range f.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+			break
+		}
+	}
+}
+
+// Writes the family in the Prometheus text exposition format. Series are sorted by their label values.
+func (f *metricFamily) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		series := f.series[key]
+
+		if f.kind != histogramMetric {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labelNames, series.labelValues), formatMetricValue(series.value))
+			continue
+		}
+
+		var cumulative uint64
+
+		for i, bound := range f.buckets {
+			cumulative += series.bucketCounts[i]
+			labels := formatLabels(append(slices.Clone(f.labelNames), "le"), append(slices.Clone(series.labelValues), formatMetricValue(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, cumulative)
+		}
+
+		labels := formatLabels(append(slices.Clone(f.labelNames), "le"), append(slices.Clone(series.labelValues), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, series.count)
+
+		labels = formatLabels(f.labelNames, series.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatMetricValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, series.count)
+	}
+}
+
+// Formats label names and values as {name="value",...}. Returns an empty string if there are no labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Formats a sample value as a Go float, which the exposition format accepts.
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Collects metrics from the events of every run in the process and tracks the runs in progress for the gauges.
+type metricsCollector struct {
+	mutex sync.Mutex
+	// The runs which have started and not finished yet.
+	activeRuns map[*pipeline]struct{}
+
+	titlesRipped   *metricFamily
+	titlesEncoded  *metricFamily
+	titlesFailed   *metricFamily
+	ripDuration    *metricFamily
+	encodeDuration *metricFamily
+	bytesRead      *metricFamily
+	bytesWritten   *metricFamily
+	encodeAvgFPS   *metricFamily
+	runsFinished   *metricFamily
+}
+
+var (
+	metricsOnce sync.Once
+	runMetrics  *metricsCollector
+)
+
+// Returns the metrics collector of the process, creating it and subscribing it to run events on first use.
+// Metrics are only collected once something has asked for them, so short lived commands pay nothing.
+func processMetrics() *metricsCollector {
+	metricsOnce.Do(func() {
+		runMetrics = newMetricsCollector()
+		addEventSink(runMetrics)
+	})
+
+	return runMetrics
+}
+
+// Creates a metrics collector with no recorded values.
+func newMetricsCollector() *metricsCollector {
+	return &metricsCollector{
+		activeRuns: make(map[*pipeline]struct{}),
+		titlesRipped: newMetricFamily("handymkv_titles_ripped_total",
+			"The number of titles which were ripped.", counterMetric, "drive"),
+		titlesEncoded: newMetricFamily("handymkv_titles_encoded_total",
+			"The number of titles which were encoded.", counterMetric, "drive", "encoder"),
+		titlesFailed: newMetricFamily("handymkv_titles_failed_total",
+			"The number of titles which failed to rip or encode after every retry.", counterMetric, "drive", "encoder", "stage"),
+		ripDuration: newHistogram("handymkv_rip_duration_seconds",
+			"The time spent ripping a title, including retries.", durationBuckets, "drive"),
+		encodeDuration: newHistogram("handymkv_encode_duration_seconds",
+			"The time spent encoding a title, including retries.", durationBuckets, "drive", "encoder"),
+		bytesRead: newMetricFamily("handymkv_read_bytes_total",
+			"The size of the raw files ripped from discs.", counterMetric, "drive"),
+		bytesWritten: newMetricFamily("handymkv_written_bytes_total",
+			"The size of the encoded files.", counterMetric, "drive", "encoder"),
+		encodeAvgFPS: newMetricFamily("handymkv_last_encode_average_fps",
+			"The average encoding speed of the most recently encoded title in frames per second.", gaugeMetric, "encoder"),
+		runsFinished: newMetricFamily("handymkv_runs_finished_total",
+			"The number of runs which completed or stopped, by outcome.", counterMetric, "status"),
+	}
+}
+
+// Returns the drive label of the title. The device path is used as it stays the same between restarts,
+// unlike the index makemkvcon assigns to the drive. Without a device path the drive index is used, as in session mode
+// every disc is given its own DiscId.
+func driveLabel(title *TitleInfo) string {
+	if title.DevicePath != "" {
+		return title.DevicePath
+	}
+
+	return strconv.Itoa(title.DriveIndex)
+}
+
+// Records the event.
+func (m *metricsCollector) handleEvent(event runEvent) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	drive := driveLabel(&event.title)
+
+	switch event.kind {
+	case eventRunStarted:
+		m.activeRuns[event.run] = struct{}{}
+	case eventTitleRipped:
+		m.titlesRipped.add(1, drive)
+		m.ripDuration.observe(event.duration.Seconds(), drive)
+		m.bytesRead.add(float64(event.bytes), drive)
+	case eventTitleEncoded:
+		m.titlesEncoded.add(1, drive, event.encoder)
+		m.encodeDuration.observe(event.duration.Seconds(), drive, event.encoder)
+		m.bytesWritten.add(float64(event.bytes), drive, event.encoder)
+
+		if event.avgFPS > 0 {
+			m.encodeAvgFPS.with(event.encoder).value = event.avgFPS
+		}
+	case eventTitleFailed:
+		m.titlesFailed.add(1, drive, event.encoder, event.stage.String())
+	case eventRunFinished:
+		delete(m.activeRuns, event.run)
+		m.runsFinished.add(1, string(event.summary.Status))
+	}
+}
+
+// Writes every metric in the Prometheus text exposition format. The gauges are measured from the active runs.
+func (m *metricsCollector) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	activeRuns := newMetricFamily("handymkv_active_jobs",
+		"The number of runs which are ripping or encoding. In serve mode each job is a run.", gaugeMetric)
+	queuedTitles := newMetricFamily("handymkv_queued_titles",
+		"The number of titles waiting to be ripped or encoded.", gaugeMetric, "stage")
+	titlesInProgress := newMetricFamily("handymkv_titles_in_progress",
+		"The number of titles being ripped or encoded.", gaugeMetric, "stage")
+	encodeFPS := newMetricFamily("handymkv_encode_fps",
+		"The combined current encoding speed of the titles being encoded in frames per second.", gaugeMetric)
+
+	activeRuns.add(float64(len(m.activeRuns)))
+
+	// Report zeros rather than leaving the series out while nothing is running
+	for _, stage := range []processStage{rippingStage, encodingStage} {
+		queuedTitles.add(0, stage.String())
+		titlesInProgress.add(0, stage.String())
+	}
+
+	encodeFPS.add(0)
+
+	for run := range m.activeRuns {
+		// Ripped titles wait in the encode queue. The queue length is safe to read while the run is processing.
+		queuedTitles.add(float64(len(run.encChannel)), encodingStage.String())
+
+		run.tracker.mutex.Lock()
+
+		for _, status := range run.tracker.statuses {
+			switch {
+			case status.Ripping == Pending:
+				queuedTitles.add(1, rippingStage.String())
+			case status.Ripping == InProgress || status.Ripping == Retrying:
+				titlesInProgress.add(1, rippingStage.String())
+			case status.Encoding == InProgress || status.Encoding == Retrying:
+				titlesInProgress.add(1, encodingStage.String())
+
+				if status.Encoding == InProgress {
+					encodeFPS.add(status.EncodeFPS)
+				}
+			}
+		}
+
+		run.tracker.mutex.Unlock()
+	}
+
+	families := []*metricFamily{
+		m.titlesRipped, m.titlesEncoded, m.titlesFailed,
+		m.ripDuration, m.encodeDuration,
+		m.bytesRead, m.bytesWritten,
+		encodeFPS, m.encodeAvgFPS,
+		activeRuns, queuedTitles, titlesInProgress,
+		m.runsFinished,
+	}
+
+	for _, family := range families {
+		family.write(w)
+	}
+}
+
+// Serves the metrics of the process in the Prometheus text exposition format.
+func metricsHandler() http.Handler {
+	return processMetrics().handler()
+}
+
+// Serves the collected metrics in the Prometheus text exposition format.
+func (m *metricsCollector) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		m.write(w)
+	})
+}
+
+// Serves the metrics of the process at /metrics on the given address until the process exits.
+// Returns an error if the address cannot be listened on.
+func startMetricsServer(address string) error {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return fmt.Errorf("error listening for metrics requests on %s - %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metricsHandler())
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go server.Serve(listener)
+
+	return nil
+}
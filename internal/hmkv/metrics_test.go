@@ -0,0 +1,187 @@
+package hmkv
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Collects the metrics of the runs started during the test.
+func useTestMetrics(t *testing.T) *metricsCollector {
+	t.Helper()
+
+	m := newMetricsCollector()
+	addEventSink(m)
+
+	t.Cleanup(func() {
+		eventSinksMutex.Lock()
+		defer eventSinksMutex.Unlock()
+
+		eventSinks = slices.DeleteFunc(eventSinks, func(sink eventSink) bool { return sink == m })
+	})
+
+	return m
+}
+
+func TestMetricsAfterRun(t *testing.T) {
+	useTestConfig(t, testConfig)
+	m := useTestMetrics(t)
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRipFailure(1),
+		fakeEncode(),
+	)
+
+	execTestRun(t, ExecOptions{ContinueOnError: true})
+
+	// A rip from a drive whose stable device path needs escaping, which took long enough to land in a later bucket
+	m.handleEvent(runEvent{
+		kind:     eventTitleRipped,
+		title:    TitleInfo{DevicePath: "/dev/disk/by-id/usb-\"Odd\"\\Drive\n"},
+		duration: 90 * time.Second,
+		bytes:    2048,
+	})
+
+	ts := httptest.NewServer(m.handler())
+	defer ts.Close()
+
+	response, err := http.Get(ts.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType := response.Header.Get("Content-Type"); contentType != metricsContentType {
+		t.Errorf("Content-Type = %q, want %q", contentType, metricsContentType)
+	}
+
+	// The fake run takes a moment rather than a fixed time
+	got := regexp.MustCompile(`(_sum\{drive="/dev/sr0"[^}]*\}) \S+`).ReplaceAllString(string(body), "$1 <elapsed>")
+
+	if got != wantMetricsAfterRun {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, wantMetricsAfterRun)
+	}
+}
+
+// The metrics after the fake run, with the durations of the run masked.
+const wantMetricsAfterRun = `# HELP handymkv_titles_ripped_total The number of titles which were ripped.
+# TYPE handymkv_titles_ripped_total counter
+handymkv_titles_ripped_total{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n"} 1
+handymkv_titles_ripped_total{drive="/dev/sr0"} 1
+# HELP handymkv_titles_encoded_total The number of titles which were encoded.
+# TYPE handymkv_titles_encoded_total counter
+handymkv_titles_encoded_total{drive="/dev/sr0",encoder="x264"} 1
+# HELP handymkv_titles_failed_total The number of titles which failed to rip or encode after every retry.
+# TYPE handymkv_titles_failed_total counter
+handymkv_titles_failed_total{drive="/dev/sr0",encoder="x264",stage="ripping"} 1
+# HELP handymkv_rip_duration_seconds The time spent ripping a title, including retries.
+# TYPE handymkv_rip_duration_seconds histogram
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="60"} 0
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="300"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="900"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="1800"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="2700"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="3600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="5400"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="7200"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="10800"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="14400"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="21600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n",le="+Inf"} 1
+handymkv_rip_duration_seconds_sum{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n"} 90
+handymkv_rip_duration_seconds_count{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="60"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="300"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="900"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="1800"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="2700"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="3600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="5400"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="7200"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="10800"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="14400"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="21600"} 1
+handymkv_rip_duration_seconds_bucket{drive="/dev/sr0",le="+Inf"} 1
+handymkv_rip_duration_seconds_sum{drive="/dev/sr0"} <elapsed>
+handymkv_rip_duration_seconds_count{drive="/dev/sr0"} 1
+# HELP handymkv_encode_duration_seconds The time spent encoding a title, including retries.
+# TYPE handymkv_encode_duration_seconds histogram
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="60"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="300"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="600"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="900"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="1800"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="2700"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="3600"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="5400"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="7200"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="10800"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="14400"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="21600"} 1
+handymkv_encode_duration_seconds_bucket{drive="/dev/sr0",encoder="x264",le="+Inf"} 1
+handymkv_encode_duration_seconds_sum{drive="/dev/sr0",encoder="x264"} <elapsed>
+handymkv_encode_duration_seconds_count{drive="/dev/sr0",encoder="x264"} 1
+# HELP handymkv_read_bytes_total The size of the raw files ripped from discs.
+# TYPE handymkv_read_bytes_total counter
+handymkv_read_bytes_total{drive="/dev/disk/by-id/usb-\"Odd\"\\Drive\n"} 2048
+handymkv_read_bytes_total{drive="/dev/sr0"} 4096
+# HELP handymkv_written_bytes_total The size of the encoded files.
+# TYPE handymkv_written_bytes_total counter
+handymkv_written_bytes_total{drive="/dev/sr0",encoder="x264"} 1024
+# HELP handymkv_encode_fps The combined current encoding speed of the titles being encoded in frames per second.
+# TYPE handymkv_encode_fps gauge
+handymkv_encode_fps 0
+# HELP handymkv_last_encode_average_fps The average encoding speed of the most recently encoded title in frames per second.
+# TYPE handymkv_last_encode_average_fps gauge
+handymkv_last_encode_average_fps{encoder="x264"} 90.5
+# HELP handymkv_active_jobs The number of runs which are ripping or encoding. In serve mode each job is a run.
+# TYPE handymkv_active_jobs gauge
+handymkv_active_jobs 0
+# HELP handymkv_queued_titles The number of titles waiting to be ripped or encoded.
+# TYPE handymkv_queued_titles gauge
+handymkv_queued_titles{stage="encoding"} 0
+handymkv_queued_titles{stage="ripping"} 0
+# HELP handymkv_titles_in_progress The number of titles being ripped or encoded.
+# TYPE handymkv_titles_in_progress gauge
+handymkv_titles_in_progress{stage="encoding"} 0
+handymkv_titles_in_progress{stage="ripping"} 0
+# HELP handymkv_runs_finished_total The number of runs which completed or stopped, by outcome.
+# TYPE handymkv_runs_finished_total counter
+handymkv_runs_finished_total{status="completed_with_failures"} 1
+`
+
+func TestDriveLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		title TitleInfo
+		want  string
+	}{
+		{name: "device path", title: TitleInfo{DiscId: 0, DriveIndex: 1, DevicePath: "/dev/sr1"}, want: "/dev/sr1"},
+		{name: "no device path", title: TitleInfo{DiscId: 1, DriveIndex: 1}, want: "1"},
+		{name: "later disc of a session", title: TitleInfo{DiscId: 3, DriveIndex: 0}, want: "0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := driveLabel(&test.title); got != test.want {
+				t.Errorf("driveLabel() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
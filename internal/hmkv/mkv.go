@@ -1,15 +1,17 @@
 package hmkv
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
 )
 
 // 2 - Disc Title
@@ -17,21 +19,160 @@ import (
 // 9 - Length of file in seconds
 // 10 - File size (GB)
 // 11 - File size (Bytes)
+// 16 - Source file name (playlist)
+// 25 - Segment count
+// 26 - Segment map
 // 27 - File name
 // 28 - Audio Short Code
 // 29 - Audio Long Code
 type TitleInfo struct {
 	// Index on disc
-	Index            int
-	DiscTitle        string
-	DiscId           int
-	Chapters         int
-	Length           string
-	FileSize         string
-	FileName         string
+	Index     int    `json:"index"`
+	DiscTitle string `json:"disc_title"`
+	DiscId    int    `json:"disc_id"`
+	// The makemkvcon index of the drive the title is ripped from. Only differs from DiscId in session mode,
+	// where every disc inserted into the drive is given its own DiscId.
+	DriveIndex int `json:"drive_index"`
+	// The type of the disc the title was read from. Example: Blu-ray disc
+	DiscType string `json:"disc_type"`
+	// The device path of the drive the title was read from. Example: /dev/sr0
+	DevicePath string `json:"device_path"`
+	Chapters   int    `json:"chapters"`
+	Length     string `json:"length"`
+	FileSize   string `json:"file_size"`
+	// The size of the title in bytes.
+	SizeBytes int64  `json:"size_bytes"`
+	FileName  string `json:"file_name"`
+	// The source playlist or file of the title on the disc. Example: 00800.mpls
+	SourceFileName string `json:"source_file_name"`
+	// The number of segments the title is made up of.
+	SegmentCount int `json:"segment_count"`
+	// The comma delimited list of segments which make up the title. Example: 1,2,3
+	SegmentMap string `json:"segment_map"`
+	// The video, audio and subtitle streams of the title.
+	Streams          []StreamInfo `json:"streams"`
 	prependDiscToSub bool
 }
 
+type StreamType string
+
+const (
+	VideoStream    StreamType = "Video"
+	AudioStream    StreamType = "Audio"
+	SubtitleStream StreamType = "Subtitles"
+)
+
+// 1 - Stream type
+// 2 - Track name
+// 3 - Language code (ISO 639-2)
+// 4 - Language name
+// 6 - Codec (short)
+// 7 - Codec (long)
+// 14 - Audio channel count
+// 19 - Video resolution
+// 21 - Video frame rate
+// 40 - Audio channel layout
+type StreamInfo struct {
+	// Index of the stream within the title
+	Index         int        `json:"index"`
+	Type          StreamType `json:"type"`
+	Name          string     `json:"name"`
+	LangCode      string     `json:"lang_code"`
+	LangName      string     `json:"lang_name"`
+	Codec         string     `json:"codec"`
+	CodecLong     string     `json:"codec_long"`
+	Channels      int        `json:"channels"`
+	ChannelLayout string     `json:"channel_layout"`
+	Resolution    string     `json:"resolution"`
+	FrameRate     string     `json:"frame_rate"`
+}
+
+// Returns a short human readable description of the stream.
+func (s *StreamInfo) String() string {
+	parts := make([]string, 0, 5)
+
+	if s.CodecLong != "" {
+		parts = append(parts, s.CodecLong)
+	} else if s.Codec != "" {
+		parts = append(parts, s.Codec)
+	}
+
+	switch s.Type {
+	case VideoStream:
+		if s.Resolution != "" {
+			parts = append(parts, s.Resolution)
+		}
+
+		if s.FrameRate != "" {
+			parts = append(parts, s.FrameRate)
+		}
+	case AudioStream:
+		if s.LangName != "" {
+			parts = append(parts, s.LangName)
+		}
+
+		if s.ChannelLayout != "" {
+			parts = append(parts, s.ChannelLayout)
+		} else if s.Channels > 0 {
+			parts = append(parts, fmt.Sprintf("%dch", s.Channels))
+		}
+	case SubtitleStream:
+		if s.LangName != "" {
+			parts = append(parts, s.LangName)
+		}
+	}
+
+	if s.Name != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", s.Name))
+	}
+
+	return fmt.Sprintf("%s: %s", s.Type, strings.Join(parts, " "))
+}
+
+// Returns the length of the title as a duration. Returns 0 if the length is unknown or cannot be parsed.
+func (t *TitleInfo) Duration() time.Duration {
+	// Lengths are reported as h:mm:ss
+	parts := strings.Split(t.Length, ":")
+
+	var length time.Duration
+
+	for _, part := range parts {
+		value, err := strconv.Atoi(part)
+
+		if err != nil {
+			return 0
+		}
+
+		length = length*60 + time.Duration(value)
+	}
+
+	return length * time.Second
+}
+
+// Returns the streams of the given type.
+func (t *TitleInfo) StreamsOfType(streamType StreamType) []StreamInfo {
+	streams := make([]StreamInfo, 0)
+
+	for _, stream := range t.Streams {
+		if stream.Type == streamType {
+			streams = append(streams, stream)
+		}
+	}
+
+	return streams
+}
+
+// Reports whether the title has a stream of the given type in the given language.
+func (t *TitleInfo) HasLanguage(streamType StreamType, langCode string) bool {
+	for _, stream := range t.Streams {
+		if stream.Type == streamType && strings.EqualFold(stream.LangCode, langCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (t *TitleInfo) SetPrependDiscToSubdirectory(val bool) {
 	t.prependDiscToSub = val
 }
@@ -44,110 +185,226 @@ func (t *TitleInfo) Subdirectory() string {
 	return strings.ReplaceAll(t.DiscTitle, " ", "_")
 }
 
-func ripTitle(ctx context.Context, title *TitleInfo, destDir string) error {
-	cmd := exec.CommandContext(ctx, "makemkvcon", "mkv", fmt.Sprintf("disc:%d", title.DiscId), fmt.Sprintf("%d", title.Index), destDir)
+// Progress of a rip as reported by makemkvcon in robot mode.
+type ripProgress struct {
+	// The overall progress of the rip as a percentage.
+	Percent float64
+	// The label of the operation makemkvcon is currently performing.
+	Operation string
+}
 
-	cmdOut, err := cmd.Output()
-	if err != nil {
-		return err
-	}
+// Rips the title into the destination directory. The attempt number is recorded in the error log if ripping fails.
+func ripTitle(ctx context.Context, title *TitleInfo, destDir string, attempt int, onProgress func(ripProgress)) error {
+	var output strings.Builder
+	var progress ripProgress
 
-	success := strings.Contains(string(cmdOut), "Copy complete. 1 titles saved.")
+	var success bool
 
-	if !success {
-		// write cmdOut to a log file in the dest dir
-		logFilePath := filepath.Join(destDir, "rip_err.log")
-		f, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	lw := newLineWriter(func(line string) {
+		record, err := mkvrobot.Parse(line)
 
 		if err != nil {
-			return fmt.Errorf("ripping title from disc was not successful - an error occured while creating log file - %w", err)
+			output.WriteString(line)
+			output.WriteString("\n")
+			return
 		}
 
-		defer f.Close()
+		switch r := record.(type) {
+		case mkvrobot.ProgressValue:
+			progress.Percent = r.TotalPercent()
+			onProgress(progress)
+		case mkvrobot.ProgressTitle:
+			// The current operation label is more specific, only fall back to the total operation label if there is none.
+			if r.IsTotal && progress.Operation != "" {
+				return
+			}
+
+			progress.Operation = r.Name
+			onProgress(progress)
+		case mkvrobot.Message:
+			if strings.Contains(r.Text, "Copy complete. 1 titles saved.") {
+				success = true
+			}
 
-		if _, err := f.WriteString(string(cmdOut)); err != nil {
-			return fmt.Errorf("failed to write to log file: %w", err)
+			output.WriteString(r.Text)
+			output.WriteString("\n")
+		default:
+			output.WriteString(line)
+			output.WriteString("\n")
 		}
+	})
+
+	err := runner.Run(ctx, "makemkvcon", []string{"-r", "--progress=-same", "mkv", fmt.Sprintf("disc:%d", title.DriveIndex), fmt.Sprintf("%d", title.Index), destDir}, lw, nil)
+
+	lw.Flush()
 
-		return fmt.Errorf("ripping title from disc was not successful - mkv error details can be found in log file %s", logFilePath)
+	if err == nil && success {
+		return nil
 	}
 
-	return nil
+	// Processing was cancelled, there is nothing worth logging
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// write the makemkvcon output to a log file in the dest dir
+	logFilePath := filepath.Join(destDir, "rip_err.log")
+
+	if logErr := appendToLogFile(logFilePath, fmt.Sprintf("Title %d (%s) - Attempt %d\n----------------\n%s----------------\n\n", title.Index, title.FileName, attempt, output.String())); logErr != nil {
+		return fmt.Errorf("ripping title from disc was not successful - %w", logErr)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("ripping title from disc was not successful - makemkvcon failure: %w - mkv error details can be found in log file %s", err, logFilePath)
+	} else {
+		err = fmt.Errorf("ripping title from disc was not successful - mkv error details can be found in log file %s", logFilePath)
+	}
+
+	return &ExternalProcessError{
+		Err:     err,
+		LogPath: logFilePath,
+	}
 }
 
-func getTitlesFromDisc(discId int) ([]TitleInfo, error) {
+func getTitlesFromDisc(ctx context.Context, discId int) ([]TitleInfo, error) {
 	titles := make([]TitleInfo, 0)
 
 	// Run the command to get the output
-	cmdOut, err := exec.Command("makemkvcon", "-r", "info", fmt.Sprintf("disc:%d", discId)).Output()
+	cmdOut, err := runOutput(ctx, "makemkvcon", "-r", "info", fmt.Sprintf("disc:%d", discId))
 
 	if err != nil {
 		return titles, fmt.Errorf("error running command: %w", err)
 	}
 
-	// Parse the output by splitting into lines
-	lines := strings.Split(string(cmdOut), "\n")
+	records, malformed, err := mkvrobot.ParseAll(bytes.NewReader(cmdOut))
+
+	if err == nil {
+		err = malformedRecordError(malformed, "DRV", "CINFO", "TINFO", "SINFO")
+	}
+
+	if err != nil {
+		return titles, fmt.Errorf("error parsing makemkvcon output: %w", err)
+	}
 
 	// Temporary variables to hold extracted data for each title
 	titleData := make(map[int]*TitleInfo)
+	streamData := make(map[int]map[int]*StreamInfo)
 
 	var discTitle string
-
-	for _, line := range lines {
-		if discTitle == "" && strings.HasPrefix(line, fmt.Sprintf("DRV:%d,", discId)) {
-			parts := strings.Split(line, ",")
-
-			if len(parts) != 7 || parts[5] == "\"\"" {
-				continue
+	var discType string
+	var devicePath string
+
+	// Ensure the titleData map has an entry for the title index
+	getTitle := func(index int) *TitleInfo {
+		if titleData[index] == nil {
+			titleData[index] = &TitleInfo{
+				Index: index,
 			}
-
-			discTitle = strings.Trim(parts[5], "\"")
 		}
 
-		// Extract the title index (e.g., TINFO:0, TINFO:1)
-		if strings.HasPrefix(line, "TINFO:") {
-			parts := strings.SplitN(line, ",", 4)
-			if len(parts) < 4 {
-				continue
+		return titleData[index]
+	}
+
+	for _, record := range records {
+		switch r := record.(type) {
+		case mkvrobot.Drive:
+			if discTitle == "" && r.Index == discId {
+				discTitle = r.DiscName
+				devicePath = r.DevicePath
+			}
+		case mkvrobot.DiscAttribute:
+			if r.Id == 1 { // Disc Type
+				discType = r.Value
 			}
-			index, _ := strconv.Atoi(strings.TrimPrefix(parts[0], "TINFO:"))
-			code := parts[1]
-			value := strings.Trim(parts[3], "\"")
+		case mkvrobot.TitleAttribute:
+			title := getTitle(r.Title)
+
+			// Populate the relevant field based on the attribute id
+			switch r.Id {
+			case 8: // Number of Chapters
+				if chapters, err := strconv.Atoi(r.Value); err == nil {
+					title.Chapters = chapters
+				}
+			case 9: // Length
+				title.Length = r.Value
+			case 10: // File Size
+				title.FileSize = r.Value
+			case 11: // File Size (Bytes)
+				if size, err := strconv.ParseInt(r.Value, 10, 64); err == nil {
+					title.SizeBytes = size
+				}
+			case 16: // Source File Name
+				title.SourceFileName = r.Value
+			case 25: // Segment Count
+				if segments, err := strconv.Atoi(r.Value); err == nil {
+					title.SegmentCount = segments
+				}
+			case 26: // Segment Map
+				title.SegmentMap = r.Value
+			case 27: // File Name
+				title.FileName = r.Value
+			}
+		case mkvrobot.StreamAttribute:
+			getTitle(r.Title)
 
-			// Windows carriage return fix
-			if runtime.GOOS == "windows" {
-				value = strings.TrimRight(value, "\"\r")
+			if streamData[r.Title] == nil {
+				streamData[r.Title] = make(map[int]*StreamInfo)
 			}
 
-			// Ensure the titleData map has an entry for this title index
-			if titleData[index] == nil {
-				titleData[index] = &TitleInfo{
-					Index: index,
+			stream := streamData[r.Title][r.Stream]
+
+			if stream == nil {
+				stream = &StreamInfo{
+					Index: r.Stream,
 				}
+
+				streamData[r.Title][r.Stream] = stream
 			}
 
-			// Populate the relevant field based on the code
-			switch code {
-			case "8": // Number of Chapters
-				if chapters, err := strconv.Atoi(value); err == nil {
-					titleData[index].Chapters = chapters
+			switch r.Id {
+			case 1: // Stream Type
+				stream.Type = StreamType(r.Value)
+			case 2: // Track Name
+				stream.Name = r.Value
+			case 3: // Language Code
+				stream.LangCode = r.Value
+			case 4: // Language Name
+				stream.LangName = r.Value
+			case 6: // Codec (short)
+				stream.Codec = r.Value
+			case 7: // Codec (long)
+				stream.CodecLong = r.Value
+			case 14: // Audio Channel Count
+				if channels, err := strconv.Atoi(r.Value); err == nil {
+					stream.Channels = channels
 				}
-			case "9": // Length
-				titleData[index].Length = value
-			case "10": // File Size
-				titleData[index].FileSize = value
-			case "27": // File Name
-				titleData[index].FileName = value
+			case 19: // Video Resolution
+				stream.Resolution = r.Value
+			case 21: // Video Frame Rate
+				stream.FrameRate = r.Value
+			case 40: // Audio Channel Layout
+				stream.ChannelLayout = r.Value
 			}
-
-			titleData[index].prependDiscToSub = false
-			titleData[index].DiscTitle = discTitle
 		}
 	}
 
 	// Convert the map to a slice
-	for _, title := range titleData {
+	for index, title := range titleData {
 		title.DiscId = discId
+		title.DriveIndex = discId
+		title.DiscTitle = discTitle
+		title.DiscType = discType
+		title.DevicePath = devicePath
+		title.prependDiscToSub = false
+
+		for _, stream := range streamData[index] {
+			title.Streams = append(title.Streams, *stream)
+		}
+
+		sort.Slice(title.Streams, func(i, j int) bool {
+			return title.Streams[i].Index < title.Streams[j].Index
+		})
+
 		titles = append(titles, *title)
 	}
 
@@ -159,8 +416,14 @@ func getTitlesFromDisc(discId int) ([]TitleInfo, error) {
 	return titles, nil
 }
 
-func getTitles(discId int) ([]TitleInfo, error) {
-	titles, err := getTitlesFromDisc(discId)
+// Reads the titles on the disc in the drive with the given index. Configured title filters are not applied.
+func ReadTitles(discId int) ([]TitleInfo, error) {
+	return getTitles(context.Background(), discId)
+}
+
+// Reads the titles of the disc. makemkvcon is stopped if the context is done before it finishes.
+func getTitles(ctx context.Context, discId int) ([]TitleInfo, error) {
+	titles, err := getTitlesFromDisc(ctx, discId)
 
 	if err != nil {
 		return titles, fmt.Errorf("an error occurred while reading titles from disc - %w", err)
@@ -194,51 +457,117 @@ func getTitles(discId int) ([]TitleInfo, error) {
 // DRV:14,256,999,0,"","",""
 // DRV:15,256,999,0,"","",""
 
+// A drive attached to the system and the disc inserted in it, if any.
 type DiscInfo struct {
-	Index int
-	Name  string
+	// The makemkvcon index of the drive. May change when drives are attached or detached.
+	Index int `json:"index"`
+	// The name of the disc. Empty if no disc is inserted.
+	Name string `json:"disc_name"`
+	// The model of the drive. Example: BD-RE HL-DT-ST BD-RE BH16NS40 1.05
+	Model string `json:"model"`
+	// The device path of the drive. Example: /dev/sr0
+	DevicePath string `json:"device_path"`
+	// The state of the drive.
+	State mkvrobot.DriveState `json:"state"`
+	// The type of the inserted disc. Example: Blu-ray
+	MediaType string `json:"media_type"`
+	// The file systems found on the inserted disc. Example: bluray_files, aacs_files
+	Flags []string `json:"flags"`
 }
 
+// Reports whether a disc is inserted in the drive. The disc may have no name if it could not be read.
+func (d *DiscInfo) HasDisc() bool {
+	return d.State == mkvrobot.DriveInserted
+}
+
+// Lists the drives which have a disc inserted.
 func ListDiscs() ([]DiscInfo, error) {
-	cmdOut, err := exec.Command("makemkvcon", "-r", "--cache=1", "info", "disc:9999").Output()
+	drives, err := ListDrives()
 
 	if err != nil {
-		return nil, fmt.Errorf("error running command: %w", err)
+		return nil, err
 	}
 
-	// Parse the output by splitting into lines
-	lines := strings.Split(string(cmdOut), "\n")
+	discs := make([]DiscInfo, 0)
 
-	// Temporary variables to hold extracted data for each title
-	drives := make([]DiscInfo, 0)
+	for _, drive := range drives {
+		if !drive.HasDisc() {
+			continue
+		}
 
-	for _, line := range lines {
-		// Extract the title index (e.g., TINFO:0, TINFO:1)
-		if strings.HasPrefix(line, "DRV:") {
-			parts := strings.Split(line, ",")
+		discs = append(discs, drive)
+	}
 
-			if len(parts) != 7 || parts[5] == "\"\"" {
-				continue
-			}
+	return discs, nil
+}
 
-			discIndexString := parts[0]
+// Lists every drive attached to the system along with its state, whether or not a disc is inserted.
+func ListDrives() ([]DiscInfo, error) {
+	drives, err := listDrives()
 
-			discIndexString = strings.TrimPrefix(discIndexString, "DRV:")
+	if err != nil {
+		return nil, err
+	}
 
-			discIndex, err := strconv.Atoi(discIndexString)
+	infos := make([]DiscInfo, 0, len(drives))
+
+	for _, drive := range drives {
+		infos = append(infos, DiscInfo{
+			Index:      drive.Index,
+			Name:       drive.DiscName,
+			Model:      drive.DriveName,
+			DevicePath: drive.DevicePath,
+			State:      drive.State,
+			MediaType:  drive.Flags.MediaType(),
+			Flags:      drive.Flags.Names(),
+		})
+	}
 
-			if err != nil {
-				return nil, fmt.Errorf("error parsing disc index: %w", err)
-			}
+	return infos, nil
+}
+
+// Returns the DRV record of every drive attached to the system, whether or not a disc is inserted.
+func listDrives() ([]mkvrobot.Drive, error) {
+	cmdOut, err := runOutput(context.Background(), "makemkvcon", "-r", "--cache=1", "info", "disc:9999")
+
+	if err != nil {
+		return nil, fmt.Errorf("error running command: %w", err)
+	}
 
-			discName := strings.Trim(parts[5], "\"")
+	records, malformed, err := mkvrobot.ParseAll(bytes.NewReader(cmdOut))
 
-			drives = append(drives, DiscInfo{
-				Index: discIndex,
-				Name:  discName,
-			})
+	if err == nil {
+		err = malformedRecordError(malformed, "DRV")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing makemkvcon output: %w", err)
+	}
+
+	drives := make([]mkvrobot.Drive, 0)
+
+	for _, record := range records {
+		drive, ok := record.(mkvrobot.Drive)
+
+		// makemkvcon reports a fixed number of drive slots, most of which are unused
+		if !ok || drive.State == mkvrobot.DriveNoDrive {
+			continue
 		}
+
+		drives = append(drives, drive)
 	}
 
 	return drives, nil
 }
+
+// Returns the first malformed record of one of the given kinds, or nil if there is none. Malformed records of other
+// kinds, such as messages the caller does not use, are ignored.
+func malformedRecordError(malformed []mkvrobot.MalformedRecord, kinds ...string) error {
+	for _, record := range malformed {
+		if slices.Contains(kinds, record.Kind) {
+			return record
+		}
+	}
+
+	return nil
+}
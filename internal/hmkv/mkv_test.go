@@ -0,0 +1,275 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
+)
+
+func TestGetTitlesFromDisc(t *testing.T) {
+	r := newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	titles, err := getTitlesFromDisc(context.Background(), 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := r.callsTo("makemkvcon"); len(calls) != 1 || strings.Join(calls[0], " ") != "-r info disc:0" {
+		t.Errorf("makemkvcon calls = %v, want a single info call for disc:0", calls)
+	}
+
+	want := []TitleInfo{
+		{
+			Index:          0,
+			DiscTitle:      "MY DISC",
+			DiscType:       "Blu-ray disc",
+			DevicePath:     "/dev/sr0",
+			Chapters:       24,
+			Length:         "2:01:32",
+			FileSize:       "31.6 GB",
+			SizeBytes:      33973923840,
+			FileName:       "My Disc, Feature_t00.mkv",
+			SourceFileName: "00800.mpls",
+			SegmentCount:   1,
+			SegmentMap:     "55",
+			Streams: []StreamInfo{
+				{Index: 0, Type: VideoStream, Resolution: "1920x1080", FrameRate: "23.976 (24000/1001)"},
+				{Index: 1, Type: AudioStream, LangCode: "eng", LangName: "English", Channels: 6, ChannelLayout: "5.1(side)"},
+				{Index: 2, Type: SubtitleStream, LangCode: "fre"},
+			},
+		},
+		{
+			Index:      1,
+			DiscTitle:  "MY DISC",
+			DiscType:   "Blu-ray disc",
+			DevicePath: "/dev/sr0",
+			Length:     "0:04:10",
+			FileName:   "My Disc_t01.mkv",
+		},
+	}
+
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("getTitlesFromDisc() =\n%+v\nwant\n%+v", titles, want)
+	}
+}
+
+func TestGetTitlesFromDiscOfSecondDrive(t *testing.T) {
+	newFakeRunner(t, fakeInfo(`DRV:0,2,999,12,"drive a","FIRST DISC","/dev/sr0"
+DRV:1,2,999,1,"drive b","SECOND DISC","/dev/sr1"
+CINFO:1,6206,"DVD disc"
+TINFO:0,27,0,"title_t00.mkv"
+`))
+
+	titles, err := getTitlesFromDisc(context.Background(), 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(titles) != 1 || titles[0].DiscId != 1 || titles[0].DriveIndex != 1 || titles[0].DiscTitle != "SECOND DISC" || titles[0].DevicePath != "/dev/sr1" {
+		t.Errorf("getTitlesFromDisc(context.Background(), 1) = %+v, want the title of the second drive", titles)
+	}
+}
+
+func TestGetTitlesFromDiscFailure(t *testing.T) {
+	processErr := errors.New("exit status 1")
+	newFakeRunner(t, &fakeCommand{name: "makemkvcon", args: []string{"info"}, err: processErr})
+
+	_, err := getTitlesFromDisc(context.Background(), 0)
+
+	if !errors.Is(err, processErr) {
+		t.Errorf("getTitlesFromDisc() error = %v, want the makemkvcon error", err)
+	}
+}
+
+func TestRipTitle(t *testing.T) {
+	destDir := t.TempDir()
+	r := newFakeRunner(t, fakeRip(3, "t03.mkv"))
+
+	title := &TitleInfo{Index: 3, DriveIndex: 1, FileName: "t03.mkv"}
+
+	var progress []ripProgress
+
+	err := ripTitle(context.Background(), title, destDir, 1, func(p ripProgress) {
+		progress = append(progress, p)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := r.callsTo("makemkvcon"); len(calls) != 1 || strings.Join(calls[0], " ") != "-r --progress=-same mkv disc:1 3 "+destDir {
+		t.Errorf("makemkvcon calls = %v, want a rip of title 3 from disc:1", calls)
+	}
+
+	if len(progress) == 0 {
+		t.Fatal("no progress was reported")
+	}
+
+	last := progress[len(progress)-1]
+
+	if last.Percent != 100 || last.Operation != "Saving to MKV file" {
+		t.Errorf("last progress = %+v, want 100%% of the current operation", last)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "rip_err.log")); !os.IsNotExist(err) {
+		t.Errorf("rip_err.log was written for a successful rip")
+	}
+}
+
+func TestRipTitleFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		command *fakeCommand
+	}{
+		{
+			name:    "no copy complete message",
+			command: fakeRipFailure(0),
+		},
+		{
+			name: "process error",
+			command: &fakeCommand{
+				name:   "makemkvcon",
+				args:   []string{"mkv"},
+				stdout: fakeRipOutput,
+				err:    errors.New("exit status 1"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			newFakeRunner(t, test.command)
+
+			title := &TitleInfo{Index: 0, FileName: "t00.mkv"}
+
+			err := ripTitle(context.Background(), title, destDir, 2, func(ripProgress) {})
+
+			var processErr *ExternalProcessError
+
+			if !errors.As(err, &processErr) {
+				t.Fatalf("ripTitle() error = %v, want an ExternalProcessError", err)
+			}
+
+			if processErr.LogPath != filepath.Join(destDir, "rip_err.log") {
+				t.Errorf("LogPath = %q, want rip_err.log in the destination directory", processErr.LogPath)
+			}
+
+			log, err := os.ReadFile(processErr.LogPath)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(log), "Title 0 (t00.mkv) - Attempt 2") {
+				t.Errorf("log does not record the title and attempt:\n%s", log)
+			}
+		})
+	}
+}
+
+func TestRipTitleCancelled(t *testing.T) {
+	destDir := t.TempDir()
+	newFakeRunner(t, &fakeCommand{name: "makemkvcon", args: []string{"mkv"}, wait: make(chan struct{})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ripTitle(ctx, &TitleInfo{FileName: "t00.mkv"}, destDir, 1, func(ripProgress) {})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ripTitle() error = %v, want context.Canceled", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "rip_err.log")); !os.IsNotExist(err) {
+		t.Errorf("rip_err.log was written for a cancelled rip")
+	}
+}
+
+func TestListDrives(t *testing.T) {
+	newFakeRunner(t, fakeInfo(fakeDiscInfo))
+
+	drives, err := ListDrives()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(drives) != 1 {
+		t.Fatalf("ListDrives() returned %d drives, want the attached drive only", len(drives))
+	}
+
+	if drives[0].Name != "MY DISC" || drives[0].DevicePath != "/dev/sr0" || drives[0].MediaType != "Blu-ray" {
+		t.Errorf("ListDrives() = %+v, want MY DISC in /dev/sr0", drives[0])
+	}
+}
+
+func TestListDiscs(t *testing.T) {
+	newFakeRunner(t, fakeInfo(`DRV:0,2,999,12,"BD-RE","MY DISC","/dev/sr0"
+DRV:1,2,999,0,"BD-RE","","/dev/sr1"
+DRV:2,0,999,0,"BD-RE","","/dev/sr2"
+DRV:3,3,999,0,"BD-RE","","/dev/sr3"
+`))
+
+	discs, err := ListDiscs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexes := make([]int, 0, len(discs))
+
+	for _, disc := range discs {
+		indexes = append(indexes, disc.Index)
+	}
+
+	// The disc in drive 1 could not be read, but is inserted
+	if !reflect.DeepEqual(indexes, []int{0, 1}) {
+		t.Errorf("ListDiscs() drives = %v, want the drives with a disc inserted", indexes)
+	}
+}
+
+func TestGetTitlesFromDiscIgnoresMalformedMessages(t *testing.T) {
+	output := fakeDiscInfo + "MSG:2003,0,3,\"Error 'Scsi error' occurred while reading '/dev/sr0' at offset '1048576\n"
+	newFakeRunner(t, fakeInfo(output))
+
+	titles, err := getTitlesFromDisc(context.Background(), 0)
+
+	if err != nil {
+		t.Fatalf("getTitlesFromDisc() error = %v, want the malformed message to be ignored", err)
+	}
+
+	if len(titles) != 2 {
+		t.Errorf("getTitlesFromDisc() returned %d titles, want 2", len(titles))
+	}
+}
+
+func TestGetTitlesFromDiscMalformedTitle(t *testing.T) {
+	newFakeRunner(t, fakeInfo(fakeDiscInfo+"TINFO:2,27,0,\"unterminated\n"))
+
+	_, err := getTitlesFromDisc(context.Background(), 0)
+
+	var malformed mkvrobot.MalformedRecord
+
+	if !errors.As(err, &malformed) || malformed.Kind != "TINFO" {
+		t.Errorf("getTitlesFromDisc() error = %v, want the malformed TINFO record", err)
+	}
+}
+
+func TestListDrivesIgnoresMalformedMessages(t *testing.T) {
+	newFakeRunner(t, fakeInfo("MSG:abc,0,0,\"bad\"\n"+fakeDiscInfo))
+
+	drives, err := ListDrives()
+
+	if err != nil || len(drives) != 1 {
+		t.Errorf("ListDrives() = %v, %v, want the drive with the malformed message ignored", drives, err)
+	}
+}
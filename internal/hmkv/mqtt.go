@@ -0,0 +1,589 @@
+package hmkv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMQTTClientId        = "handymkv"
+	defaultMQTTTopicPrefix     = "handymkv"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTPollInterval    = 10 * time.Second
+	// Replaced with the index of the drive in the drive topic.
+	mqttDrivePlaceholder = "{drive}"
+	mqttOnline           = "online"
+	mqttOffline          = "offline"
+)
+
+// Settings for publishing state to an MQTT broker and receiving commands from it in serve mode.
+type mqttConfig struct {
+	// The broker to connect to. MQTT is disabled if empty. Example: tcp://localhost:1883 or ssl://broker:8883
+	Broker   string `json:"broker,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// The prefix of the default topics. Example: handymkv
+	TopicPrefix string `json:"topic_prefix,omitempty"`
+	// Overrides of the default topics.
+	StatusTopic   string `json:"status_topic,omitempty"`
+	DriveTopic    string `json:"drive_topic,omitempty"`
+	JobTopic      string `json:"job_topic,omitempty"`
+	ProgressTopic string `json:"progress_topic,omitempty"`
+	ResultTopic   string `json:"result_topic,omitempty"`
+	CommandTopic  string `json:"command_topic,omitempty"`
+	// If true, Home Assistant discovery messages are published so that the drives and jobs appear as a device.
+	HomeAssistantDiscovery bool `json:"home_assistant_discovery,omitempty"`
+	// The topic prefix Home Assistant reads discovery messages from. Example: homeassistant
+	DiscoveryPrefix string `json:"discovery_prefix,omitempty"`
+	// How often the drives are checked for changes. Example: 10s
+	PollInterval string `json:"poll_interval,omitempty"`
+}
+
+// The topics state is published to and commands are received from.
+type mqttTopics struct {
+	status   string
+	drive    string
+	job      string
+	progress string
+	result   string
+	command  string
+	// Where the outcome of each command is published.
+	commandResult string
+}
+
+// Checks that the MQTT settings are valid.
+func (c *mqttConfig) validate() error {
+	if c.Broker == "" {
+		return nil
+	}
+
+	if _, err := c.brokerURL(); err != nil {
+		return err
+	}
+
+	if _, err := c.pollInterval(); err != nil {
+		return err
+	}
+
+	topics := c.topics()
+
+	for _, topic := range []string{topics.status, topics.drive, topics.job, topics.progress, topics.result, topics.command} {
+		if topic == "" || strings.ContainsAny(topic, "#+") {
+			return fmt.Errorf("%w: invalid mqtt topic '%s'", ErrInvalidInput, topic)
+		}
+	}
+
+	// Without the placeholder every drive would publish its state to the same topic
+	if !strings.Contains(topics.drive, mqttDrivePlaceholder) {
+		return fmt.Errorf("%w: mqtt drive_topic '%s' must contain %s", ErrInvalidInput, topics.drive, mqttDrivePlaceholder)
+	}
+
+	return nil
+}
+
+// Parses the broker address.
+func (c *mqttConfig) brokerURL() (*url.URL, error) {
+	u, err := url.Parse(c.Broker)
+
+	if err != nil || u.Hostname() == "" || !slices.Contains([]string{"tcp", "mqtt", "ssl", "tls", "mqtts"}, u.Scheme) {
+		return nil, fmt.Errorf("%w: invalid mqtt broker '%s', expected an address such as tcp://localhost:1883", ErrInvalidInput, c.Broker)
+	}
+
+	return u, nil
+}
+
+// Parses the poll interval, using the default if unset.
+func (c *mqttConfig) pollInterval() (time.Duration, error) {
+	if c.PollInterval == "" {
+		return defaultMQTTPollInterval, nil
+	}
+
+	d, err := time.ParseDuration(c.PollInterval)
+
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: invalid mqtt poll_interval '%s'", ErrInvalidInput, c.PollInterval)
+	}
+
+	return d, nil
+}
+
+// Returns the client id, using the default if unset.
+func (c *mqttConfig) clientId() string {
+	if c.ClientId == "" {
+		return defaultMQTTClientId
+	}
+
+	return c.ClientId
+}
+
+// Returns the topics, using the defaults under the topic prefix for the ones which are not set.
+func (c *mqttConfig) topics() mqttTopics {
+	prefix := c.TopicPrefix
+
+	if prefix == "" {
+		prefix = defaultMQTTTopicPrefix
+	}
+
+	topicOrDefault := func(topic, name string) string {
+		if topic != "" {
+			return topic
+		}
+
+		return prefix + "/" + name
+	}
+
+	topics := mqttTopics{
+		status:   topicOrDefault(c.StatusTopic, "status"),
+		drive:    topicOrDefault(c.DriveTopic, "drive/"+mqttDrivePlaceholder),
+		job:      topicOrDefault(c.JobTopic, "job"),
+		progress: topicOrDefault(c.ProgressTopic, "progress"),
+		result:   topicOrDefault(c.ResultTopic, "result"),
+		command:  topicOrDefault(c.CommandTopic, "command"),
+	}
+
+	topics.commandResult = topics.command + "/result"
+
+	return topics
+}
+
+// Returns the topic of the drive with the given index.
+func (t *mqttTopics) driveTopic(index int) string {
+	return strings.ReplaceAll(t.drive, mqttDrivePlaceholder, strconv.Itoa(index))
+}
+
+// The state of a drive as published to its topic. The field names are part of the published messages
+// and must not change.
+type mqttDriveState struct {
+	DiscInfo
+	// The job using the drive. Empty if the drive is not in use.
+	JobID string `json:"job_id,omitempty"`
+}
+
+// A command received on the command topic.
+type mqttCommand struct {
+	// Returned with the result so that the sender can match the result to the command. Optional.
+	ID string `json:"id,omitempty"`
+	// Either "eject" or "start".
+	Command string `json:"command"`
+	// The drive to eject or rip from, as a drive index, device path or drive alias. Defaults to drive 0.
+	Drive string `json:"drive"`
+	// The job settings of a start command.
+	Profile         string `json:"profile"`
+	TitleSelection  string `json:"title_selection"`
+	ContinueOnError bool   `json:"continue_on_error"`
+}
+
+// The outcome of a command as published to the command result topic.
+type mqttCommandResult struct {
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	// The job started by a start command.
+	JobID string `json:"job_id,omitempty"`
+}
+
+// Publishes the state of the server's drives and jobs to an MQTT broker and runs the commands received from it.
+type mqttBridge struct {
+	server       *server
+	client       *mqttClient
+	topics       mqttTopics
+	config       mqttConfig
+	pollInterval time.Duration
+	// Signals the drive poller to check the drives straight away.
+	pollNow chan struct{}
+	// The last state published for each drive, by drive index. Only used by the drive poller.
+	publishedDrives map[int]string
+}
+
+// Creates a bridge between the server and the configured broker. The configuration must have been validated.
+func newMQTTBridge(s *server, config mqttConfig) *mqttBridge {
+	broker, _ := config.brokerURL()
+	pollInterval, _ := config.pollInterval()
+	topics := config.topics()
+
+	b := &mqttBridge{
+		server:          s,
+		topics:          topics,
+		config:          config,
+		pollInterval:    pollInterval,
+		pollNow:         make(chan struct{}, 1),
+		publishedDrives: make(map[int]string),
+	}
+
+	b.client = &mqttClient{
+		broker:        broker,
+		clientId:      config.clientId(),
+		username:      config.Username,
+		password:      config.Password,
+		willTopic:     topics.status,
+		willPayload:   []byte(mqttOffline),
+		subscriptions: []string{topics.command},
+		retained:      make(map[string][]byte),
+		onMessage: func(topic string, payload []byte) {
+			if topic == topics.command {
+				go b.handleCommand(payload)
+			}
+		},
+		onError: func(err error) {
+			timestampedLog("MQTT - %v", err)
+		},
+	}
+
+	return b
+}
+
+// Publishes state until the context is done, then marks the server as offline and disconnects. Returns a channel
+// which is closed once the bridge has disconnected.
+func (b *mqttBridge) start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	clientCtx, stopClient := context.WithCancel(context.Background())
+	clientDone := make(chan struct{})
+
+	b.client.publish(b.topics.status, []byte(mqttOnline), true)
+
+	if b.config.HomeAssistantDiscovery {
+		b.publishServerDiscovery()
+	}
+
+	go func() {
+		defer close(clientDone)
+		b.client.run(clientCtx)
+	}()
+
+	go b.pollDrives(ctx)
+
+	go func() {
+		defer close(done)
+
+		b.forwardEvents(ctx)
+
+		b.client.publish(b.topics.status, []byte(mqttOffline), true)
+		stopClient()
+		<-clientDone
+	}()
+
+	timestampedLog("Publishing state to the MQTT broker at %s", b.config.Broker)
+
+	return done
+}
+
+// Publishes job and title events from the server until the context is done. Events which are already waiting
+// when the context is done are still published, so that the final state of cancelled jobs is not lost.
+func (b *mqttBridge) forwardEvents(ctx context.Context) {
+	for {
+		sub, snapshot := b.server.subscribe("")
+
+		// The snapshot lists every job, the latest one is the current job
+		if len(snapshot) > 0 {
+			b.publishEvent(snapshot[len(snapshot)-1])
+		}
+
+	receive:
+		for {
+			select {
+			case event, ok := <-sub.events:
+				// The bridge fell too far behind and was dropped, subscribe again to get the current state
+				if !ok {
+					break receive
+				}
+
+				b.publishEvent(event)
+			case <-ctx.Done():
+				b.drainEvents(sub)
+				return
+			}
+		}
+	}
+}
+
+// Publishes the events already waiting for the subscriber and unsubscribes it.
+func (b *mqttBridge) drainEvents(sub *subscriber) {
+	// Jobs publish their final state after they have been cancelled, wait for them to finish first
+	b.server.jobsRunning.Wait()
+	b.server.unsubscribe(sub)
+
+	for event := range sub.events {
+		b.publishEvent(event)
+	}
+}
+
+// Publishes a job or title event to its topic.
+func (b *mqttBridge) publishEvent(event serverEvent) {
+	payload, err := json.Marshal(event.data)
+
+	if err != nil {
+		return
+	}
+
+	switch event.name {
+	case "job":
+		b.client.publish(b.topics.job, payload, true)
+
+		if view, ok := event.data.(jobView); ok && view.State != jobRunning {
+			b.client.publish(b.topics.result, payload, true)
+		}
+
+		// The drives of the job have changed hands
+		select {
+		case b.pollNow <- struct{}{}:
+		default:
+		}
+	case "title":
+		b.client.publish(b.topics.progress, payload, false)
+	}
+}
+
+// Publishes the state of each drive whenever it changes, checking the drives every poll interval and whenever
+// a job starts or finishes.
+func (b *mqttBridge) pollDrives(ctx context.Context) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		b.publishDrives()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.pollNow:
+		}
+	}
+}
+
+// Reads the drives and publishes the state of each drive which has changed. Drives which are no longer attached
+// have their retained state cleared.
+func (b *mqttBridge) publishDrives() {
+	drives, err := ListDrives()
+
+	if err != nil {
+		timestampedLog("MQTT - an error occurred while listing the drives - %v", err)
+		return
+	}
+
+	b.server.mutex.Lock()
+	busyDrives := make(map[int]string, len(b.server.busyDrives))
+
+	for drive, jobId := range b.server.busyDrives {
+		busyDrives[drive] = jobId
+	}
+
+	b.server.mutex.Unlock()
+
+	attached := make(map[int]struct{}, len(drives))
+
+	for _, drive := range drives {
+		attached[drive.Index] = struct{}{}
+
+		payload, err := json.Marshal(mqttDriveState{DiscInfo: drive, JobID: busyDrives[drive.Index]})
+
+		if err != nil {
+			continue
+		}
+
+		published, ok := b.publishedDrives[drive.Index]
+
+		if ok && published == string(payload) {
+			continue
+		}
+
+		if !ok && b.config.HomeAssistantDiscovery {
+			b.publishDriveDiscovery(drive)
+		}
+
+		b.client.publish(b.topics.driveTopic(drive.Index), payload, true)
+		b.publishedDrives[drive.Index] = string(payload)
+	}
+
+	for index := range b.publishedDrives {
+		if _, ok := attached[index]; !ok {
+			b.client.publish(b.topics.driveTopic(index), nil, true)
+			delete(b.publishedDrives, index)
+		}
+	}
+}
+
+// Runs a command received from the broker and publishes its outcome.
+func (b *mqttBridge) handleCommand(payload []byte) {
+	var command mqttCommand
+
+	result := mqttCommandResult{}
+
+	err := json.Unmarshal(payload, &command)
+
+	if err == nil {
+		result.ID = command.ID
+		result.Command = command.Command
+
+		if command.Drive == "" {
+			command.Drive = "0"
+		}
+
+		switch command.Command {
+		case "eject":
+			err = b.eject(command.Drive)
+		case "start":
+			var view jobView
+
+			view, err = b.server.submitJob(jobRequest{
+				Drives:          []string{command.Drive},
+				TitleSelection:  command.TitleSelection,
+				Profile:         command.Profile,
+				ContinueOnError: command.ContinueOnError,
+			})
+
+			result.JobID = view.ID
+		default:
+			err = fmt.Errorf("%w: unknown command '%s', expected 'eject' or 'start'", ErrInvalidInput, command.Command)
+		}
+	} else {
+		err = fmt.Errorf("%w: invalid command - %v", ErrInvalidInput, err)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		timestampedLog("MQTT - command '%s' failed - %v", result.Command, err)
+	} else {
+		result.OK = true
+		timestampedLog("MQTT - command '%s' for drive %s succeeded", result.Command, command.Drive)
+	}
+
+	if data, err := json.Marshal(result); err == nil {
+		b.client.publish(b.topics.commandResult, data, false)
+	}
+}
+
+// Ejects the disc in the drive unless a job is using it.
+func (b *mqttBridge) eject(driveId string) error {
+	drives, err := ResolveDrives([]string{driveId})
+
+	if err != nil {
+		return err
+	}
+
+	if len(drives) < 1 {
+		return fmt.Errorf("%w: no drive was given", ErrInvalidInput)
+	}
+
+	b.server.mutex.Lock()
+	err = b.server.checkDriveFree(drives[0])
+	b.server.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	config, err := ReadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	allDrives, err := ListDrives()
+
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(allDrives, func(drive DiscInfo) bool { return drive.Index == drives[0] })
+
+	if index < 0 {
+		return fmt.Errorf("%w: drive %d", ErrDriveNotFound, drives[0])
+	}
+
+	if err := ejectDisc(context.Background(), config.EjectCommand, allDrives[index].DevicePath); err != nil {
+		return err
+	}
+
+	select {
+	case b.pollNow <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+var unsafeDiscoveryIdChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Returns the object id used in discovery topics and unique ids, which may only contain letters, digits, _ and -.
+func (b *mqttBridge) discoveryId(suffix string) string {
+	return unsafeDiscoveryIdChars.ReplaceAllString(b.client.clientId, "_") + "_" + suffix
+}
+
+// Returns the discovery prefix, using the default if unset.
+func (b *mqttBridge) discoveryPrefix() string {
+	if b.config.DiscoveryPrefix == "" {
+		return defaultMQTTDiscoveryPrefix
+	}
+
+	return b.config.DiscoveryPrefix
+}
+
+// Publishes a Home Assistant discovery message for an entity of the device.
+func (b *mqttBridge) publishDiscovery(component, objectId string, entity map[string]any) {
+	entity["unique_id"] = objectId
+	entity["availability_topic"] = b.topics.status
+	entity["device"] = map[string]any{
+		"identifiers":  []string{b.discoveryId("device")},
+		"name":         "HandyMKV",
+		"manufacturer": "HandyMKV",
+		"model":        "Disc ripping station",
+	}
+
+	payload, err := json.Marshal(entity)
+
+	if err != nil {
+		return
+	}
+
+	b.client.publish(fmt.Sprintf("%s/%s/%s/config", b.discoveryPrefix(), component, objectId), payload, true)
+}
+
+// Publishes the discovery messages of the entities which describe the jobs.
+func (b *mqttBridge) publishServerDiscovery() {
+	b.publishDiscovery("sensor", b.discoveryId("job"), map[string]any{
+		"name":                  "Current job",
+		"state_topic":           b.topics.job,
+		"value_template":        "{{ value_json.state }}",
+		"json_attributes_topic": b.topics.job,
+		"icon":                  "mdi:disc-player",
+	})
+
+	b.publishDiscovery("sensor", b.discoveryId("result"), map[string]any{
+		"name":                  "Last run result",
+		"state_topic":           b.topics.result,
+		"value_template":        "{{ value_json.state }}",
+		"json_attributes_topic": b.topics.result,
+		"icon":                  "mdi:clipboard-check",
+	})
+}
+
+// Publishes the discovery messages of the entities of a drive: its state and a button which ejects it.
+func (b *mqttBridge) publishDriveDiscovery(drive DiscInfo) {
+	index := strconv.Itoa(drive.Index)
+	ejectPayload, _ := json.Marshal(mqttCommand{Command: "eject", Drive: index})
+
+	b.publishDiscovery("sensor", b.discoveryId("drive_"+index), map[string]any{
+		"name":                  fmt.Sprintf("Drive %s", index),
+		"state_topic":           b.topics.driveTopic(drive.Index),
+		"value_template":        "{{ value_json.state }}",
+		"json_attributes_topic": b.topics.driveTopic(drive.Index),
+		"icon":                  "mdi:disc",
+	})
+
+	b.publishDiscovery("button", b.discoveryId("eject_"+index), map[string]any{
+		"name":          fmt.Sprintf("Eject drive %s", index),
+		"command_topic": b.topics.command,
+		"payload_press": string(ejectPayload),
+		"icon":          "mdi:eject",
+	})
+}
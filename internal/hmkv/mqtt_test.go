@@ -0,0 +1,87 @@
+package hmkv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Checks that a command for a blank drive is rejected rather than crashing the bridge.
+func TestMQTTBridgeRejectsBlankDrive(t *testing.T) {
+	useTestConfig(t, testConfig)
+
+	// No command is expected to run, so any call to makemkvcon fails
+	newFakeRunner(t)
+
+	listener, client := newFakeBroker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := newMQTTBridge(newServer(ctx, testServeToken), mqttConfig{Broker: "tcp://127.0.0.1:1883"})
+	b.client = client
+
+	done := startSession(ctx, client)
+	broker := acceptFakeBrokerConn(t, listener)
+	broker.handshake()
+
+	for _, command := range []string{"eject", "start"} {
+		for _, drive := range []string{" ", "\t"} {
+			payload, _ := json.Marshal(mqttCommand{ID: "1", Command: command, Drive: drive})
+
+			b.handleCommand(payload)
+
+			_, body := broker.expect(mqttPublish)
+			topic, data, err := readMQTTString(body)
+
+			if err != nil || topic != b.topics.commandResult {
+				t.Fatalf("publish topic = %q, %v, want the command result topic", topic, err)
+			}
+
+			var result mqttCommandResult
+
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatalf("command result is not valid JSON - %v", err)
+			}
+
+			if result.OK || result.Command != command || !strings.Contains(result.Error, ErrInvalidInput.Error()) {
+				t.Errorf("%s for drive %q = %+v, want it rejected as invalid input", command, drive, result)
+			}
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMQTTConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  mqttConfig
+		wantErr bool
+	}{
+		{name: "disabled", config: mqttConfig{DriveTopic: "drives"}},
+		{name: "default topics", config: mqttConfig{Broker: "tcp://localhost:1883"}},
+		{name: "drive topic with placeholder", config: mqttConfig{Broker: "tcp://localhost:1883", DriveTopic: "home/rippers/{drive}/state"}},
+		{name: "drive topic without placeholder", config: mqttConfig{Broker: "tcp://localhost:1883", DriveTopic: "home/rippers/state"}, wantErr: true},
+		{name: "wildcard topic", config: mqttConfig{Broker: "tcp://localhost:1883", JobTopic: "handymkv/#"}, wantErr: true},
+		{name: "invalid broker", config: mqttConfig{Broker: "localhost"}, wantErr: true},
+		{name: "invalid poll interval", config: mqttConfig{Broker: "tcp://localhost:1883", PollInterval: "soon"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.validate()
+
+			if test.wantErr && !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("validate() = %v, want ErrInvalidInput", err)
+			}
+
+			if !test.wantErr && err != nil {
+				t.Errorf("validate() = %v, want no error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package hmkv
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect    byte = 1
+	mqttConnAck    byte = 2
+	mqttPublish    byte = 3
+	mqttPubAck     byte = 4
+	mqttSubscribe  byte = 8
+	mqttSubAck     byte = 9
+	mqttPingReq    byte = 12
+	mqttPingResp   byte = 13
+	mqttDisconnect byte = 14
+)
+
+// The SUBACK return code of a subscription the broker rejected.
+const mqttSubscribeFailure byte = 0x80
+
+const (
+	// How often the broker expects to hear from the client. A ping is sent after half of it passes.
+	mqttKeepAlive = 30 * time.Second
+	// How long connecting to the broker and writing a packet may take.
+	mqttWriteTimeout = 10 * time.Second
+	// The delay before reconnecting to the broker. It doubles after each failed attempt up to the maximum.
+	mqttReconnectDelay    = 2 * time.Second
+	mqttMaxReconnectDelay = time.Minute
+	// The largest packet accepted from the broker.
+	mqttMaxPacketSize = 1 << 20
+)
+
+var errMQTTConnectionClosed = errors.New("the connection to the mqtt broker is closed")
+
+// A minimal MQTT 3.1.1 client. Messages are published and received with QoS 0. The client reconnects whenever the
+// connection to the broker is lost, and republishes its retained messages once reconnected so that the broker's
+// state is restored even if the broker was restarted without persistence.
+type mqttClient struct {
+	broker   *url.URL
+	clientId string
+	username string
+	password string
+	// Published by the broker if the connection is lost without a disconnect. Retained.
+	willTopic   string
+	willPayload []byte
+	// The topics subscribed to on every connection.
+	subscriptions []string
+	// Called from the reading goroutine with each message received for a subscription.
+	onMessage func(topic string, payload []byte)
+	// Called with each connection error. The client keeps reconnecting.
+	onError func(err error)
+
+	mutex sync.Mutex
+	// The current connection. Nil while disconnected.
+	conn net.Conn
+	// The last payload of each retained topic, in the order the topics were first published.
+	retained      map[string][]byte
+	retainedOrder []string
+}
+
+// Publishes a message. Retained messages are remembered and published again after reconnecting, and an empty retained
+// payload clears the topic. Messages published while disconnected are dropped unless they are retained.
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if retain {
+		if _, ok := c.retained[topic]; !ok {
+			c.retainedOrder = append(c.retainedOrder, topic)
+		}
+
+		c.retained[topic] = payload
+	}
+
+	if c.conn == nil {
+		return
+	}
+
+	if err := c.writePacketLocked(encodeMQTTPublish(topic, payload, retain)); err != nil {
+		c.conn.Close()
+	}
+}
+
+// Connects to the broker and keeps the connection open until the context is done, then disconnects cleanly.
+func (c *mqttClient) run(ctx context.Context) {
+	delay := mqttReconnectDelay
+
+	for {
+		connected, err := c.session(ctx)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if connected {
+			delay = mqttReconnectDelay
+		}
+
+		c.onError(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, mqttMaxReconnectDelay)
+	}
+}
+
+// Connects to the broker, subscribes, republishes the retained messages and reads from the connection until it fails
+// or the context is done. Returns true if the broker accepted the connection.
+func (c *mqttClient) session(ctx context.Context) (bool, error) {
+	conn, err := c.dial(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(mqttWriteTimeout))
+
+	if _, err := conn.Write(c.encodeConnect()); err != nil {
+		return false, fmt.Errorf("error connecting to the mqtt broker - %w", err)
+	}
+
+	packetType, _, body, err := readMQTTPacket(reader)
+
+	if err != nil {
+		return false, fmt.Errorf("error connecting to the mqtt broker - %w", err)
+	}
+
+	if packetType != mqttConnAck || len(body) < 2 {
+		return false, fmt.Errorf("the mqtt broker sent an unexpected response to the connection request")
+	}
+
+	if body[1] != 0 {
+		return false, fmt.Errorf("the mqtt broker refused the connection - %s", mqttConnectError(body[1]))
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	c.mutex.Lock()
+	c.conn = conn
+
+	// The packet id of each subscription which has not been acknowledged yet
+	pending := make(map[uint16]string, len(c.subscriptions))
+
+	for i, topic := range c.subscriptions {
+		packetId := uint16(i + 1)
+		pending[packetId] = topic
+
+		if err := c.writePacketLocked(encodeMQTTSubscribe(packetId, topic)); err != nil {
+			c.conn = nil
+			c.mutex.Unlock()
+
+			return true, fmt.Errorf("error subscribing to %s - %w", topic, err)
+		}
+	}
+
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.conn = nil
+		c.mutex.Unlock()
+	}()
+
+	// Closing the connection stops the reading loop below when the context is done
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		ping := time.NewTicker(mqttKeepAlive / 2)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				c.mutex.Lock()
+				c.writePacketLocked([]byte{mqttDisconnect << 4, 0})
+				c.mutex.Unlock()
+				conn.Close()
+				return
+			case <-ping.C:
+				c.mutex.Lock()
+				c.writePacketLocked([]byte{mqttPingReq << 4, 0})
+				c.mutex.Unlock()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	if err := c.awaitSubAcks(conn, reader, pending); err != nil {
+		return true, err
+	}
+
+	c.mutex.Lock()
+
+	for _, topic := range c.retainedOrder {
+		if err := c.writePacketLocked(encodeMQTTPublish(topic, c.retained[topic], true)); err != nil {
+			c.mutex.Unlock()
+			return true, fmt.Errorf("error publishing the retained message of %s - %w", topic, err)
+		}
+	}
+
+	c.mutex.Unlock()
+
+	for {
+		// The broker answers every ping, so a silent connection is a dead one
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+
+		packetType, flags, body, err := readMQTTPacket(reader)
+
+		if err != nil {
+			return true, fmt.Errorf("lost the connection to the mqtt broker - %w", err)
+		}
+
+		if packetType == mqttPublish {
+			c.handlePublish(flags, body)
+		}
+	}
+}
+
+// Reads from the connection until the broker has acknowledged every pending subscription. Messages received meanwhile
+// are handled as usual. A subscription the broker rejects is reported through onError without ending the session,
+// as the client can still publish and the broker would reject the subscription again after reconnecting.
+func (c *mqttClient) awaitSubAcks(conn net.Conn, reader *bufio.Reader, pending map[uint16]string) error {
+	conn.SetReadDeadline(time.Now().Add(mqttWriteTimeout))
+
+	for len(pending) > 0 {
+		packetType, flags, body, err := readMQTTPacket(reader)
+
+		if err != nil {
+			return fmt.Errorf("error waiting for the mqtt broker to acknowledge the subscriptions - %w", err)
+		}
+
+		switch packetType {
+		case mqttPublish:
+			c.handlePublish(flags, body)
+		case mqttSubAck:
+			if len(body) < 3 {
+				return errors.New("the mqtt broker sent a malformed subscription acknowledgement")
+			}
+
+			packetId := binary.BigEndian.Uint16(body)
+			topic, ok := pending[packetId]
+
+			if !ok {
+				continue
+			}
+
+			delete(pending, packetId)
+
+			if body[2] == mqttSubscribeFailure {
+				c.onError(fmt.Errorf("the mqtt broker rejected the subscription to %s", topic))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Opens a network connection to the broker, using TLS for the ssl, tls and mqtts schemes.
+func (c *mqttClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqttWriteTimeout}
+
+	secure := c.broker.Scheme == "ssl" || c.broker.Scheme == "tls" || c.broker.Scheme == "mqtts"
+	address := c.broker.Host
+
+	if c.broker.Port() == "" {
+		port := "1883"
+
+		if secure {
+			port = "8883"
+		}
+
+		address = net.JoinHostPort(c.broker.Hostname(), port)
+	}
+
+	var conn net.Conn
+	var err error
+
+	if secure {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.broker.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the mqtt broker at %s - %w", address, err)
+	}
+
+	return conn, nil
+}
+
+// Handles a message received for a subscription. Subscriptions are made with QoS 0, but brokers which deliver with
+// QoS 1 anyway have the message acknowledged.
+func (c *mqttClient) handlePublish(flags byte, body []byte) {
+	topic, payload, err := readMQTTString(body)
+
+	if err != nil {
+		return
+	}
+
+	if qos := (flags >> 1) & 0x03; qos > 0 {
+		if len(payload) < 2 {
+			return
+		}
+
+		packetId := payload[:2]
+		payload = payload[2:]
+
+		if qos == 1 {
+			c.mutex.Lock()
+			c.writePacketLocked(encodeMQTTPacket(mqttPubAck<<4, packetId))
+			c.mutex.Unlock()
+		}
+	}
+
+	c.onMessage(topic, payload)
+}
+
+// Writes a packet to the current connection. Must be called with the mutex held.
+func (c *mqttClient) writePacketLocked(packet []byte) error {
+	if c.conn == nil {
+		return errMQTTConnectionClosed
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+	_, err := c.conn.Write(packet)
+
+	return err
+}
+
+// Returns the CONNECT packet. Sessions are always clean as messages are only sent with QoS 0.
+func (c *mqttClient) encodeConnect() []byte {
+	var flags byte = 0x02
+
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4)
+
+	if c.willTopic != "" {
+		flags |= 0x04 | 0x20
+	}
+
+	if c.username != "" {
+		flags |= 0x80
+
+		if c.password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive.Seconds()))
+	body = appendMQTTString(body, c.clientId)
+
+	if c.willTopic != "" {
+		body = appendMQTTString(body, c.willTopic)
+		body = appendMQTTBytes(body, c.willPayload)
+	}
+
+	if c.username != "" {
+		body = appendMQTTString(body, c.username)
+
+		if c.password != "" {
+			body = appendMQTTString(body, c.password)
+		}
+	}
+
+	return encodeMQTTPacket(mqttConnect<<4, body)
+}
+
+// Returns a PUBLISH packet with QoS 0.
+func encodeMQTTPublish(topic string, payload []byte, retain bool) []byte {
+	header := mqttPublish << 4
+
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendMQTTString(nil, topic)
+	body = append(body, payload...)
+
+	return encodeMQTTPacket(header, body)
+}
+
+// Returns a SUBSCRIBE packet for a single topic filter with QoS 0.
+func encodeMQTTSubscribe(packetId uint16, topic string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, packetId)
+	body = appendMQTTString(body, topic)
+	body = append(body, 0)
+
+	return encodeMQTTPacket(mqttSubscribe<<4|0x02, body)
+}
+
+// Returns a packet with the given first header byte and body, encoding the remaining length.
+func encodeMQTTPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+
+	for {
+		digit := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			digit |= 0x80
+		}
+
+		packet = append(packet, digit)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+// Reads a packet and returns its type, the flags of its fixed header and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length := 0
+
+	for multiplier := 1; ; multiplier *= 128 {
+		digit, err := r.ReadByte()
+
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		length += int(digit&0x7f) * multiplier
+
+		if digit&0x80 == 0 {
+			break
+		}
+
+		// The remaining length is at most four bytes long
+		if multiplier == 128*128*128 {
+			return 0, 0, nil, fmt.Errorf("malformed mqtt packet length")
+		}
+	}
+
+	if length > mqttMaxPacketSize {
+		return 0, 0, nil, fmt.Errorf("mqtt packet of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0f, body, nil
+}
+
+// Appends a length prefixed UTF-8 string.
+func appendMQTTString(b []byte, s string) []byte {
+	return appendMQTTBytes(b, []byte(s))
+}
+
+// Appends length prefixed binary data.
+func appendMQTTBytes(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// Reads a length prefixed string and returns it along with the remaining data.
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("malformed mqtt string")
+	}
+
+	length := int(binary.BigEndian.Uint16(b))
+
+	if len(b) < 2+length {
+		return "", nil, fmt.Errorf("malformed mqtt string")
+	}
+
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
+
+// Describes a CONNACK return code.
+func mqttConnectError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client id rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	default:
+		return fmt.Sprintf("return code %d", code)
+	}
+}
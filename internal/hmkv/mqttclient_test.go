@@ -0,0 +1,433 @@
+package hmkv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTTPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		length int
+		// The encoded remaining length.
+		want []byte
+	}{
+		{length: 0, want: []byte{0x00}},
+		{length: 127, want: []byte{0x7f}},
+		{length: 128, want: []byte{0x80, 0x01}},
+		{length: 16383, want: []byte{0xff, 0x7f}},
+		{length: 16384, want: []byte{0x80, 0x80, 0x01}},
+		{length: mqttMaxPacketSize, want: []byte{0x80, 0x80, 0x40}},
+	}
+
+	for _, test := range tests {
+		body := bytes.Repeat([]byte{'x'}, test.length)
+		packet := encodeMQTTPacket(mqttPublish<<4|0x01, body)
+
+		if !bytes.Equal(packet[1:1+len(test.want)], test.want) {
+			t.Errorf("remaining length of %d = %x, want %x", test.length, packet[1:1+len(test.want)], test.want)
+		}
+
+		packetType, flags, decoded, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)))
+
+		if err != nil {
+			t.Errorf("readMQTTPacket() of %d bytes error = %v", test.length, err)
+			continue
+		}
+
+		if packetType != mqttPublish || flags != 0x01 || !bytes.Equal(decoded, body) {
+			t.Errorf("readMQTTPacket() of %d bytes = %d, %x, %d bytes, want the encoded packet", test.length, packetType, flags, len(decoded))
+		}
+	}
+}
+
+func TestReadMQTTPacketErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   string
+	}{
+		{name: "five byte length", packet: []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, want: "malformed"},
+		{name: "too large", packet: []byte{0x30, 0x81, 0x80, 0x40}, want: "too large"},
+		{name: "truncated length", packet: []byte{0x30, 0x80}, want: io.EOF.Error()},
+		{name: "truncated body", packet: []byte{0x30, 0x05, 'a'}, want: io.ErrUnexpectedEOF.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(test.packet)))
+
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("readMQTTPacket(%x) error = %v, want %q", test.packet, err, test.want)
+			}
+		})
+	}
+}
+
+func TestReadMQTTString(t *testing.T) {
+	s, rest, err := readMQTTString(append(appendMQTTString(nil, "handymkv/command"), "payload"...))
+
+	if err != nil || s != "handymkv/command" || string(rest) != "payload" {
+		t.Errorf("readMQTTString() = %q, %q, %v, want the topic and the rest", s, rest, err)
+	}
+
+	for _, malformed := range [][]byte{nil, {0x00}, {0x00, 0x05, 'a'}} {
+		if _, _, err := readMQTTString(malformed); err == nil {
+			t.Errorf("readMQTTString(%x) succeeded, want an error", malformed)
+		}
+	}
+}
+
+func TestEncodeMQTTPublishAndSubscribe(t *testing.T) {
+	packetType, flags, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(encodeMQTTPublish("handymkv/status", []byte("online"), true))))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, payload, err := readMQTTString(body)
+
+	if packetType != mqttPublish || flags != 0x01 || err != nil || topic != "handymkv/status" || string(payload) != "online" {
+		t.Errorf("publish = %d, %x, %q, %q, %v, want a retained QoS 0 publish", packetType, flags, topic, payload, err)
+	}
+
+	packetType, flags, body, err = readMQTTPacket(bufio.NewReader(bytes.NewReader(encodeMQTTSubscribe(7, "handymkv/command"))))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, rest, err := readMQTTString(body[2:])
+
+	if packetType != mqttSubscribe || flags != 0x02 || binary.BigEndian.Uint16(body) != 7 || err != nil || topic != "handymkv/command" || !bytes.Equal(rest, []byte{0}) {
+		t.Errorf("subscribe = %d, %x, %x, want packet 7 subscribing to the topic with QoS 0", packetType, flags, body)
+	}
+}
+
+// A broker end of a loopback connection which reads and writes single packets, failing the test on unexpected ones.
+type fakeBrokerConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Listens on a loopback port and returns the listener and a client for it.
+func newFakeBroker(t *testing.T) (net.Listener, *mqttClient) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	broker, _ := url.Parse("tcp://" + listener.Addr().String())
+
+	client := &mqttClient{
+		broker:        broker,
+		clientId:      "handymkv-test",
+		username:      "user",
+		password:      "pass",
+		willTopic:     "handymkv/status",
+		willPayload:   []byte("offline"),
+		subscriptions: []string{"handymkv/command"},
+		retained:      make(map[string][]byte),
+		onMessage:     func(string, []byte) {},
+		onError:       func(error) {},
+	}
+
+	return listener, client
+}
+
+// Accepts the next connection from the client.
+func acceptFakeBrokerConn(t *testing.T, listener net.Listener) *fakeBrokerConn {
+	t.Helper()
+
+	conn, err := listener.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return &fakeBrokerConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Reads the next packet and checks its type. Returns its flags and body.
+func (b *fakeBrokerConn) expect(packetType byte) (byte, []byte) {
+	b.t.Helper()
+
+	gotType, flags, body, err := readMQTTPacket(b.reader)
+
+	if err != nil {
+		b.t.Fatalf("error reading packet type %d - %v", packetType, err)
+	}
+
+	if gotType != packetType {
+		b.t.Fatalf("packet type = %d, want %d", gotType, packetType)
+	}
+
+	return flags, body
+}
+
+// Reads a PUBLISH packet and checks its topic, payload and retain flag.
+func (b *fakeBrokerConn) expectPublish(topic, payload string, retain bool) {
+	b.t.Helper()
+
+	flags, body := b.expect(mqttPublish)
+	gotTopic, gotPayload, err := readMQTTString(body)
+
+	if err != nil || gotTopic != topic || string(gotPayload) != payload || (flags&0x01 == 1) != retain {
+		b.t.Errorf("publish = %q %q (flags %x), want %q %q retained %t", gotTopic, gotPayload, flags, topic, payload, retain)
+	}
+}
+
+func (b *fakeBrokerConn) write(packet []byte) {
+	b.t.Helper()
+
+	if _, err := b.conn.Write(packet); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+// Reads the CONNECT packet and accepts it, then checks and grants the subscription. Returns the body of the CONNECT
+// packet.
+func (b *fakeBrokerConn) handshake() []byte {
+	b.t.Helper()
+
+	return b.handshakeWith(0)
+}
+
+// Like handshake, but acknowledges the subscription with the given return code.
+func (b *fakeBrokerConn) handshakeWith(returnCode byte) []byte {
+	b.t.Helper()
+
+	_, connect := b.expect(mqttConnect)
+	b.write([]byte{mqttConnAck << 4, 2, 0, 0})
+
+	flags, subscribe := b.expect(mqttSubscribe)
+	topic, rest, err := readMQTTString(subscribe[2:])
+
+	if flags != 0x02 || err != nil || topic != "handymkv/command" || !bytes.Equal(rest, []byte{0}) {
+		b.t.Errorf("subscribe = %x %x, want a QoS 0 subscription to the command topic", flags, subscribe)
+	}
+
+	b.write(encodeMQTTPacket(mqttSubAck<<4, []byte{subscribe[0], subscribe[1], returnCode}))
+
+	return connect
+}
+
+// Runs a session of the client in the background. The returned channel receives the error it ends with.
+func startSession(ctx context.Context, c *mqttClient) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := c.session(ctx)
+		done <- err
+	}()
+
+	return done
+}
+
+func TestMQTTClientConnect(t *testing.T) {
+	listener, c := newFakeBroker(t)
+
+	done := startSession(context.Background(), c)
+	broker := acceptFakeBrokerConn(t, listener)
+
+	connect := broker.handshake()
+
+	protocol, rest, err := readMQTTString(connect)
+
+	if err != nil || protocol != "MQTT" || rest[0] != 4 {
+		t.Fatalf("CONNECT protocol = %q level %d, want MQTT 3.1.1", protocol, rest[0])
+	}
+
+	// Clean session, a retained will and a user name and password
+	if flags := rest[1]; flags != 0x02|0x04|0x20|0x40|0x80 {
+		t.Errorf("CONNECT flags = %08b, want a clean session with a retained will and credentials", flags)
+	}
+
+	if keepAlive := binary.BigEndian.Uint16(rest[2:]); keepAlive != uint16(mqttKeepAlive.Seconds()) {
+		t.Errorf("CONNECT keep alive = %d, want %d", keepAlive, uint16(mqttKeepAlive.Seconds()))
+	}
+
+	fields := make([]string, 0, 5)
+	payload := rest[4:]
+
+	for len(payload) > 0 {
+		var field string
+
+		if field, payload, err = readMQTTString(payload); err != nil {
+			t.Fatalf("malformed CONNECT payload - %v", err)
+		}
+
+		fields = append(fields, field)
+	}
+
+	if got := strings.Join(fields, " "); got != "handymkv-test handymkv/status offline user pass" {
+		t.Errorf("CONNECT payload = %q, want the client id, will, user name and password", got)
+	}
+
+	broker.conn.Close()
+
+	if err := <-done; err == nil {
+		t.Errorf("session() ended without an error after the connection was lost")
+	}
+}
+
+func TestMQTTClientRefused(t *testing.T) {
+	listener, c := newFakeBroker(t)
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		readMQTTPacket(bufio.NewReader(conn))
+		conn.Write([]byte{mqttConnAck << 4, 2, 0, 4})
+	}()
+
+	connected, err := c.session(context.Background())
+
+	if connected || err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("session() = %t, %v, want the refusal", connected, err)
+	}
+}
+
+// Checks that messages are received and acknowledged, and that retained messages, including those published while
+// disconnected, are republished in order once the client reconnects.
+func TestMQTTClientRepublishesRetained(t *testing.T) {
+	listener, c := newFakeBroker(t)
+
+	messages := make(chan string, 1)
+
+	c.onMessage = func(topic string, payload []byte) {
+		messages <- topic + " " + string(payload)
+	}
+
+	// Published before the first connection
+	c.publish("handymkv/status", []byte("online"), true)
+
+	done := startSession(context.Background(), c)
+	broker := acceptFakeBrokerConn(t, listener)
+
+	broker.handshake()
+	broker.expectPublish("handymkv/status", "online", true)
+
+	// A command delivered with QoS 1 by the broker is acknowledged with its packet id
+	command := appendMQTTString(nil, "handymkv/command")
+	command = append(command, 0x12, 0x34)
+	command = append(command, `{"command": "eject"}`...)
+
+	broker.write(encodeMQTTPacket(mqttPublish<<4|0x02, command))
+
+	if _, body := broker.expect(mqttPubAck); !bytes.Equal(body, []byte{0x12, 0x34}) {
+		t.Errorf("PUBACK = %x, want packet id 1234", body)
+	}
+
+	select {
+	case message := <-messages:
+		if message != `handymkv/command {"command": "eject"}` {
+			t.Errorf("message = %q, want the command", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not received")
+	}
+
+	c.publish("handymkv/progress", []byte("50"), false)
+	broker.expectPublish("handymkv/progress", "50", false)
+
+	broker.conn.Close()
+	<-done
+
+	// Published while disconnected. Only the retained messages are kept.
+	c.publish("handymkv/job", []byte("{}"), true)
+	c.publish("handymkv/progress", []byte("75"), false)
+	c.publish("handymkv/status", []byte("busy"), true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = startSession(ctx, c)
+	broker = acceptFakeBrokerConn(t, listener)
+
+	broker.handshake()
+	broker.expectPublish("handymkv/status", "busy", true)
+	broker.expectPublish("handymkv/job", "{}", true)
+
+	// The client disconnects cleanly when it stops, so the broker does not publish the will
+	cancel()
+	broker.expect(mqttDisconnect)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session did not end once the client stopped")
+	}
+}
+
+// Checks that a subscription the broker rejects is reported without ending the session, and that retained messages
+// are still republished.
+func TestMQTTClientSubscriptionRejected(t *testing.T) {
+	listener, c := newFakeBroker(t)
+
+	errs := make(chan error, 1)
+	c.onError = func(err error) {
+		errs <- err
+	}
+
+	c.publish("handymkv/status", []byte("online"), true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startSession(ctx, c)
+	broker := acceptFakeBrokerConn(t, listener)
+
+	broker.handshakeWith(mqttSubscribeFailure)
+	broker.expectPublish("handymkv/status", "online", true)
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "rejected the subscription to handymkv/command") {
+			t.Errorf("error = %v, want the rejected subscription", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rejected subscription was not reported")
+	}
+
+	c.publish("handymkv/progress", []byte("50"), false)
+	broker.expectPublish("handymkv/progress", "50", false)
+
+	cancel()
+	broker.expect(mqttDisconnect)
+	<-done
+}
+
+// Checks that the session ends if the broker goes away before acknowledging the subscription.
+func TestMQTTClientSubscriptionNotAcknowledged(t *testing.T) {
+	listener, c := newFakeBroker(t)
+
+	done := startSession(context.Background(), c)
+	broker := acceptFakeBrokerConn(t, listener)
+
+	broker.expect(mqttConnect)
+	broker.write([]byte{mqttConnAck << 4, 2, 0, 0})
+	broker.expect(mqttSubscribe)
+	broker.conn.Close()
+
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "acknowledge the subscriptions") {
+		t.Errorf("session() error = %v, want the missing acknowledgement", err)
+	}
+}
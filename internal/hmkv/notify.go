@@ -0,0 +1,487 @@
+package hmkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// Used when the notifications section does not configure retries.
+	defaultNotificationAttempts = 3
+	defaultNotificationDelay    = "5s"
+	// How long a single delivery attempt may take.
+	notificationRequestTimeout = 10 * time.Second
+	// How long a finished run waits for its notifications to be delivered before giving up on them.
+	notificationFlushTimeout = time.Minute
+	// The number of notifications which can wait to be delivered to a webhook before new ones are dropped.
+	notificationQueueCapacity = 64
+	// The number of lines of process output included with a failed run.
+	notificationOutputTailLines = 20
+)
+
+var ErrNotificationsFailed = errors.New("one or more notifications could not be delivered")
+
+// The event a notification is sent for.
+type notificationEvent string
+
+const (
+	notifyRunStarted   notificationEvent = "run_started"
+	notifyDiscRipped   notificationEvent = "disc_ripped"
+	notifyTitleEncoded notificationEvent = "title_encoded"
+	notifyRunCompleted notificationEvent = "run_completed"
+	notifyRunFailed    notificationEvent = "run_failed"
+	// Sent by the test-notifications command. Webhooks receive it whatever their events are.
+	notifyTest notificationEvent = "test"
+)
+
+var notificationEvents = []notificationEvent{notifyRunStarted, notifyDiscRipped, notifyTitleEncoded, notifyRunCompleted, notifyRunFailed}
+
+// Settings for notifications about runs.
+type notificationsConfig struct {
+	Webhooks []webhookConfig `json:"webhooks,omitempty"`
+	// How failed deliveries are retried. Defaults to 3 attempts with a 5 second delay that doubles after each retry.
+	Retry retryPolicy `json:"retry"`
+}
+
+// A URL notifications are posted to.
+type webhookConfig struct {
+	URL string `json:"url"`
+	// The events sent to the webhook. Every event is sent if empty. Example: ["run_completed", "run_failed"]
+	Events []notificationEvent `json:"events,omitempty"`
+	// Extra request headers, such as an Authorization header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// A Go template which renders the JSON body. The notification is sent as JSON if empty.
+	// Example: {"text": {{json .Message}}}
+	Body string `json:"body,omitempty"`
+}
+
+// Checks that the notification settings are valid.
+func (c *notificationsConfig) validate() error {
+	for i, webhook := range c.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return fmt.Errorf("webhook %d - %w", i+1, err)
+		}
+	}
+
+	return c.Retry.validate()
+}
+
+// Returns the retry policy for deliveries, using the defaults if the policy is not set.
+func (c *notificationsConfig) retryPolicy() retryPolicy {
+	if c.Retry.MaxAttempts == 0 && c.Retry.Delay == "" {
+		return retryPolicy{MaxAttempts: defaultNotificationAttempts, Delay: defaultNotificationDelay}
+	}
+
+	return c.Retry
+}
+
+// Checks that the URL, events and body template of the webhook are valid.
+func (c *webhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: invalid webhook url '%s'", ErrInvalidInput, c.URL)
+	}
+
+	for _, event := range c.Events {
+		if !slices.Contains(notificationEvents, event) {
+			return fmt.Errorf("%w: unknown webhook event '%s'", ErrInvalidInput, event)
+		}
+	}
+
+	if _, err := c.template(); err != nil {
+		return fmt.Errorf("%w: invalid webhook body - %v", ErrInvalidInput, err)
+	}
+
+	return nil
+}
+
+// Returns the URL of the webhook with everything but the scheme and host replaced, for output which may be shared
+// or logged. Webhook URLs often carry a token in the path or query, or credentials in the user info.
+func (c *webhookConfig) redactedURL() string {
+	u, err := url.Parse(c.URL)
+
+	if err != nil || u.Host == "" {
+		return redactedValue
+	}
+
+	redacted := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+
+	if u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		redacted = fmt.Sprintf("%s/%s", redacted, redactedValue)
+	}
+
+	return redacted
+}
+
+// Returns true if the webhook should receive the event.
+func (c *webhookConfig) wants(event notificationEvent) bool {
+	return len(c.Events) == 0 || event == notifyTest || slices.Contains(c.Events, event)
+}
+
+// Parses the body template. Returns nil if the webhook has no body template.
+func (c *webhookConfig) template() (*template.Template, error) {
+	if c.Body == "" {
+		return nil, nil
+	}
+
+	return template.New("body").Funcs(template.FuncMap{"json": templateJSON}).Option("missingkey=error").Parse(c.Body)
+}
+
+// Marshals a value as JSON for use in body templates, so strings are quoted and escaped.
+func templateJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+
+	return string(data), err
+}
+
+// The data of a notification. It is sent as the body of webhooks without a body template, and is the data
+// body templates are executed with. The field names are part of the webhook body and must not change.
+type notification struct {
+	Event notificationEvent `json:"event"`
+	Time  time.Time         `json:"time"`
+	// A short description of the event suitable for a push notification.
+	Message string `json:"message"`
+	// The run's mkv output directory. Resuming the run uses this directory.
+	RunDirectory string             `json:"run_directory"`
+	Disc         *notificationDisc  `json:"disc,omitempty"`
+	Title        *notificationTitle `json:"title,omitempty"`
+	TitleCount   int                `json:"title_count,omitempty"`
+	Summary      *RunSummary        `json:"summary,omitempty"`
+	// The raw size minus the encoded size of the titles which were encoded. Set for completed runs.
+	SpaceSavedBytes int64  `json:"space_saved_bytes,omitempty"`
+	SpaceSaved      string `json:"space_saved,omitempty"`
+	TimeElapsed     string `json:"time_elapsed,omitempty"`
+	Error           string `json:"error,omitempty"`
+	// The last lines of output of the process which failed, if the run failed because of one.
+	OutputTail string `json:"output_tail,omitempty"`
+}
+
+// The disc a notification is about.
+type notificationDisc struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	DevicePath string `json:"device_path"`
+}
+
+// The title a notification is about.
+type notificationTitle struct {
+	Index    int    `json:"index"`
+	FileName string `json:"file_name"`
+	Encoder  string `json:"encoder"`
+	// The size of the encoded file.
+	SizeBytes int64 `json:"size_bytes"`
+	// The time spent encoding the title, including retries.
+	EncodeSeconds float64 `json:"encode_seconds"`
+}
+
+// Builds the notification for a run event. Returns false if the event is not one notifications are sent for.
+func newNotification(event runEvent) (notification, bool) {
+	n := notification{
+		Time:         event.time,
+		RunDirectory: event.run.config.MKVOutputDirectory,
+	}
+
+	disc := &notificationDisc{Id: event.title.DiscId, Name: event.title.DiscTitle, DevicePath: event.title.DevicePath}
+
+	switch event.kind {
+	case eventRunStarted:
+		n.Event = notifyRunStarted
+		n.TitleCount = len(event.run.journal.Entries)
+		n.Message = fmt.Sprintf("Run started with %d titles.", n.TitleCount)
+	case eventDiscRipped:
+		n.Event = notifyDiscRipped
+		n.Disc = disc
+		n.Message = fmt.Sprintf("Disc %d (%s) finished ripping.", disc.Id, disc.Name)
+	case eventTitleEncoded:
+		n.Event = notifyTitleEncoded
+		n.Disc = disc
+		n.Title = &notificationTitle{
+			Index:         event.title.Index,
+			FileName:      event.title.FileName,
+			Encoder:       event.encoder,
+			SizeBytes:     event.bytes,
+			EncodeSeconds: event.duration.Round(time.Second).Seconds(),
+		}
+		n.Message = fmt.Sprintf("%s from disc %d (%s) was encoded in %s.", event.title.FileName, disc.Id, disc.Name, formatTimeElapsedString(event.duration))
+	case eventRunFinished:
+		summary := event.summary
+		n.Summary = summary
+		n.TimeElapsed = formatTimeElapsedString(time.Duration(summary.DurationSeconds) * time.Second)
+		n.Error = summary.Error
+
+		switch summary.Status {
+		case runCompleted, runCompletedWithFailures:
+			n.Event = notifyRunCompleted
+			n.SpaceSavedBytes = summary.RawSizeBytes - summary.EncodedSizeBytes
+
+			// Nothing was saved if the titles were not encoded
+			if summary.EncodedSizeBytes == 0 || n.SpaceSavedBytes < 0 {
+				n.SpaceSavedBytes = 0
+			}
+
+			n.SpaceSaved = formatSavedSpace(n.SpaceSavedBytes)
+			n.Message = fmt.Sprintf("Run completed in %s. %s of disk space saved.", n.TimeElapsed, n.SpaceSaved)
+
+			if summary.Status == runCompletedWithFailures {
+				n.Message = fmt.Sprintf("Run completed with failures in %s - %s", n.TimeElapsed, summary.Error)
+			}
+		default:
+			n.Event = notifyRunFailed
+			n.OutputTail = processOutputTail(event.err, notificationOutputTailLines)
+			n.Message = fmt.Sprintf("Run %s after %s - %s", summary.Status, n.TimeElapsed, summary.Error)
+		}
+	default:
+		return n, false
+	}
+
+	return n, true
+}
+
+// Returns the last lines of output of the process which caused the error. The output is read from the log file
+// of the process if it was not kept in the error. Returns an empty string if the error was not caused by a process.
+func processOutputTail(err error, lines int) string {
+	var processErr *ExternalProcessError
+
+	if !errors.As(err, &processErr) {
+		return ""
+	}
+
+	output := processErr.ProcessOuput
+
+	if output == "" && processErr.LogPath != "" {
+		data, err := os.ReadFile(processErr.LogPath)
+
+		if err != nil {
+			return ""
+		}
+
+		output = string(data)
+	}
+
+	outputLines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+
+	if len(outputLines) > lines {
+		outputLines = outputLines[len(outputLines)-lines:]
+	}
+
+	return strings.Join(outputLines, "\n")
+}
+
+// Delivers the notifications of a run to the configured webhooks. Each webhook has its own queue so a slow or
+// unreachable webhook does not hold up the others.
+type notifier struct {
+	webhooks []*webhookQueue
+	// Called with a description of each delivery which failed after every retry.
+	onFailure func(message string)
+}
+
+// The notifications waiting to be delivered to a webhook.
+type webhookQueue struct {
+	webhook   webhookConfig
+	body      *template.Template
+	policy    retryPolicy
+	queue     chan notification
+	waitGroup sync.WaitGroup
+}
+
+// Creates a notifier for the configured webhooks and starts delivering. Returns nil if no webhooks are configured.
+func newNotifier(config *notificationsConfig, onFailure func(message string)) *notifier {
+	if len(config.Webhooks) == 0 {
+		return nil
+	}
+
+	n := &notifier{onFailure: onFailure}
+
+	for _, webhook := range config.Webhooks {
+		// The template was checked when the configuration was read
+		body, _ := webhook.template()
+
+		w := &webhookQueue{
+			webhook: webhook,
+			body:    body,
+			policy:  config.retryPolicy(),
+			queue:   make(chan notification, notificationQueueCapacity),
+		}
+
+		w.waitGroup.Add(1)
+
+		go func() {
+			defer w.waitGroup.Done()
+
+			for notification := range w.queue {
+				if err := w.deliver(notification); err != nil {
+					onFailure(fmt.Sprintf("The %s notification could not be delivered to %s - %v", notification.Event, w.webhook.redactedURL(), err))
+				}
+			}
+		}()
+
+		n.webhooks = append(n.webhooks, w)
+	}
+
+	return n
+}
+
+// Queues a notification for the event with every webhook which wants it.
+func (n *notifier) handleEvent(event runEvent) {
+	notification, ok := newNotification(event)
+
+	if !ok {
+		return
+	}
+
+	for _, w := range n.webhooks {
+		if !w.webhook.wants(notification.Event) {
+			continue
+		}
+
+		select {
+		case w.queue <- notification:
+		default:
+			n.onFailure(fmt.Sprintf("The %s notification for %s was dropped because too many notifications are waiting to be delivered", notification.Event, w.webhook.redactedURL()))
+		}
+	}
+}
+
+// Stops accepting notifications and waits for the queued ones to be delivered, or for the flush timeout.
+func (n *notifier) close() {
+	done := make(chan struct{})
+
+	go func() {
+		for _, w := range n.webhooks {
+			close(w.queue)
+			w.waitGroup.Wait()
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(notificationFlushTimeout):
+		n.onFailure("Gave up waiting for notifications to be delivered")
+	}
+}
+
+// Posts the notification to the webhook, retrying failed attempts as allowed by the retry policy.
+func (w *webhookQueue) deliver(notification notification) error {
+	body, err := w.render(notification)
+
+	if err != nil {
+		return err
+	}
+
+	post := func(attempt int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationRequestTimeout)
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.webhook.URL, bytes.NewReader(body))
+
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "handymkv")
+
+		for name, value := range w.webhook.Headers {
+			request.Header.Set(name, value)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+
+		if err != nil {
+			// The error is logged, so it must not repeat the URL
+			if urlErr, ok := err.(*url.Error); ok {
+				urlErr.URL = w.webhook.redactedURL()
+			}
+
+			return err
+		}
+
+		response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return fmt.Errorf("the webhook responded with status %s", response.Status)
+		}
+
+		return nil
+	}
+
+	return withRetries(context.Background(), w.policy, post, func(int, time.Duration, error) {})
+}
+
+// Renders the body of the notification with the webhook's template, or as JSON if it has none.
+// Rendered bodies must be valid JSON.
+func (w *webhookQueue) render(notification notification) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(notification)
+	}
+
+	var body bytes.Buffer
+
+	if err := w.body.Execute(&body, notification); err != nil {
+		return nil, fmt.Errorf("error rendering the webhook body - %w", err)
+	}
+
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("the webhook body template did not render valid JSON: %s", body.String())
+	}
+
+	return body.Bytes(), nil
+}
+
+// Sends a test notification to every configured webhook and prints the outcome of each delivery.
+// Returns ErrNotificationsFailed if any delivery failed.
+func TestNotifications() error {
+	config, err := ReadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if len(config.Notifications.Webhooks) == 0 {
+		fmt.Printf("No webhooks are configured. Add them to the notifications section of the configuration file.\n")
+		return nil
+	}
+
+	n := notification{
+		Event:        notifyTest,
+		Time:         time.Now(),
+		Message:      "This is a test notification from HandyMKV.",
+		RunDirectory: config.MKVOutputDirectory,
+	}
+
+	failed := false
+
+	for _, webhook := range config.Notifications.Webhooks {
+		body, _ := webhook.template()
+
+		w := &webhookQueue{webhook: webhook, body: body, policy: config.Notifications.retryPolicy()}
+
+		if err := w.deliver(n); err != nil {
+			fmt.Printf("%s - %sfailed%s - %v\n", webhook.redactedURL(), colorRed, colorReset, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("%s - %sdelivered%s\n", webhook.redactedURL(), colorGreen, colorReset)
+	}
+
+	if failed {
+		return ErrNotificationsFailed
+	}
+
+	return nil
+}
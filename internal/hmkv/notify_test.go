@@ -0,0 +1,323 @@
+package hmkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhook endpoint which records the requests it receives and answers with the scripted status codes in turn.
+// Once the statuses are used up it answers with 200.
+type testWebhook struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+	// If set, requests are not answered until the channel is closed.
+	block chan struct{}
+}
+
+func newTestWebhook(t *testing.T, statuses ...int) *testWebhook {
+	w := &testWebhook{statuses: statuses}
+
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.mutex.Lock()
+		w.requests = append(w.requests, r)
+		w.bodies = append(w.bodies, string(body))
+
+		status := http.StatusOK
+
+		if len(w.statuses) > 0 {
+			status = w.statuses[0]
+			w.statuses = w.statuses[1:]
+		}
+
+		block := w.block
+		w.mutex.Unlock()
+
+		if block != nil {
+			<-block
+		}
+
+		rw.WriteHeader(status)
+	}))
+
+	t.Cleanup(w.Close)
+
+	return w
+}
+
+// Returns the bodies of the requests received so far.
+func (w *testWebhook) received() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return append([]string(nil), w.bodies...)
+}
+
+// A retry policy which retries quickly enough for tests.
+var testNotificationPolicy = retryPolicy{MaxAttempts: 3, Delay: "1ms"}
+
+// Returns a run event of the kind for a run of one title.
+func testRunEvent(kind runEventKind) runEvent {
+	title := TitleInfo{Index: 0, DiscTitle: "MY DISC", DevicePath: "/dev/sr0", FileName: "t00.mkv"}
+
+	event := runEvent{
+		kind: kind,
+		time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		run: &pipeline{
+			config:  &handyMKVConfig{MKVOutputDirectory: "/rips/handymkv_2024-05-01_12-00-00"},
+			journal: &journal{Entries: []journalEntry{{Title: title}}},
+		},
+		title: title,
+	}
+
+	if kind == eventRunFinished {
+		event.summary = &RunSummary{Status: runCompleted, DurationSeconds: 90, RawSizeBytes: 4096, EncodedSizeBytes: 1024}
+	}
+
+	return event
+}
+
+func TestWebhookDeliverTemplate(t *testing.T) {
+	webhook := newTestWebhook(t)
+
+	config := webhookConfig{
+		URL:     webhook.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Body:    `{"text": {{json .Message}}, "event": "{{.Event}}", "disc": {{json .Disc.Name}}}`,
+	}
+
+	body, err := config.template()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, _ := newNotification(testRunEvent(eventDiscRipped))
+	w := &webhookQueue{webhook: config, body: body, policy: testNotificationPolicy}
+
+	if err := w.deliver(n); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := webhook.received()
+
+	if len(bodies) != 1 {
+		t.Fatalf("requests = %d, want 1", len(bodies))
+	}
+
+	var got map[string]string
+
+	if err := json.Unmarshal([]byte(bodies[0]), &got); err != nil {
+		t.Fatalf("the rendered body is not valid JSON - %v\n%s", err, bodies[0])
+	}
+
+	if got["text"] != "Disc 0 (MY DISC) finished ripping." || got["event"] != "disc_ripped" || got["disc"] != "MY DISC" {
+		t.Errorf("body = %v, want the rendered template", got)
+	}
+
+	request := webhook.requests[0]
+
+	if request.Header.Get("Authorization") != "Bearer secret" || request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v, want the configured Authorization header and a JSON content type", request.Header)
+	}
+}
+
+func TestWebhookDeliverWithoutTemplate(t *testing.T) {
+	webhook := newTestWebhook(t)
+
+	n, _ := newNotification(testRunEvent(eventRunFinished))
+	w := &webhookQueue{webhook: webhookConfig{URL: webhook.URL}, policy: testNotificationPolicy}
+
+	if err := w.deliver(n); err != nil {
+		t.Fatal(err)
+	}
+
+	var got notification
+
+	if err := json.Unmarshal([]byte(webhook.received()[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Event != notifyRunCompleted || got.SpaceSavedBytes != 3072 || got.Summary == nil || got.RunDirectory != "/rips/handymkv_2024-05-01_12-00-00" {
+		t.Errorf("body = %+v, want the completed run notification", got)
+	}
+}
+
+func TestWebhookTemplateMustRenderJSON(t *testing.T) {
+	webhook := newTestWebhook(t)
+
+	config := webhookConfig{URL: webhook.URL, Body: `{"text": {{.Message}}}`}
+	body, _ := config.template()
+
+	n, _ := newNotification(testRunEvent(eventRunStarted))
+	w := &webhookQueue{webhook: config, body: body, policy: testNotificationPolicy}
+
+	if err := w.deliver(n); err == nil || !strings.Contains(err.Error(), "valid JSON") {
+		t.Errorf("deliver() error = %v, want an invalid JSON error", err)
+	}
+
+	if len(webhook.received()) != 0 {
+		t.Errorf("an invalid body was sent")
+	}
+}
+
+func TestWebhookDeliverRetries(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusInternalServerError, http.StatusBadGateway)
+
+	n, _ := newNotification(testRunEvent(eventRunStarted))
+	w := &webhookQueue{webhook: webhookConfig{URL: webhook.URL}, policy: testNotificationPolicy}
+
+	if err := w.deliver(n); err != nil {
+		t.Fatalf("deliver() error = %v, want success on the third attempt", err)
+	}
+
+	if requests := len(webhook.received()); requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestWebhookDeliverFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "client error", status: http.StatusUnauthorized},
+		{name: "server error", status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook := newTestWebhook(t, test.status, test.status, test.status, test.status)
+
+			n, _ := newNotification(testRunEvent(eventRunStarted))
+			w := &webhookQueue{webhook: webhookConfig{URL: webhook.URL}, policy: testNotificationPolicy}
+
+			err := w.deliver(n)
+
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprint(test.status)) {
+				t.Errorf("deliver() error = %v, want the %d status", err, test.status)
+			}
+
+			if requests := len(webhook.received()); requests != testNotificationPolicy.MaxAttempts {
+				t.Errorf("requests = %d, want %d attempts", requests, testNotificationPolicy.MaxAttempts)
+			}
+		})
+	}
+}
+
+func TestNotifierReportsFailedDeliveries(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusNotFound, http.StatusNotFound, http.StatusNotFound)
+
+	var failures []string
+
+	n := newNotifier(&notificationsConfig{
+		Webhooks: []webhookConfig{{URL: webhook.URL}},
+		Retry:    testNotificationPolicy,
+	}, func(message string) {
+		failures = append(failures, message)
+	})
+
+	n.handleEvent(testRunEvent(eventRunStarted))
+	n.close()
+
+	if len(failures) != 1 || !strings.Contains(failures[0], "run_started") || !strings.Contains(failures[0], "404") {
+		t.Errorf("failures = %q, want the failed run_started delivery", failures)
+	}
+}
+
+func TestNotifierFiltersEvents(t *testing.T) {
+	all := newTestWebhook(t)
+	ripped := newTestWebhook(t)
+
+	n := newNotifier(&notificationsConfig{
+		Webhooks: []webhookConfig{
+			{URL: all.URL},
+			{URL: ripped.URL, Events: []notificationEvent{notifyDiscRipped}},
+		},
+		Retry: testNotificationPolicy,
+	}, func(message string) {
+		t.Errorf("unexpected failure - %s", message)
+	})
+
+	for _, kind := range []runEventKind{eventRunStarted, eventTitleRipped, eventDiscRipped, eventTitleFailed, eventRunFinished} {
+		n.handleEvent(testRunEvent(kind))
+	}
+
+	n.close()
+
+	events := func(bodies []string) []notificationEvent {
+		events := make([]notificationEvent, 0, len(bodies))
+
+		for _, body := range bodies {
+			var n notification
+
+			json.Unmarshal([]byte(body), &n)
+			events = append(events, n.Event)
+		}
+
+		return events
+	}
+
+	if got := fmt.Sprint(events(all.received())); got != "[run_started disc_ripped run_completed]" {
+		t.Errorf("events sent to the webhook without a filter = %s, want every notification", got)
+	}
+
+	if got := fmt.Sprint(events(ripped.received())); got != "[disc_ripped]" {
+		t.Errorf("events sent to the disc_ripped webhook = %s, want only disc_ripped", got)
+	}
+}
+
+func TestNotifierDropsWhenQueueIsFull(t *testing.T) {
+	webhook := newTestWebhook(t)
+	webhook.block = make(chan struct{})
+
+	var mutex sync.Mutex
+	var failures []string
+
+	n := newNotifier(&notificationsConfig{
+		Webhooks: []webhookConfig{{URL: webhook.URL}},
+		Retry:    testNotificationPolicy,
+	}, func(message string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		failures = append(failures, message)
+	})
+
+	// The first notification may already be waiting on the webhook, the rest fill the queue and overflow it
+	const sent = notificationQueueCapacity + 3
+
+	for range sent {
+		n.handleEvent(testRunEvent(eventRunStarted))
+	}
+
+	close(webhook.block)
+	n.close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	delivered := len(webhook.received())
+
+	if len(failures) < 2 || delivered+len(failures) != sent {
+		t.Fatalf("%d delivered and %d failures, want the notifications which did not fit in the queue dropped", delivered, len(failures))
+	}
+
+	for _, failure := range failures {
+		if !strings.Contains(failure, "dropped") {
+			t.Errorf("failure = %q, want a dropped notification", failure)
+		}
+	}
+}
@@ -0,0 +1,756 @@
+package hmkv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State shared by the ripping and encoding stages of a run.
+type pipeline struct {
+	ctx              context.Context
+	config           *handyMKVConfig
+	tracker          *progressTracker
+	journal          *journal
+	encChannel       chan EncodingParams
+	continueOnError  bool
+	cancelProcessing context.CancelFunc
+	// Reports whether processing was stopped by an interrupt rather than cancelled.
+	interrupted     func() bool
+	encodeWaitGroup sync.WaitGroup
+	startTime       time.Time
+	// Where the JSON summary of the run is written once processing completes. Nil for none.
+	summaryOutput io.Writer
+	// Set when the run is controlled by something other than the terminal. Nil for none.
+	hooks *runHooks
+	// Delivers notifications about the run to the configured webhooks. Nil if none are configured.
+	notifier *notifier
+	// Set once ripping and encoding have stopped and the progress display is no longer drawn.
+	finishing atomic.Bool
+}
+
+// Lets a run be controlled and observed by something other than the terminal, such as a job submitted to the server
+// or a disc inserted in watch mode.
+// The progress display is not drawn for runs with hooks.
+type runHooks struct {
+	// Processing is cancelled when the context is done.
+	ctx context.Context
+	// Called with the status of a title whenever it changes. Called for every title when processing starts.
+	onStatus func(titleStatus)
+	// Called with the summary of the run once processing completes or stops. May be nil.
+	onSummary func(RunSummary)
+	// Reports whether the context was done because of an interrupt, in which case the run can be resumed, rather than
+	// a cancellation. May be nil, in which case runs are always cancelled.
+	interrupted func() bool
+}
+
+// Creates a pipeline for the titles recorded in the journal. Up to queueCapacity titles can be queued for encoding
+// without blocking ripping. Processing is cancelled when the interrupt handler of the options receives a signal, or when
+// the context of the hooks is done. The pipeline never handles signals itself.
+func newPipeline(config *handyMKVConfig, jrnl *journal, options ExecOptions, queueCapacity int) *pipeline {
+	// Titles progress tracking
+	tracker := &progressTracker{
+		statuses: make([]titleStatus, len(jrnl.Entries)),
+	}
+
+	for i, entry := range jrnl.Entries {
+		tracker.statuses[i] = newTitleStatus(entry)
+	}
+
+	parentCtx := context.Background()
+	interrupted := func() bool { return false }
+
+	if options.interrupts != nil {
+		parentCtx = options.interrupts.ctx
+		interrupted = options.interrupts.wasInterrupted
+	}
+
+	if options.hooks != nil {
+		parentCtx = options.hooks.ctx
+		interrupted = func() bool { return false }
+
+		if options.hooks.interrupted != nil {
+			interrupted = options.hooks.interrupted
+		}
+
+		tracker.quiet = true
+		tracker.onChange = options.hooks.onStatus
+
+		for _, status := range tracker.statuses {
+			tracker.onChange(status)
+		}
+	}
+
+	ctx, cancelProcessing := context.WithCancel(parentCtx)
+
+	p := &pipeline{
+		ctx:              ctx,
+		config:           config,
+		tracker:          tracker,
+		journal:          jrnl,
+		encChannel:       make(chan EncodingParams, queueCapacity),
+		continueOnError:  config.ContinueOnError || options.ContinueOnError,
+		cancelProcessing: cancelProcessing,
+		interrupted:      interrupted,
+		startTime:        time.Now(),
+		summaryOutput:    options.SummaryOutput,
+		hooks:            options.hooks,
+	}
+
+	p.notifier = newNotifier(&config.Notifications, p.warn)
+	p.emit(runEvent{kind: eventRunStarted})
+
+	return p
+}
+
+// Returns the initial status of the title recorded in the journal entry.
+func newTitleStatus(entry journalEntry) titleStatus {
+	return titleStatus{
+		TitleIndex: entry.Title.Index,
+		Title:      entry.Title.FileName,
+		DiscId:     entry.Title.DiscId,
+		Ripping:    entry.Ripping,
+		Encoding:   entry.Encoding,
+	}
+}
+
+// Releases the resources of the pipeline. Waits for notifications about the run to be delivered.
+func (p *pipeline) stop() {
+	p.cancelProcessing()
+
+	if p.notifier != nil {
+		p.notifier.close()
+	}
+}
+
+// Reports a problem which does not affect processing. The message is added to the progress display while it is drawn,
+// and printed once processing has finished.
+func (p *pipeline) warn(message string) {
+	if p.tracker.quiet || p.finishing.Load() {
+		fmt.Printf("\nWarning: %s\n", message)
+		return
+	}
+
+	p.tracker.addNotice("%s%s%s", colorYellow, message, colorReset)
+}
+
+// Rips and encodes the titles recorded in the journal and prints a summary once processing completes.
+// Titles the journal records as ripped are only encoded, and titles the journal records as encoded are skipped.
+func process(config *handyMKVConfig, jrnl *journal, options ExecOptions) error {
+	p := newPipeline(config, jrnl, options, len(jrnl.Entries))
+	defer p.stop()
+
+	ripQueues := make(map[int][]TitleInfo)
+	discIds := make([]int, 0)
+
+	for _, entry := range jrnl.Entries {
+		title := entry.title()
+
+		createTitleSubdirectories(&title, config)
+
+		if entry.Encoding == Complete {
+			continue
+		}
+
+		// Titles ripped by an earlier attempt at the run only need encoding
+		if entry.Ripping == Complete {
+			p.encChannel <- encodingParamsFor(&title, config)
+			continue
+		}
+
+		if _, ok := ripQueues[title.DiscId]; !ok {
+			discIds = append(discIds, title.DiscId)
+		}
+
+		ripQueues[title.DiscId] = append(ripQueues[title.DiscId], title)
+	}
+
+	slices.Sort(discIds)
+
+	// The discs to rip in each drive, in order. A drive only has more than one when a session is resumed.
+	driveDiscs := make(map[int][]int)
+	driveIndexes := make([]int, 0)
+
+	for _, discId := range discIds {
+		driveIndex := ripQueues[discId][0].DriveIndex
+
+		if _, ok := driveDiscs[driveIndex]; !ok {
+			driveIndexes = append(driveIndexes, driveIndex)
+		}
+
+		driveDiscs[driveIndex] = append(driveDiscs[driveIndex], discId)
+	}
+
+	// HB
+	p.startEncoders(options)
+
+	// MKV
+	var rippingWaitGroup sync.WaitGroup
+
+	// For each drive rip the titles of its discs, one disc at a time
+	for _, driveIndex := range driveIndexes {
+		discs := driveDiscs[driveIndex]
+
+		rippingWaitGroup.Add(1)
+
+		go func() {
+			defer rippingWaitGroup.Done()
+
+			for i, discId := range discs {
+				discTitles := ripQueues[discId]
+
+				if i > 0 && !p.waitForResumedDisc(discTitles[0], ripQueues[discs[i-1]][0].DiscTitle) {
+					return
+				}
+
+				// A disc followed by another in the same drive is ejected to make way for it
+				p.ripDisc(discTitles, config.EjectAfterRip || i < len(discs)-1)
+
+				if p.ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	rippingWaitGroup.Wait()
+
+	return p.finish()
+}
+
+// Makes sure the output subdirectories of the title exist.
+func createTitleSubdirectories(title *TitleInfo, config *handyMKVConfig) {
+	os.MkdirAll(filepath.Join(config.MKVOutputDirectory, title.Subdirectory()), 0740)
+	os.MkdirAll(filepath.Join(config.HBOutputDirectory, title.Subdirectory()), 0740)
+}
+
+// Starts the encode workers. The worker count from the options overrides the configured count.
+func (p *pipeline) startEncoders(options ExecOptions) {
+	encodeWorkers := p.config.EncodeWorkers
+
+	if options.EncodeWorkers > 0 {
+		encodeWorkers = options.EncodeWorkers
+	}
+
+	if encodeWorkers < 1 {
+		encodeWorkers = 1
+	}
+
+	// Each worker encodes titles from the shared queue until it is closed
+	for range encodeWorkers {
+		p.encodeWaitGroup.Add(1)
+
+		go func() {
+			defer p.encodeWaitGroup.Done()
+			p.encodeTitles(p.ctx)
+		}()
+	}
+}
+
+// Adds titles to a running pipeline. The titles must not already be part of the run.
+func (p *pipeline) addTitles(titles []TitleInfo) {
+	p.journal.addEntries(titles)
+
+	for _, title := range titles {
+		createTitleSubdirectories(&title, p.config)
+
+		p.tracker.addStatus(titleStatus{
+			TitleIndex: title.Index,
+			Title:      title.FileName,
+			DiscId:     title.DiscId,
+			Ripping:    Pending,
+			Encoding:   Pending,
+		})
+	}
+}
+
+// Rips the titles of a single disc, queueing each for encoding, and ejects the disc afterwards if eject is true.
+func (p *pipeline) ripDisc(titles []TitleInfo, eject bool) {
+	p.ripTitles(p.ctx, titles)
+
+	if p.ctx.Err() == nil {
+		p.emit(runEvent{kind: eventDiscRipped, title: titles[0]})
+	}
+
+	// The drive is not needed for encoding, so the next disc can go in while encoding carries on
+	if eject && p.ctx.Err() == nil {
+		p.ejectRippedDisc(p.ctx, titles[0])
+	}
+}
+
+// Waits for the queued titles to be encoded once ripping has finished and prints a summary of the run.
+func (p *pipeline) finish() error {
+	config := p.config
+	jrnl := p.journal
+	tracker := p.tracker
+
+	close(p.encChannel)
+	p.encodeWaitGroup.Wait()
+
+	p.finishing.Store(true)
+
+	if err := jrnl.err(); err != nil {
+		fmt.Printf("\nWarning: the run journal could not be updated, this run may not be resumable - %v\n", err)
+	}
+
+	if p.interrupted() {
+		p.abandon("Processing was interrupted.")
+		fmt.Printf("\nThe run can be resumed with: handymkv resume %s\n\n", config.MKVOutputDirectory)
+		p.writeSummary(runInterrupted, ErrInterrupted, false)
+		return ErrInterrupted
+	}
+
+	if p.hooks != nil && p.hooks.ctx.Err() != nil {
+		p.abandon("Processing was cancelled.")
+		p.writeSummary(runCancelled, ErrCancelled, false)
+		return ErrCancelled
+	}
+
+	if tracker.err != nil {
+		// The titles which were still being ripped or encoded were stopped when the failure cancelled processing
+		p.abandon("Processing was stopped because a title failed.")
+		fmt.Printf("\nThe run can be resumed with: handymkv resume %s\n", config.MKVOutputDirectory)
+		p.writeSummary(runFailed, tracker.err, false)
+		return tracker.err
+	}
+
+	processDuration := time.Since(p.startTime).Round(time.Second)
+
+	fmt.Printf("\nOperation Complete. Time Elapsed - %s\n", formatTimeElapsedString(processDuration))
+
+	// Only titles which made it all the way through have files to measure
+	succeededTitles := make([]TitleInfo, 0, len(jrnl.Entries))
+
+	for i, status := range tracker.statuses {
+		if status.succeeded() {
+			succeededTitles = append(succeededTitles, jrnl.Entries[i].title())
+		}
+	}
+
+	totalSizeRaw, totalSizeEncoded, err := calculateTotalFileSizes(succeededTitles, config)
+
+	if err != nil {
+		fmt.Printf("An error occurred while calculating total sizes - %v\n", err)
+	}
+
+	fmt.Printf("\nTotal size of raw unencoded files - %s\n", formatSavedSpace(totalSizeRaw))
+	fmt.Printf("Total size of encoded files - %s\n", formatSavedSpace(totalSizeEncoded))
+
+	savedSpace := totalSizeRaw - totalSizeEncoded
+	if savedSpace > 0 {
+		fmt.Printf("Total disk space saved via encoding - %s\n", formatSavedSpace(totalSizeRaw-totalSizeEncoded))
+	}
+
+	failures := tracker.failures()
+
+	if len(failures) > 0 {
+		printFailures(failures)
+	}
+
+	rawFilesDeleted := false
+
+	if config.DeleteRawMKVFiles {
+		if len(failures) > 0 {
+			// The raw files and logs are needed to investigate and retry the failed titles
+			fmt.Printf("\nRaw unencoded files were not deleted because some titles failed. They are located in: %s\n", config.MKVOutputDirectory)
+			fmt.Printf("The failed titles can be retried with: handymkv resume %s\n", config.MKVOutputDirectory)
+		} else {
+			deleteRawFiles(config)
+			rawFilesDeleted = true
+		}
+	}
+
+	// Tell the user where the encoded files are located
+	fmt.Printf("\nEncoded files are located in: %s\n\n", config.HBOutputDirectory)
+
+	if len(failures) > 0 {
+		err := fmt.Errorf("%w - %d of %d titles failed", ErrTitlesFailed, len(failures), len(jrnl.Entries))
+		p.writeSummary(runCompletedWithFailures, err, rawFilesDeleted)
+		return err
+	}
+
+	p.writeSummary(runCompleted, nil, rawFilesDeleted)
+
+	return nil
+}
+
+// Prints each failed title along with its error and the location of its log file.
+func printFailures(failures []titleStatus) {
+	fmt.Printf("\n%sThe following titles failed:%s\n\n", colorRed, colorReset)
+
+	for _, failure := range failures {
+		stage := encodingStage
+
+		if failure.Ripping == Failed {
+			stage = rippingStage
+		}
+
+		fmt.Printf("Disc %d, Title %d (%s) - %s failed - %v\n", failure.DiscId, failure.TitleIndex, failure.Title, stage, failure.Err)
+
+		if failure.LogPath != "" {
+			fmt.Printf("    Log file: %s\n", failure.LogPath)
+		}
+	}
+}
+
+// Cleans up the partial files of titles which were being ripped or encoded when processing was stopped early,
+// then prints the heading followed by which titles finished and which were abandoned. Must only be called once
+// processing has stopped.
+func (p *pipeline) abandon(heading string) {
+	// The display may have been interrupted mid-redraw
+	fmt.Printf("%s\n\n%s\n", colorReset, heading)
+
+	finished := make([]string, 0)
+	abandoned := make([]string, 0)
+
+	for i, status := range p.tracker.statuses {
+		description := fmt.Sprintf("Disc %d, Title %d (%s)", status.DiscId, status.TitleIndex, status.Title)
+
+		if status.succeeded() {
+			finished = append(finished, description)
+			continue
+		}
+
+		title := p.journal.Entries[i].title()
+		params := encodingParamsFor(&title, p.config)
+
+		var reason, partialPath, keptPath string
+
+		switch {
+		case status.Ripping == Failed:
+			reason = "ripping failed"
+		case status.Encoding == Failed:
+			reason = "encoding failed"
+		case status.Ripping == InProgress || status.Ripping == Retrying:
+			reason = "stopped while ripping"
+			partialPath = params.MKVOutputPath
+			keptPath = params.MKVOutputPath + ".partial"
+		case status.Encoding == InProgress || status.Encoding == Retrying:
+			reason = "stopped while encoding"
+			partialPath = tempEncodePath(params.HandBrakeOutputPath)
+			keptPath = params.HandBrakeOutputPath + ".partial"
+		case status.Ripping == Complete:
+			reason = "ripped but not encoded"
+		default:
+			reason = "not started"
+		}
+
+		if partialPath != "" {
+			reason = fmt.Sprintf("%s, %s", reason, cleanupPartialFile(partialPath, keptPath, p.config.KeepPartialFiles))
+		}
+
+		abandoned = append(abandoned, fmt.Sprintf("%s - %s", description, reason))
+	}
+
+	if len(finished) > 0 {
+		fmt.Printf("\n%sFinished titles:%s\n\n", colorGreen, colorReset)
+
+		for _, description := range finished {
+			fmt.Println(description)
+		}
+	}
+
+	if len(abandoned) > 0 {
+		fmt.Printf("\n%sAbandoned titles:%s\n\n", colorYellow, colorReset)
+
+		for _, description := range abandoned {
+			fmt.Println(description)
+		}
+	}
+}
+
+// Deletes a file left behind by an interrupted process, or renames it to keptPath if keep is true.
+// Returns a description of what was done for the interruption summary.
+func cleanupPartialFile(path, keptPath string, keep bool) string {
+	if _, err := os.Stat(path); err != nil {
+		return "no partial file was written"
+	}
+
+	if keep {
+		if err := os.Rename(path, keptPath); err != nil {
+			return fmt.Sprintf("partial file %s could not be renamed - %v", path, err)
+		}
+
+		return fmt.Sprintf("partial file kept as %s", keptPath)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Sprintf("partial file %s could not be deleted - %v", path, err)
+	}
+
+	return "partial file deleted"
+}
+
+// Returns the encoding parameters for a title, including the paths of its raw and encoded files.
+func encodingParamsFor(title *TitleInfo, config *handyMKVConfig) EncodingParams {
+	// Replace spaces with underscores for encoding run.
+	encodingOutputFileName := strings.ReplaceAll(title.FileName, " ", "_")
+
+	if config.EncodeConfig.OutputFileFormat != "" && config.EncodeConfig.OutputFileFormat != "mkv" {
+		encodingOutputFileName = fmt.Sprintf("%s.%s", strings.TrimSuffix(encodingOutputFileName, ".mkv"), config.EncodeConfig.OutputFileFormat)
+	}
+
+	return EncodingParams{
+		DiscId:              title.DiscId,
+		TitleIndex:          title.Index,
+		MKVOutputPath:       filepath.Join(config.MKVOutputDirectory, title.Subdirectory(), title.FileName),
+		HandBrakeOutputPath: filepath.Join(config.HBOutputDirectory, title.Subdirectory(), encodingOutputFileName),
+		Quality:             config.EncodeConfig.Quality,
+		Encoder:             config.EncodeConfig.Encoder,
+		EncoderPreset:       config.EncodeConfig.EncoderPreset,
+		OutputFileFormat:    config.EncodeConfig.OutputFileFormat,
+		Preset:              config.EncodeConfig.Preset,
+		PresetFile:          config.EncodeConfig.PresetFile,
+		SubtitleLanguages:   config.EncodeConfig.SubtitleLanguages,
+		AudioLanguages:      config.EncodeConfig.AudioLanguages,
+	}
+}
+
+// Ejects the disc the title was ripped from and reports the outcome in the progress display.
+func (p *pipeline) ejectRippedDisc(ctx context.Context, title TitleInfo) {
+	if err := ejectDisc(ctx, p.config.EjectCommand, title.DevicePath); err != nil {
+		p.tracker.addNotice("%sDisc %d (%s) could not be ejected - %v%s", colorRed, title.DiscId, title.DiscTitle, err, colorReset)
+		return
+	}
+
+	p.tracker.addNotice("Disc %d (%s) was ejected.", title.DiscId, title.DiscTitle)
+}
+
+// Describes a failed attempt which is about to be retried. The attempt is the number of the next attempt.
+func retryMessage(attempt int, delay time.Duration, err error) string {
+	return fmt.Sprintf("Attempt %d failed, retrying in %s - %v", attempt-1, delay, err)
+}
+
+// Handles a title which failed to rip or encode. The title is marked as failed and, unless in continue on error mode,
+// processing is cancelled. Returns true if processing should stop.
+func (p *pipeline) handleFailure(ctx context.Context, discId, titleIndex int, stage processStage, err error) bool {
+	// Errors caused by cancellation are not failures of the title itself
+	if ctx.Err() == nil {
+		p.journal.update(discId, titleIndex, func(entry *journalEntry) {
+			if stage == rippingStage {
+				entry.Ripping = Failed
+			} else {
+				entry.Encoding = Failed
+			}
+		})
+
+		title := p.title(discId, titleIndex)
+		params := encodingParamsFor(&title, p.config)
+
+		p.emit(runEvent{kind: eventTitleFailed, title: title, stage: stage, encoder: encoderName(&params), err: err})
+		p.tracker.setTitleFailed(discId, titleIndex, stage, err)
+
+		if p.continueOnError {
+			return false
+		}
+	}
+
+	p.tracker.setError(err)
+	p.cancelProcessing()
+
+	return true
+}
+
+// Rips the titles one after another, queueing each for encoding once it has been ripped.
+func (p *pipeline) ripTitles(ctx context.Context, titles []TitleInfo) {
+	tracker := p.tracker
+
+	for _, title := range titles {
+		var mkvOutputDirectory string = filepath.Join(p.config.MKVOutputDirectory, title.Subdirectory())
+
+		var lastPercent int
+		var lastOperation string
+
+		onProgress := func(progress ripProgress) {
+			// Only redraw the display when something visible has changed
+			if int(progress.Percent) == lastPercent && progress.Operation == lastOperation {
+				return
+			}
+
+			lastPercent = int(progress.Percent)
+			lastOperation = progress.Operation
+
+			tracker.applyChangeAndDisplay(title.DiscId, title.Index, func(status *titleStatus) {
+				status.RipPercent = progress.Percent
+				status.RipOperation = progress.Operation
+			})
+		}
+
+		params := encodingParamsFor(&title, p.config)
+
+		rip := func(attempt int) error {
+			tracker.applyChangeAndDisplay(title.DiscId, title.Index, func(status *titleStatus) {
+				status.Ripping = InProgress
+				status.RipAttempts = attempt
+				status.RipPercent = 0
+				status.RipOperation = ""
+			})
+
+			// Remove anything left behind by an earlier attempt
+			os.Remove(params.MKVOutputPath)
+
+			return ripTitle(ctx, &title, mkvOutputDirectory, attempt, onProgress)
+		}
+
+		onRetry := func(attempt int, delay time.Duration, err error) {
+			tracker.applyChangeAndDisplay(title.DiscId, title.Index, func(status *titleStatus) {
+				status.Ripping = Retrying
+				status.RipOperation = retryMessage(attempt, delay, err)
+			})
+		}
+
+		p.journal.update(title.DiscId, title.Index, func(entry *journalEntry) {
+			entry.Ripping = InProgress
+		})
+
+		ripStart := time.Now()
+		ripErr := withRetries(ctx, p.config.Retry.Rip, rip, onRetry)
+		ripDuration := time.Since(ripStart)
+
+		tracker.applyChange(title.DiscId, title.Index, func(status *titleStatus) {
+			status.RipDuration = ripDuration
+		})
+
+		if ripErr != nil {
+			if p.handleFailure(ctx, title.DiscId, title.Index, rippingStage, ripErr) {
+				return
+			}
+
+			continue
+		}
+
+		rawFileSize, _ := getFileSize(params.MKVOutputPath)
+
+		p.journal.update(title.DiscId, title.Index, func(entry *journalEntry) {
+			entry.Ripping = Complete
+			entry.RawFileSize = rawFileSize
+		})
+
+		applyComplete := func(status *titleStatus) {
+			status.Ripping = Complete
+		}
+
+		// Update progress for ripping completion
+		tracker.applyChangeAndDisplay(title.DiscId, title.Index, applyComplete)
+
+		p.emit(runEvent{kind: eventTitleRipped, title: title, duration: ripDuration, bytes: rawFileSize})
+
+		p.encChannel <- params
+	}
+}
+
+// Encodes titles received from the encoding channel until the channel is closed or processing is cancelled.
+// Several instances may run concurrently against the same channel.
+func (p *pipeline) encodeTitles(ctx context.Context) {
+	tracker := p.tracker
+
+	for {
+		select {
+		case params, ok := <-p.encChannel:
+			if !ok {
+				return
+			}
+
+			// Make sure the input file exists
+			if _, err := os.Stat(params.MKVOutputPath); os.IsNotExist(err) {
+				inputErr := fmt.Errorf("encoding input file %s does not exist", params.MKVOutputPath)
+
+				if p.handleFailure(ctx, params.DiscId, params.TitleIndex, encodingStage, inputErr) {
+					return
+				}
+
+				continue
+			}
+
+			var lastPercent int
+			var lastAvgFPS float64
+
+			onProgress := func(progress encodeProgress) {
+				lastAvgFPS = progress.AvgFPS
+
+				// Only redraw the display when the whole percentage has changed
+				if int(progress.Percent) == lastPercent {
+					return
+				}
+
+				lastPercent = int(progress.Percent)
+
+				tracker.applyChangeAndDisplay(params.DiscId, params.TitleIndex, func(status *titleStatus) {
+					status.EncodePercent = progress.Percent
+					status.EncodeFPS = progress.FPS
+					status.EncodeAvgFPS = progress.AvgFPS
+					status.EncodeETA = progress.ETA
+				})
+			}
+
+			encodeAttempt := func(attempt int) error {
+				tracker.applyChangeAndDisplay(params.DiscId, params.TitleIndex, func(status *titleStatus) {
+					status.Encoding = InProgress
+					status.EncodeAttempts = attempt
+					status.EncodePercent = 0
+					status.EncodeOperation = ""
+				})
+
+				return encode(ctx, &params, attempt, onProgress)
+			}
+
+			onRetry := func(attempt int, delay time.Duration, err error) {
+				tracker.applyChangeAndDisplay(params.DiscId, params.TitleIndex, func(status *titleStatus) {
+					status.Encoding = Retrying
+					status.EncodeOperation = retryMessage(attempt, delay, err)
+				})
+			}
+
+			p.journal.update(params.DiscId, params.TitleIndex, func(entry *journalEntry) {
+				entry.Encoding = InProgress
+			})
+
+			encodeStart := time.Now()
+			encErr := withRetries(ctx, p.config.Retry.Encode, encodeAttempt, onRetry)
+			encodeDuration := time.Since(encodeStart)
+
+			tracker.applyChange(params.DiscId, params.TitleIndex, func(status *titleStatus) {
+				status.EncodeDuration = encodeDuration
+			})
+
+			if encErr != nil {
+				if p.handleFailure(ctx, params.DiscId, params.TitleIndex, encodingStage, encErr) {
+					return
+				}
+
+				continue
+			}
+
+			p.journal.update(params.DiscId, params.TitleIndex, func(entry *journalEntry) {
+				entry.Encoding = Complete
+			})
+
+			applyComplete := func(status *titleStatus) {
+				status.Encoding = Complete
+			}
+
+			// Update progress for encoding completion
+			tracker.applyChangeAndDisplay(params.DiscId, params.TitleIndex, applyComplete)
+
+			encodedFileSize, _ := getFileSize(params.HandBrakeOutputPath)
+
+			p.emit(runEvent{
+				kind:     eventTitleEncoded,
+				title:    p.title(params.DiscId, params.TitleIndex),
+				encoder:  encoderName(&params),
+				duration: encodeDuration,
+				bytes:    encodedFileSize,
+				avgFPS:   lastAvgFPS,
+			})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
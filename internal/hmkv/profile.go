@@ -0,0 +1,86 @@
+package hmkv
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+// A named set of settings which replace those of the base configuration when the profile is selected.
+// Settings which are omitted from the profile are taken from the base configuration.
+type profile struct {
+	EncodeConfig   *EncodingParams `json:"encoding_params,omitempty"`
+	TitleFilters   *titleFilters   `json:"title_filters,omitempty"`
+	TitleSelection string          `json:"title_selection,omitempty"`
+}
+
+// Checks that the profile is valid.
+func (p *profile) validate() error {
+	if p.TitleFilters != nil {
+		return p.TitleFilters.validate()
+	}
+
+	return nil
+}
+
+// Returns the names of the configured profiles in sorted order.
+func (config *handyMKVConfig) profileNames() []string {
+	return slices.Sorted(maps.Keys(config.Profiles))
+}
+
+// Replaces the settings of the configuration with those of the named profile.
+// Returns the title selection of the profile, which is empty if the profile does not set one.
+func (config *handyMKVConfig) applyProfile(name string) (string, error) {
+	p, ok := config.Profiles[name]
+
+	if !ok {
+		return "", fmt.Errorf("%w: '%s'", ErrProfileNotFound, name)
+	}
+
+	if p.EncodeConfig != nil {
+		config.EncodeConfig = *p.EncodeConfig
+
+		if err := applyPresetFile(&config.EncodeConfig); err != nil {
+			return "", err
+		}
+	}
+
+	if p.TitleFilters != nil {
+		config.TitleFilters = *p.TitleFilters
+	}
+
+	return p.TitleSelection, nil
+}
+
+// Reads the configuration file and applies the profile selected in the options.
+// The profile's title selection is used for discs without a title selection in the options.
+func readRunConfig(options *ExecOptions) (*handyMKVConfig, error) {
+	config, err := ReadConfig()
+
+	if err != nil {
+		if err == ErrConfigNotFound {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("an unexpected error occurred while reading the configuration file: %w", err)
+	}
+
+	if options.Profile == "" {
+		return config, nil
+	}
+
+	titleSelection, err := config.applyProfile(options.Profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DefaultTitleSelection == "" {
+		options.DefaultTitleSelection = titleSelection
+	}
+
+	return config, nil
+}
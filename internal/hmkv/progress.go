@@ -1,15 +1,25 @@
 package hmkv
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Keeps track of the progress of the ripping and encoding processes.
 // Outputs the progress to the terminal.
 type progressTracker struct {
 	statuses []titleStatus
+	// Messages about the run as a whole which are shown below the titles.
+	notices []string
+	// While paused, changes are recorded but the display is not redrawn so that other output is not cleared.
+	paused bool
+	// If true, the display is never drawn. Used for runs which are not attached to a terminal.
+	quiet bool
+	// Called with the new status of a title after every change which is displayed. May be nil.
+	onChange func(titleStatus)
 	mutex    sync.Mutex
 	err      error
 }
@@ -20,6 +30,9 @@ const (
 	Pending statusValue = iota
 	InProgress
 	Complete
+	Failed
+	Skipped
+	Retrying
 )
 
 // String representation of the statusValue.
@@ -31,11 +44,51 @@ func (s statusValue) String() string {
 		return "In Progress"
 	case Complete:
 		return "Complete"
+	case Failed:
+		return "Failed"
+	case Skipped:
+		return "Skipped"
+	case Retrying:
+		return "Retrying"
 	default:
 		return "Unknown"
 	}
 }
 
+// A stage of processing a title.
+type processStage uint8
+
+const (
+	rippingStage processStage = iota
+	encodingStage
+)
+
+// String representation of the processStage.
+func (s processStage) String() string {
+	if s == rippingStage {
+		return "ripping"
+	}
+
+	return "encoding"
+}
+
+// Marshals the status value as its string representation.
+func (s statusValue) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Unmarshals a status value from its string representation.
+func (s *statusValue) UnmarshalText(text []byte) error {
+	for value := Pending; value <= Retrying; value++ {
+		if value.String() == string(text) {
+			*s = value
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: unknown status '%s'", ErrInvalidInput, text)
+}
+
 // Represents the status of a title.
 type titleStatus struct {
 	// The index of the title on the disc.
@@ -48,15 +101,43 @@ type titleStatus struct {
 	Ripping statusValue
 	// The status of the encoding process.
 	Encoding statusValue
+	// The progress of the ripping process as a percentage. Only meaningful while ripping is in progress.
+	RipPercent float64
+	// The label of the operation makemkvcon is currently performing.
+	RipOperation string
+	// The number of the current or last ripping attempt.
+	RipAttempts int
+	// The number of the current or last encoding attempt.
+	EncodeAttempts int
+	// Why the encode is waiting to be retried and when the next attempt starts. Empty while an attempt is running.
+	EncodeOperation string
+	// The progress of the encoding process as a percentage. Only meaningful while encoding is in progress.
+	EncodePercent float64
+	// The current encoding speed in frames per second.
+	EncodeFPS float64
+	// The average encoding speed in frames per second.
+	EncodeAvgFPS float64
+	// The estimated time remaining for the encode.
+	EncodeETA time.Duration
+	// The time spent ripping the title, including retries.
+	RipDuration time.Duration
+	// The time spent encoding the title, including retries.
+	EncodeDuration time.Duration
+	// The error which caused ripping or encoding to fail.
+	Err error
+	// The path of the log file containing the output of the failed process, if any.
+	LogPath string
 }
 
-func (pt *progressTracker) applyChangeAndDisplay(titleIndex int, applyChangeFunc func(*titleStatus)) {
+// Applies a change to the status of the title with the given disc and title index and redraws the display.
+func (pt *progressTracker) applyChangeAndDisplay(discId, titleIndex int, applyChangeFunc func(*titleStatus)) {
 	pt.mutex.Lock()
 	defer pt.mutex.Unlock()
 
 	for i, status := range pt.statuses {
-		if status.TitleIndex == titleIndex {
+		if status.DiscId == discId && status.TitleIndex == titleIndex {
 			applyChangeFunc(&pt.statuses[i])
+			pt.notifyChange(pt.statuses[i])
 			break
 		}
 	}
@@ -64,11 +145,35 @@ func (pt *progressTracker) applyChangeAndDisplay(titleIndex int, applyChangeFunc
 	pt.refreshDisplay()
 }
 
+// Passes the status to the change callback, if any. Must be called with the mutex held so that changes are passed on in order.
+func (pt *progressTracker) notifyChange(status titleStatus) {
+	if pt.onChange != nil {
+		pt.onChange(status)
+	}
+}
+
+// Applies a change to the status of the title with the given disc and title index without redrawing the display.
+func (pt *progressTracker) applyChange(discId, titleIndex int, applyChangeFunc func(*titleStatus)) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	for i, status := range pt.statuses {
+		if status.DiscId == discId && status.TitleIndex == titleIndex {
+			applyChangeFunc(&pt.statuses[i])
+			break
+		}
+	}
+}
+
 func (pt *progressTracker) refreshDisplay() {
+	if pt.paused || pt.quiet {
+		return
+	}
+
 	clear()
 	PrintLogo()
-	fmt.Printf("%-30s%-10s%-20s%-20s\n", "Title", "Disc", "Ripping", "Encoding")
-	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("%-30s%-10s%-24s%-24s%-10s%-10s%-10s\n", "Title", "Disc", "Ripping", "Encoding", "FPS", "Avg FPS", "ETA")
+	fmt.Println(strings.Repeat("-", 118))
 
 	for _, status := range pt.statuses {
 		rippingColor := getColor(status.Ripping)
@@ -78,27 +183,151 @@ func (pt *progressTracker) refreshDisplay() {
 		displayTitle := strings.TrimSuffix(status.Title, ".mkv")
 		titleCol, titleTooLong := padString(displayTitle, 30)
 		discIdCol, _ := padString(fmt.Sprintf("%d", status.DiscId), 10)
-		rippingCol, _ := padString(colorize(status.Ripping, rippingColor), 20)
-		encodingCol, _ := padString(colorize(status.Encoding, encodingColor), 20)
+		rippingText := status.Ripping.String()
+
+		if status.Ripping == InProgress {
+			rippingText = fmt.Sprintf("%s (%.0f%%)", rippingText, status.RipPercent)
+		}
+
+		if status.RipAttempts > 1 {
+			rippingText = fmt.Sprintf("%s #%d", rippingText, status.RipAttempts)
+		}
+
+		rippingCol, _ := padString(colorize(rippingText, rippingColor), 24)
+		encodingText := status.Encoding.String()
+
+		var fpsText, avgFPSText, etaText string
+
+		if status.Encoding == InProgress {
+			encodingText = fmt.Sprintf("%s (%.0f%%)", encodingText, status.EncodePercent)
+
+			if status.EncodeAvgFPS > 0 {
+				fpsText = fmt.Sprintf("%.1f", status.EncodeFPS)
+				avgFPSText = fmt.Sprintf("%.1f", status.EncodeAvgFPS)
+				etaText = formatTimeElapsedString(status.EncodeETA)
+			}
+		}
+
+		if status.EncodeAttempts > 1 {
+			encodingText = fmt.Sprintf("%s #%d", encodingText, status.EncodeAttempts)
+		}
+
+		encodingCol, _ := padString(colorize(encodingText, encodingColor), 24)
+		fpsCol, _ := padString(fpsText, 10)
+		avgFPSCol, _ := padString(avgFPSText, 10)
+		etaCol, _ := padString(etaText, 10)
 
 		if titleTooLong {
 			titleSpillOver := titleCol[27:]
 			titleCol = fmt.Sprintf("%s   ", titleCol[0:27])
-			fmt.Printf("%s%s%s%s\n", titleCol, discIdCol, rippingCol, encodingCol)
+			fmt.Printf("%s%s%s%s%s%s%s\n", titleCol, discIdCol, rippingCol, encodingCol, fpsCol, avgFPSCol, etaCol)
 			fmt.Printf("%s\n", titleSpillOver)
-			continue
+		} else {
+			// Print the row
+			fmt.Printf("%s%s%s%s%s%s%s\n", titleCol, discIdCol, rippingCol, encodingCol, fpsCol, avgFPSCol, etaCol)
+		}
+
+		if (status.Ripping == InProgress || status.Ripping == Retrying) && status.RipOperation != "" {
+			fmt.Printf("  %s\n", status.RipOperation)
+		}
+
+		if status.Encoding == Retrying && status.EncodeOperation != "" {
+			fmt.Printf("  %s\n", status.EncodeOperation)
+		}
+
+		if status.Err != nil {
+			fmt.Printf("  %s%v%s\n", colorRed, status.Err, colorReset)
 		}
+	}
+
+	if len(pt.notices) > 0 {
+		fmt.Println()
 
-		// Print the row
-		fmt.Printf("%s%s%s%s\n", titleCol, discIdCol, rippingCol, encodingCol)
+		for _, notice := range pt.notices {
+			fmt.Println(notice)
+		}
 	}
 }
 
+// Adds the status of a title which was added to the run after processing started and redraws the display.
+func (pt *progressTracker) addStatus(status titleStatus) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.statuses = append(pt.statuses, status)
+	pt.notifyChange(status)
+	pt.refreshDisplay()
+}
+
+// Pauses or resumes redrawing the display. The display is redrawn when it is resumed.
+func (pt *progressTracker) setPaused(paused bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.paused = paused
+	pt.refreshDisplay()
+}
+
+// Adds a message about the run as a whole to the display and redraws it.
+func (pt *progressTracker) addNotice(format string, args ...any) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.notices = append(pt.notices, fmt.Sprintf(format, args...))
+	pt.refreshDisplay()
+}
+
+// Records an error which stops processing. Only the first error is kept as errors reported afterwards
+// are usually caused by the cancellation of processing.
 func (pt *progressTracker) setError(err error) {
 	pt.mutex.Lock()
 	defer pt.mutex.Unlock()
 
-	pt.err = err
+	if pt.err == nil {
+		pt.err = err
+	}
+}
+
+// Marks the given stage of the title as failed, recording the error and its log file for the final summary.
+func (pt *progressTracker) setTitleFailed(discId, titleIndex int, stage processStage, err error) {
+	pt.applyChangeAndDisplay(discId, titleIndex, func(status *titleStatus) {
+		if stage == rippingStage {
+			status.Ripping = Failed
+			// A title which failed to rip has nothing to encode
+			status.Encoding = Skipped
+		} else {
+			status.Encoding = Failed
+		}
+
+		status.Err = err
+
+		var processErr *ExternalProcessError
+
+		if errors.As(err, &processErr) {
+			status.LogPath = processErr.LogPath
+		}
+	})
+}
+
+// Returns the statuses of all titles which failed.
+func (pt *progressTracker) failures() []titleStatus {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	failed := make([]titleStatus, 0)
+
+	for _, status := range pt.statuses {
+		if status.Ripping == Failed || status.Encoding == Failed {
+			failed = append(failed, status)
+		}
+	}
+
+	return failed
+}
+
+// Reports whether the title was both ripped and encoded.
+func (s *titleStatus) succeeded() bool {
+	return s.Ripping == Complete && s.Encoding == Complete
 }
 
 func getColor(status statusValue) string {
@@ -109,6 +338,10 @@ func getColor(status statusValue) string {
 		return colorBlue
 	case Complete:
 		return colorGreen
+	case Failed:
+		return colorRed
+	case Retrying:
+		return colorYellow
 	default:
 		return colorReset
 	}
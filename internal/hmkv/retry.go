@@ -0,0 +1,88 @@
+package hmkv
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Returns a channel which receives once the retry delay has passed. Replaced by tests so that they do not wait.
+var retryAfter = time.After
+
+// Controls how many times a failed stage of processing is attempted and how long to wait between attempts.
+// The delay doubles after every failed attempt.
+type retryPolicy struct {
+	// The maximum number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int `json:"max_attempts"`
+	// The delay before the first retry. Example: 30s
+	Delay string `json:"delay"`
+}
+
+// Retry policies for each stage of processing.
+type retryConfig struct {
+	Rip    retryPolicy `json:"rip"`
+	Encode retryPolicy `json:"encode"`
+}
+
+// Checks that the retry policy is valid.
+func (p *retryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("%w: retry max_attempts cannot be negative", ErrInvalidInput)
+	}
+
+	if _, err := p.delay(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Parses the initial retry delay. An unset delay is returned as 0.
+func (p *retryPolicy) delay() (time.Duration, error) {
+	if p.Delay == "" {
+		return 0, nil
+	}
+
+	delay, err := time.ParseDuration(p.Delay)
+
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("%w: invalid retry delay '%s'", ErrInvalidInput, p.Delay)
+	}
+
+	return delay, nil
+}
+
+// Returns the maximum number of attempts, which is always at least 1.
+func (p *retryPolicy) attempts() int {
+	return max(p.MaxAttempts, 1)
+}
+
+// Runs the operation until it succeeds, the attempts allowed by the policy are exhausted or the context is cancelled.
+// The operation receives the number of the attempt starting at 1. Before waiting to retry, onRetry is called with
+// the number of the next attempt, the delay before it starts and the error of the failed attempt.
+// The error of the last attempt is returned.
+func withRetries(ctx context.Context, policy retryPolicy, operation func(attempt int) error, onRetry func(attempt int, delay time.Duration, err error)) error {
+	delay, err := policy.delay()
+
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = operation(attempt)
+
+		if err == nil || attempt >= policy.attempts() || ctx.Err() != nil {
+			return err
+		}
+
+		onRetry(attempt+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-retryAfter(delay):
+		}
+
+		delay *= 2
+	}
+}
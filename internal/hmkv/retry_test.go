@@ -0,0 +1,140 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Makes retries wait for the channel returned by after instead of the delay for the duration of the test.
+// Every delay waited for is recorded in the returned slice.
+func useTestRetryAfter(t *testing.T, after func() <-chan time.Time) *[]time.Duration {
+	t.Helper()
+
+	delays := make([]time.Duration, 0)
+
+	retryAfter = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		return after()
+	}
+
+	t.Cleanup(func() { retryAfter = time.After })
+
+	return &delays
+}
+
+// Returns a channel which is ready straight away.
+func immediately() <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- time.Now()
+
+	return c
+}
+
+func TestWithRetries(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		// The number of attempts which fail before one succeeds.
+		failures     int
+		wantAttempts int
+		wantDelays   []time.Duration
+		wantErr      bool
+	}{
+		{name: "success", policy: retryPolicy{MaxAttempts: 3, Delay: "1s"}, wantAttempts: 1, wantDelays: []time.Duration{}},
+		{name: "retries disabled", policy: retryPolicy{}, failures: 5, wantAttempts: 1, wantDelays: []time.Duration{}, wantErr: true},
+		{
+			name:         "success on a retry",
+			policy:       retryPolicy{MaxAttempts: 4, Delay: "1s"},
+			failures:     2,
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:         "attempts exhausted",
+			policy:       retryPolicy{MaxAttempts: 3, Delay: "30s"},
+			failures:     5,
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{30 * time.Second, time.Minute},
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delays := useTestRetryAfter(t, immediately)
+
+			attempts := 0
+			retries := make([]int, 0)
+
+			err := withRetries(context.Background(), test.policy, func(attempt int) error {
+				attempts++
+
+				if attempt != attempts {
+					t.Errorf("attempt = %d, want %d", attempt, attempts)
+				}
+
+				if attempts <= test.failures {
+					return errFailed
+				}
+
+				return nil
+			}, func(attempt int, delay time.Duration, err error) {
+				retries = append(retries, attempt)
+
+				if err != errFailed {
+					t.Errorf("onRetry() error = %v, want the error of the failed attempt", err)
+				}
+			})
+
+			if (err != nil) != test.wantErr || (err != nil && err != errFailed) {
+				t.Errorf("withRetries() = %v, want an error: %t", err, test.wantErr)
+			}
+
+			if attempts != test.wantAttempts || len(retries) != test.wantAttempts-1 {
+				t.Errorf("attempts = %d, retries = %v, want %d attempts", attempts, retries, test.wantAttempts)
+			}
+
+			if !slices.Equal(*delays, test.wantDelays) {
+				t.Errorf("delays = %v, want %v", *delays, test.wantDelays)
+			}
+		})
+	}
+}
+
+func TestWithRetriesStopsWhenCancelled(t *testing.T) {
+	// The delay never passes, so only the cancellation can end the wait
+	delays := useTestRetryAfter(t, func() <-chan time.Time { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errFailed := errors.New("failed")
+	attempts := 0
+
+	err := withRetries(ctx, retryPolicy{MaxAttempts: 5, Delay: "1h"}, func(int) error {
+		attempts++
+		return errFailed
+	}, func(int, time.Duration, error) {
+		cancel()
+	})
+
+	if err != errFailed || attempts != 1 || len(*delays) != 1 {
+		t.Errorf("withRetries() = %v after %d attempts and %d waits, want the first error once cancelled while waiting", err, attempts, len(*delays))
+	}
+}
+
+func TestWithRetriesInvalidDelay(t *testing.T) {
+	err := withRetries(context.Background(), retryPolicy{MaxAttempts: 2, Delay: "soon"}, func(int) error {
+		t.Error("the operation ran with an invalid policy")
+		return nil
+	}, func(int, time.Duration, error) {})
+
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("withRetries() = %v, want ErrInvalidInput", err)
+	}
+}
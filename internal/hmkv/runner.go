@@ -0,0 +1,97 @@
+package hmkv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+)
+
+// Runs the external processes (makemkvcon and HandBrakeCLI) that handymkv depends on.
+// Replacing the runner allows the external tools to be swapped out for recorded or scripted fakes.
+type CommandRunner interface {
+	// Runs the named command with the given arguments and waits for it to exit.
+	// Output written by the process is copied to stdout and stderr as it arrives. Either writer may be nil, in which case that output is discarded.
+	// If the process does not exit successfully an error is returned.
+	Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error
+}
+
+// The runner used for all external process invocations.
+var runner CommandRunner = execRunner{}
+
+// Sets the runner used for all external process invocations. Passing nil restores the default runner which executes real processes.
+func SetCommandRunner(r CommandRunner) {
+	if r == nil {
+		r = execRunner{}
+	}
+
+	runner = r
+}
+
+// Runs commands as real operating system processes.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	return cmd.Run()
+}
+
+// Runs the command and returns its standard output.
+func runOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+
+	err := runner.Run(ctx, name, args, &stdout, nil)
+
+	return stdout.Bytes(), err
+}
+
+// Runs the command and returns its combined standard output and standard error.
+func runCombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var output bytes.Buffer
+
+	err := runner.Run(ctx, name, args, &output, &output)
+
+	return output.Bytes(), err
+}
+
+// An io.Writer which splits written output into lines and passes each complete line to onLine.
+// Both '\n' and '\r' are treated as line terminators so that carriage return based progress output is split as well.
+type lineWriter struct {
+	onLine func(line string)
+	buf    []byte
+}
+
+func newLineWriter(onLine func(line string)) *lineWriter {
+	return &lineWriter{onLine: onLine}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' || b == '\r' {
+			w.emit()
+			continue
+		}
+
+		w.buf = append(w.buf, b)
+	}
+
+	return len(p), nil
+}
+
+// Passes any buffered partial line to onLine. Should be called once the writer will receive no more output.
+func (w *lineWriter) Flush() {
+	w.emit()
+}
+
+func (w *lineWriter) emit() {
+	if len(w.buf) == 0 {
+		return
+	}
+
+	line := string(w.buf)
+	w.buf = w.buf[:0]
+	w.onLine(line)
+}
@@ -0,0 +1,305 @@
+package hmkv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The output of makemkvcon -r info for a Blu-ray with a feature and an extra, as captured from a real drive.
+// The feature's file name contains a comma.
+const fakeDiscInfo = `MSG:1005,0,1,"MakeMKV v1.17.7 linux(x64-release) started","%1 started","MakeMKV v1.17.7 linux(x64-release)"
+DRV:0,2,999,12,"BD-RE HL-DT-ST BD-RE  BH16NS40 1.05","MY DISC","/dev/sr0"
+DRV:1,256,999,0,"","",""
+TCOUNT:2
+CINFO:1,6209,"Blu-ray disc"
+CINFO:2,0,"My Disc"
+TINFO:0,8,0,"24"
+TINFO:0,9,0,"2:01:32"
+TINFO:0,10,0,"31.6 GB"
+TINFO:0,11,0,"33973923840"
+TINFO:0,16,0,"00800.mpls"
+TINFO:0,25,0,"1"
+TINFO:0,26,0,"55"
+TINFO:0,27,0,"My Disc, Feature_t00.mkv"
+SINFO:0,0,1,6201,"Video"
+SINFO:0,0,19,0,"1920x1080"
+SINFO:0,0,21,0,"23.976 (24000/1001)"
+SINFO:0,1,1,6202,"Audio"
+SINFO:0,1,3,0,"eng"
+SINFO:0,1,4,0,"English"
+SINFO:0,1,14,0,"6"
+SINFO:0,1,40,0,"5.1(side)"
+SINFO:0,2,1,6203,"Subtitles"
+SINFO:0,2,3,0,"fre"
+TINFO:1,8,0,"0"
+TINFO:1,9,0,"0:04:10"
+TINFO:1,27,0,"My Disc_t01.mkv"
+MSG:5011,0,0,"Operation successfully completed","Operation successfully completed"
+`
+
+// The output of a successful makemkvcon rip of one title.
+const fakeRipOutput = `PRGT:5018,0,"Saving all titles to MKV files"
+PRGC:5017,0,"Saving to MKV file"
+PRGV:0,0,65536
+PRGV:32768,32768,65536
+PRGV:65536,65536,65536
+MSG:5036,260,1,"Copy complete. 1 titles saved.","Copy complete. %1 titles saved.","1"
+`
+
+// The progress output of a HandBrakeCLI encode.
+const fakeEncodeOutput = "Encoding: task 1 of 1, 45.23 % (87.10 fps, avg 90.20 fps, ETA 00h12m03s)\r" +
+	"Encoding: task 1 of 1, 100.00 % (88.00 fps, avg 90.50 fps, ETA 00h00m00s)\r"
+
+// A scripted stand-in for makemkvcon and HandBrakeCLI. Each call is answered by the first command whose name
+// matches and whose arguments are all present in the call. Calls which match no command fail.
+type fakeRunner struct {
+	mutex    sync.Mutex
+	commands []*fakeCommand
+	// The name and arguments of every call, in the order they were made.
+	calls [][]string
+}
+
+// A scripted response to the calls of a command.
+type fakeCommand struct {
+	// The name of the command. Example: makemkvcon
+	name string
+	// Arguments which must all be present for the command to answer a call. Empty matches any arguments.
+	args []string
+	// Written to the process's stdout and stderr.
+	stdout string
+	stderr string
+	// Returned once the output has been written.
+	err error
+	// Called with the arguments of the call before the output is written, to create the files the real tool would.
+	effect func(args []string) error
+	// If set, the command does not exit until the channel is closed or the context is done.
+	wait chan struct{}
+	// The number of calls the command answers. Zero for no limit. Later commands answer once it is used up,
+	// so that a failing attempt can be followed by a successful one.
+	times int
+	used  int
+}
+
+// Installs a fake runner answering with the commands for the duration of the test.
+func newFakeRunner(t *testing.T, commands ...*fakeCommand) *fakeRunner {
+	t.Helper()
+
+	r := &fakeRunner{commands: commands}
+
+	SetCommandRunner(r)
+	t.Cleanup(func() { SetCommandRunner(nil) })
+
+	return r
+}
+
+func (r *fakeRunner) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	r.mutex.Lock()
+
+	r.calls = append(r.calls, append([]string{name}, args...))
+
+	var command *fakeCommand
+
+	for _, c := range r.commands {
+		if c.matches(name, args) {
+			c.used++
+			command = c
+			break
+		}
+	}
+
+	r.mutex.Unlock()
+
+	if command == nil {
+		return fmt.Errorf("fake runner: unexpected command %s %s", name, strings.Join(args, " "))
+	}
+
+	if command.effect != nil {
+		if err := command.effect(args); err != nil {
+			return err
+		}
+	}
+
+	if stdout != nil {
+		io.WriteString(stdout, command.stdout)
+	}
+
+	if stderr != nil {
+		io.WriteString(stderr, command.stderr)
+	}
+
+	if command.wait != nil {
+		select {
+		case <-command.wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return command.err
+}
+
+// Returns the calls made to the named command.
+func (r *fakeRunner) callsTo(name string) [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	calls := make([][]string, 0)
+
+	for _, call := range r.calls {
+		if call[0] == name {
+			calls = append(calls, call[1:])
+		}
+	}
+
+	return calls
+}
+
+func (c *fakeCommand) matches(name string, args []string) bool {
+	if c.name != name || (c.times > 0 && c.used >= c.times) {
+		return false
+	}
+
+	for _, arg := range c.args {
+		if !slices.Contains(args, arg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Answers makemkvcon info calls with the output.
+func fakeInfo(output string) *fakeCommand {
+	return &fakeCommand{name: "makemkvcon", args: []string{"info"}, stdout: output}
+}
+
+// Answers makemkvcon rips of the title by writing the raw file into the destination directory.
+func fakeRip(titleIndex int, fileName string) *fakeCommand {
+	return &fakeCommand{
+		name:   "makemkvcon",
+		args:   []string{"mkv", strconv.Itoa(titleIndex)},
+		stdout: fakeRipOutput,
+		effect: func(args []string) error {
+			return os.WriteFile(filepath.Join(args[len(args)-1], fileName), make([]byte, 4096), 0644)
+		},
+	}
+}
+
+// Answers makemkvcon rips of the title with a read error.
+func fakeRipFailure(titleIndex int) *fakeCommand {
+	return &fakeCommand{
+		name:   "makemkvcon",
+		args:   []string{"mkv", strconv.Itoa(titleIndex)},
+		stdout: "MSG:5003,0,2,\"Failed to save title 0 to file\",\"Failed to save title %1 to file %2\",\"0\",\"t00.mkv\"\n",
+	}
+}
+
+// Answers HandBrakeCLI encodes by writing the encoded file.
+func fakeEncode() *fakeCommand {
+	return &fakeCommand{
+		name:   "HandBrakeCLI",
+		args:   []string{"--input"},
+		stdout: fakeEncodeOutput,
+		effect: func(args []string) error {
+			return os.WriteFile(argValue(args, "--output"), make([]byte, 1024), 0644)
+		},
+	}
+}
+
+// Returns the value following the flag in the arguments, or an empty string if the flag is not present.
+func argValue(args []string, flag string) string {
+	i := slices.Index(args, flag)
+
+	if i < 0 || i+1 >= len(args) {
+		return ""
+	}
+
+	return args[i+1]
+}
+
+// Writes the configuration file into a temporary directory and makes it the working directory for the duration of the
+// test. The output directories in the configuration are relative to it. Returns the directory.
+func useTestConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return dir
+}
+
+// A configuration which encodes with x264 into directories below the working directory.
+const testConfig = `{
+	"encoding_params": {"encoder": "x264", "quality": 20},
+	"mkv_output_directory": "mkv",
+	"handbrake_output_directory": "hb"
+}`
+
+func TestRunOutputUsesRunner(t *testing.T) {
+	r := newFakeRunner(t, &fakeCommand{name: "HandBrakeCLI", stdout: "out\n", stderr: "err\n"})
+
+	output, err := runOutput(context.Background(), "HandBrakeCLI", "--version")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(output) != "out\n" {
+		t.Errorf("runOutput() = %q, want only stdout", output)
+	}
+
+	output, err = runCombinedOutput(context.Background(), "HandBrakeCLI", "--version")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(output) != "out\nerr\n" {
+		t.Errorf("runCombinedOutput() = %q, want stdout and stderr", output)
+	}
+
+	if calls := r.callsTo("HandBrakeCLI"); len(calls) != 2 || calls[0][0] != "--version" {
+		t.Errorf("calls = %v, want two calls with --version", calls)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+
+	w := newLineWriter(func(line string) {
+		lines = append(lines, line)
+	})
+
+	io.WriteString(w, "first\r\nsec")
+	io.WriteString(w, "ond\rthird")
+	w.Flush()
+
+	want := []string{"first", "second", "third"}
+
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,352 @@
+package hmkv
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when titles would need to be selected interactively but standard input is not a terminal.
+var ErrNoTitleSelection = errors.New("no title selection provided and standard input is not a terminal - provide a title selection with the -t flag")
+
+// Used in serve and watch mode when neither the request nor the profile provide a title selection, as nobody is there
+// to answer a prompt.
+const defaultUnattendedTitleSelection = "all"
+
+// Selects titles according to a title selection expression.
+//
+// A selection is a comma delimited list of the following terms. A title is selected if any term matches it.
+//
+//	all              - every title
+//	<id>             - the title with the given ID. Example: 3
+//	<id>-<id>        - the titles with IDs in the inclusive range. Example: 0-5
+//	longest          - the title with the longest length
+//	min-length=<d>   - titles at least as long as the duration. Example: min-length=20m
+//
+// Any term prefixed with '!' excludes the titles it matches instead. Example: all,!3
+// If a selection only contains exclusions, every title not excluded is selected.
+// A title ID which is not among the titles is an error. If titles is nil only the syntax of the selection is checked.
+func selectTitles(selection string, titles []TitleInfo) ([]TitleInfo, error) {
+	// Remove invalid characters
+	selection = strings.ReplaceAll(selection, " ", "")
+	selection = strings.ReplaceAll(selection, "(", "")
+	selection = strings.ReplaceAll(selection, ")", "")
+	selection = strings.Trim(selection, ",")
+
+	if selection == "" {
+		return nil, fmt.Errorf("%w: empty title selection", ErrInvalidInput)
+	}
+
+	included := make(map[int]struct{})
+	excluded := make(map[int]struct{})
+	hasInclusions := false
+
+	for _, term := range strings.Split(selection, ",") {
+		exclude := strings.HasPrefix(term, "!")
+
+		ids, err := matchSelectionTerm(strings.TrimPrefix(term, "!"), titles)
+
+		if err != nil {
+			return nil, err
+		}
+
+		target := included
+
+		if exclude {
+			target = excluded
+		} else {
+			hasInclusions = true
+		}
+
+		for _, id := range ids {
+			target[id] = struct{}{}
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(titles), func(x TitleInfo) bool {
+		if _, ok := excluded[x.Index]; ok {
+			return true
+		}
+
+		_, ok := included[x.Index]
+
+		return hasInclusions && !ok
+	}), nil
+}
+
+// Returns the IDs of the titles matched by a single title selection term.
+func matchSelectionTerm(term string, titles []TitleInfo) ([]int, error) {
+	ids := make([]int, 0)
+
+	switch {
+	case term == "":
+		return nil, fmt.Errorf("%w: empty title selection term", ErrInvalidInput)
+	case term == "all":
+		for _, title := range titles {
+			ids = append(ids, title.Index)
+		}
+	case term == "longest":
+		longest := -1
+		var longestLength time.Duration
+
+		for _, title := range titles {
+			if length := title.Duration(); longest < 0 || length > longestLength {
+				longest = title.Index
+				longestLength = length
+			}
+		}
+
+		if longest >= 0 {
+			ids = append(ids, longest)
+		}
+	case strings.HasPrefix(term, "min-length="):
+		minLength, err := time.ParseDuration(strings.TrimPrefix(term, "min-length="))
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid minimum length in '%s'", ErrInvalidInput, term)
+		}
+
+		for _, title := range titles {
+			if title.Duration() >= minLength {
+				ids = append(ids, title.Index)
+			}
+		}
+	case strings.Contains(term, "-"):
+		rawStart, rawEnd, _ := strings.Cut(term, "-")
+
+		start, err := strconv.Atoi(rawStart)
+
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%w: invalid title range '%s'", ErrInvalidInput, term)
+		}
+
+		end, err := strconv.Atoi(rawEnd)
+
+		if err != nil || end < start {
+			return nil, fmt.Errorf("%w: invalid title range '%s'", ErrInvalidInput, term)
+		}
+
+		// Ranges are checked against the titles rather than expanded, as a range may be arbitrarily large
+		for _, title := range titles {
+			if start <= title.Index && title.Index <= end {
+				ids = append(ids, title.Index)
+			}
+		}
+	default:
+		id, err := strconv.Atoi(term)
+
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("%w: unrecognized title selection '%s'", ErrInvalidInput, term)
+		}
+
+		// A mistyped ID would otherwise leave the intended title out without a word
+		if titles != nil && !slices.ContainsFunc(titles, func(title TitleInfo) bool { return title.Index == id }) {
+			return nil, fmt.Errorf("%w: there is no title with ID %d", ErrInvalidInput, id)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Parses the value of the -t flag into exec options.
+// Each semicolon delimited selection applies to every disc unless it is prefixed with a drive and a colon.
+// The drive is given the same way as with the -d flag, as a disc index, a device path or an alias. Example: 0:1,2;/dev/sr1:longest
+func ParseTitleSelections(value string) (ExecOptions, error) {
+	options := ExecOptions{
+		TitleSelections: make(map[int]string),
+	}
+
+	for _, selection := range strings.Split(value, ";") {
+		selection = strings.TrimSpace(selection)
+
+		if selection == "" {
+			continue
+		}
+
+		rawDiscId, discSelection, ok := splitDriveSelection(selection)
+
+		if !ok {
+			options.DefaultTitleSelection = selection
+			continue
+		}
+
+		discSelection = strings.TrimSpace(discSelection)
+
+		if discSelection == "" {
+			return options, fmt.Errorf("%w: missing title selection after '%s'", ErrInvalidInput, rawDiscId)
+		}
+
+		discIds, err := ResolveDrives([]string{rawDiscId})
+
+		if err != nil {
+			return options, err
+		}
+
+		if len(discIds) != 1 {
+			return options, fmt.Errorf("%w: missing disc before '%s'", ErrInvalidInput, selection)
+		}
+
+		options.TitleSelections[discIds[0]] = discSelection
+	}
+
+	return options, nil
+}
+
+// Splits a title selection prefixed with a drive into the drive and the selection. Returns false if there is no drive prefix.
+// A Windows drive letter, such as the D: of D::1-3, is part of the drive. Title selections never contain a colon but
+// stable device paths can, such as /dev/disk/by-id/usb-BD-RE-0:0, so the last colon after it separates the drive.
+func splitDriveSelection(selection string) (string, string, bool) {
+	start := 0
+
+	if hasDriveLetterPrefix(selection) {
+		start = len("D:")
+	}
+
+	i := strings.LastIndex(selection[start:], ":")
+
+	if i < 0 {
+		return "", selection, false
+	}
+
+	i += start
+
+	return strings.TrimSpace(selection[:i]), selection[i+1:], true
+}
+
+// Reports whether the value starts with a Windows drive letter and colon which is followed by another colon or a path.
+func hasDriveLetterPrefix(value string) bool {
+	if len(value) < 3 || value[1] != ':' {
+		return false
+	}
+
+	letter := value[0] | 0x20
+
+	return letter >= 'a' && letter <= 'z' && strings.ContainsRune(`:\/`, rune(value[2]))
+}
+
+// Rules used to automatically hide titles before title selection.
+// Rules with a zero value are not applied.
+type titleFilters struct {
+	// Titles shorter than this duration are hidden. Example: 20m
+	MinDuration string `json:"min_duration,omitempty"`
+	// Titles longer than this duration are hidden. Example: 3h
+	MaxDuration string `json:"max_duration,omitempty"`
+	// Titles with fewer chapters are hidden.
+	MinChapters int `json:"min_chapters,omitempty"`
+	// Titles smaller than this size in megabytes are hidden.
+	MinSizeMB int64 `json:"min_size_mb,omitempty"`
+	// Only the N longest titles remaining after the other rules are applied are shown.
+	TopNByLength int `json:"top_n_by_length,omitempty"`
+}
+
+// A title hidden by the title filters along with the reason it was hidden.
+type hiddenTitle struct {
+	Title  TitleInfo
+	Reason string
+}
+
+// Reports whether any filter rules are set.
+func (f *titleFilters) enabled() bool {
+	return *f != titleFilters{}
+}
+
+// Checks that the filter rules are valid.
+func (f *titleFilters) validate() error {
+	if _, _, err := f.durations(); err != nil {
+		return err
+	}
+
+	if f.MinChapters < 0 || f.MinSizeMB < 0 || f.TopNByLength < 0 {
+		return fmt.Errorf("%w: title filter values cannot be negative", ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// Parses the minimum and maximum durations. Unset durations are returned as 0.
+func (f *titleFilters) durations() (time.Duration, time.Duration, error) {
+	var minDuration, maxDuration time.Duration
+	var err error
+
+	if f.MinDuration != "" {
+		minDuration, err = time.ParseDuration(f.MinDuration)
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: invalid title filter min_duration '%s'", ErrInvalidInput, f.MinDuration)
+		}
+	}
+
+	if f.MaxDuration != "" {
+		maxDuration, err = time.ParseDuration(f.MaxDuration)
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: invalid title filter max_duration '%s'", ErrInvalidInput, f.MaxDuration)
+		}
+	}
+
+	return minDuration, maxDuration, nil
+}
+
+// Applies the filter rules to the titles. Returns the titles which passed and the titles which were hidden.
+func (f *titleFilters) apply(titles []TitleInfo) ([]TitleInfo, []hiddenTitle, error) {
+	minDuration, maxDuration, err := f.durations()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make([]TitleInfo, 0, len(titles))
+	hidden := make([]hiddenTitle, 0)
+
+	for _, title := range titles {
+		var reason string
+
+		switch {
+		case minDuration > 0 && title.Duration() < minDuration:
+			reason = fmt.Sprintf("shorter than %s", minDuration)
+		case maxDuration > 0 && title.Duration() > maxDuration:
+			reason = fmt.Sprintf("longer than %s", maxDuration)
+		case f.MinChapters > 0 && title.Chapters < f.MinChapters:
+			reason = fmt.Sprintf("fewer than %d chapters", f.MinChapters)
+		case f.MinSizeMB > 0 && title.SizeBytes < f.MinSizeMB*1024*1024:
+			reason = fmt.Sprintf("smaller than %d MB", f.MinSizeMB)
+		}
+
+		if reason != "" {
+			hidden = append(hidden, hiddenTitle{Title: title, Reason: reason})
+			continue
+		}
+
+		kept = append(kept, title)
+	}
+
+	if f.TopNByLength > 0 && len(kept) > f.TopNByLength {
+		byLength := slices.Clone(kept)
+
+		slices.SortStableFunc(byLength, func(a, b TitleInfo) int {
+			return cmp.Compare(b.Duration(), a.Duration())
+		})
+
+		for _, title := range byLength[f.TopNByLength:] {
+			hidden = append(hidden, hiddenTitle{Title: title, Reason: fmt.Sprintf("not among the %d longest titles", f.TopNByLength)})
+		}
+
+		kept = slices.DeleteFunc(kept, func(x TitleInfo) bool {
+			return !slices.ContainsFunc(byLength[:f.TopNByLength], func(y TitleInfo) bool {
+				return x.Index == y.Index
+			})
+		})
+	}
+
+	slices.SortFunc(hidden, func(a, b hiddenTitle) int {
+		return cmp.Compare(a.Title.Index, b.Title.Index)
+	})
+
+	return kept, hidden, nil
+}
@@ -0,0 +1,310 @@
+package hmkv
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// Titles 0 to 4 with distinct lengths. Title 2 is the longest.
+var selectionTestTitles = []TitleInfo{
+	{Index: 0, Length: "0:01:30"},
+	{Index: 1, Length: "0:22:00"},
+	{Index: 2, Length: "1:45:10"},
+	{Index: 3, Length: "0:44:59"},
+	{Index: 4, Length: "0:00:12"},
+}
+
+func TestSelectTitles(t *testing.T) {
+	tests := []struct {
+		selection string
+		want      []int
+	}{
+		{selection: "all", want: []int{0, 1, 2, 3, 4}},
+		{selection: "3", want: []int{3}},
+		{selection: "1,3", want: []int{1, 3}},
+		{selection: "1-3", want: []int{1, 2, 3}},
+		{selection: "3-3", want: []int{3}},
+		{selection: "3-9999999999", want: []int{3, 4}},
+		{selection: "0-9999999999,!1-2", want: []int{0, 3, 4}},
+		{selection: "all,!3", want: []int{0, 1, 2, 4}},
+		{selection: "!3", want: []int{0, 1, 2, 4}},
+		{selection: "!0,!4", want: []int{1, 2, 3}},
+		{selection: "longest", want: []int{2}},
+		{selection: "longest,0", want: []int{0, 2}},
+		{selection: "min-length=20m", want: []int{1, 2, 3}},
+		{selection: "min-length=20m,!longest", want: []int{1, 3}},
+		{selection: " (1, 2) ", want: []int{1, 2}},
+		{selection: "2,", want: []int{2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.selection, func(t *testing.T) {
+			titles, err := selectTitles(test.selection, selectionTestTitles)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int, 0, len(titles))
+
+			for _, title := range titles {
+				got = append(got, title.Index)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("selectTitles(%q) = %v, want %v", test.selection, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectTitlesInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		",",
+		"a",
+		"5-2",
+		"-",
+		"-3",
+		"1-",
+		"!",
+		"1,!",
+		"min-length=abc",
+		// Titles which are not on the disc
+		"7",
+		"1,99",
+		"all,!7",
+	}
+
+	for _, selection := range tests {
+		t.Run(selection, func(t *testing.T) {
+			titles, err := selectTitles(selection, selectionTestTitles)
+
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("selectTitles(%q) = %v, %v, want ErrInvalidInput", selection, titles, err)
+			}
+		})
+	}
+}
+
+func TestSelectTitlesSyntaxOnly(t *testing.T) {
+	if _, err := selectTitles("1,99,!7", nil); err != nil {
+		t.Errorf("selectTitles() without titles = %v, want only the syntax checked", err)
+	}
+
+	if _, err := selectTitles("5-2", nil); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("selectTitles(\"5-2\") without titles = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestSelectLongestOfNoTitles(t *testing.T) {
+	titles, err := selectTitles("longest", nil)
+
+	if err != nil || len(titles) != 0 {
+		t.Errorf("selectTitles(\"longest\", nil) = %v, %v, want no titles", titles, err)
+	}
+}
+
+// Titles 0 to 5 for the title filters. Titles 3 and 4 are the same length and title 5 is a play-all title.
+var filterTestTitles = []TitleInfo{
+	{Index: 0, Length: "0:01:30", Chapters: 1, SizeBytes: 50 << 20},
+	{Index: 1, Length: "0:22:00", Chapters: 6, SizeBytes: 900 << 20},
+	{Index: 2, Length: "1:45:10", Chapters: 24, SizeBytes: 30000 << 20},
+	{Index: 3, Length: "0:44:59", Chapters: 12, SizeBytes: 2000 << 20},
+	{Index: 4, Length: "0:44:59", Chapters: 12, SizeBytes: 2000 << 20},
+	{Index: 5, Length: "4:10:00", Chapters: 2, SizeBytes: 40000 << 20},
+}
+
+func TestTitleFiltersApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters titleFilters
+		want    []int
+		// The hidden titles as "index: reason", in index order.
+		wantHidden []string
+	}{
+		{
+			name:       "no rules",
+			want:       []int{0, 1, 2, 3, 4, 5},
+			wantHidden: []string{},
+		},
+		{
+			name:       "min duration",
+			filters:    titleFilters{MinDuration: "20m"},
+			want:       []int{1, 2, 3, 4, 5},
+			wantHidden: []string{"0: shorter than 20m0s"},
+		},
+		{
+			name:       "max duration",
+			filters:    titleFilters{MaxDuration: "3h"},
+			want:       []int{0, 1, 2, 3, 4},
+			wantHidden: []string{"5: longer than 3h0m0s"},
+		},
+		{
+			name:       "min chapters",
+			filters:    titleFilters{MinChapters: 10},
+			want:       []int{2, 3, 4},
+			wantHidden: []string{"0: fewer than 10 chapters", "1: fewer than 10 chapters", "5: fewer than 10 chapters"},
+		},
+		{
+			name:       "min size",
+			filters:    titleFilters{MinSizeMB: 1000},
+			want:       []int{2, 3, 4, 5},
+			wantHidden: []string{"0: smaller than 1000 MB", "1: smaller than 1000 MB"},
+		},
+		{
+			name:       "duration reason takes precedence",
+			filters:    titleFilters{MinDuration: "20m", MinChapters: 10},
+			want:       []int{2, 3, 4},
+			wantHidden: []string{"0: shorter than 20m0s", "1: fewer than 10 chapters", "5: fewer than 10 chapters"},
+		},
+		{
+			name:    "top n",
+			filters: titleFilters{TopNByLength: 2},
+			want:    []int{2, 5},
+			wantHidden: []string{
+				"0: not among the 2 longest titles",
+				"1: not among the 2 longest titles",
+				"3: not among the 2 longest titles",
+				"4: not among the 2 longest titles",
+			},
+		},
+		{
+			name:    "top n after the other rules",
+			filters: titleFilters{MinDuration: "20m", MaxDuration: "3h", TopNByLength: 2},
+			// Titles 3 and 4 tie for second place and the lower index is kept
+			want: []int{2, 3},
+			wantHidden: []string{
+				"0: shorter than 20m0s",
+				"1: not among the 2 longest titles",
+				"4: not among the 2 longest titles",
+				"5: longer than 3h0m0s",
+			},
+		},
+		{
+			name:       "top n larger than the titles left",
+			filters:    titleFilters{MinChapters: 10, TopNByLength: 3},
+			want:       []int{2, 3, 4},
+			wantHidden: []string{"0: fewer than 10 chapters", "1: fewer than 10 chapters", "5: fewer than 10 chapters"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept, hidden, err := test.filters.apply(filterTestTitles)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int, 0, len(kept))
+
+			for _, title := range kept {
+				got = append(got, title.Index)
+			}
+
+			gotHidden := make([]string, 0, len(hidden))
+
+			for _, h := range hidden {
+				gotHidden = append(gotHidden, fmt.Sprintf("%d: %s", h.Title.Index, h.Reason))
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("kept = %v, want %v", got, test.want)
+			}
+
+			if !slices.Equal(gotHidden, test.wantHidden) {
+				t.Errorf("hidden = %q, want %q", gotHidden, test.wantHidden)
+			}
+		})
+	}
+}
+
+func TestTitleFiltersInvalid(t *testing.T) {
+	tests := []titleFilters{
+		{MinDuration: "20"},
+		{MaxDuration: "long"},
+		{MinChapters: -1},
+		{TopNByLength: -2},
+	}
+
+	for _, filters := range tests {
+		if err := filters.validate(); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("validate() of %+v = %v, want ErrInvalidInput", filters, err)
+		}
+	}
+
+	if _, _, err := (&titleFilters{MinDuration: "20"}).apply(filterTestTitles); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("apply() with an invalid duration = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestParseTitleSelections(t *testing.T) {
+	useTestConfig(t, testConfig)
+	newFakeRunner(t, fakeInfo(`DRV:0,2,999,12,"BD-RE","A DISC","D:"
+DRV:1,2,999,12,"DVD-RW","B DISC","/dev/disk/by-id/usb-DVD-RW-0:0"
+DRV:2,256,999,0,"","",""
+`))
+
+	tests := []struct {
+		value       string
+		wantDefault string
+		want        map[int]string
+	}{
+		{value: "all", wantDefault: "all", want: map[int]string{}},
+		{value: "1-3", wantDefault: "1-3", want: map[int]string{}},
+		{value: "0:1,2", want: map[int]string{0: "1,2"}},
+		{value: " 0 : longest ; 1:all;", want: map[int]string{0: "longest", 1: "all"}},
+		{value: "longest;1:0-5", wantDefault: "longest", want: map[int]string{1: "0-5"}},
+		{value: "D::1-3", want: map[int]string{0: "1-3"}},
+		{value: "/dev/disk/by-id/usb-DVD-RW-0:0:longest", want: map[int]string{1: "longest"}},
+		{value: "D::1-3;/dev/disk/by-id/usb-DVD-RW-0:0:!0", want: map[int]string{0: "1-3", 1: "!0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			options, err := ParseTitleSelections(test.value)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if options.DefaultTitleSelection != test.wantDefault {
+				t.Errorf("DefaultTitleSelection = %q, want %q", options.DefaultTitleSelection, test.wantDefault)
+			}
+
+			if !maps.Equal(options.TitleSelections, test.want) {
+				t.Errorf("TitleSelections = %v, want %v", options.TitleSelections, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTitleSelectionsInvalid(t *testing.T) {
+	useTestConfig(t, testConfig)
+	newFakeRunner(t, fakeInfo(`DRV:0,2,999,12,"BD-RE","A DISC","D:"
+`))
+
+	tests := []struct {
+		value   string
+		wantErr error
+	}{
+		{value: "0:", wantErr: ErrInvalidInput},
+		{value: "D::", wantErr: ErrInvalidInput},
+		{value: ":1-3", wantErr: ErrInvalidInput},
+		{value: "-1:all", wantErr: ErrInvalidInput},
+		{value: "E::1-3", wantErr: ErrDriveNotFound},
+		{value: "/dev/sr9:all", wantErr: ErrDriveNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if _, err := ParseTitleSelections(test.value); !errors.Is(err, test.wantErr) {
+				t.Errorf("ParseTitleSelections(%q) = %v, want %v", test.value, err, test.wantErr)
+			}
+		})
+	}
+}
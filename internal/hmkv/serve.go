@@ -0,0 +1,913 @@
+package hmkv
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeListenAddress = ":8080"
+	// The number of events buffered for each event stream client. Clients which fall further behind are disconnected.
+	serveEventBufferSize = 256
+	// How often a comment is sent to idle event stream clients so that proxies do not close the connection.
+	serveKeepAliveInterval = 15 * time.Second
+	// How long in-flight requests are given to complete when the server stops.
+	serveShutdownTimeout = 5 * time.Second
+	// The largest job request body accepted. Job requests are only a few fields.
+	serveMaxRequestBytes = 64 * 1024
+)
+
+var ErrServeTokenRequired = errors.New("serve mode requires an access token - set the 'token' in the 'serve' section of the configuration file")
+
+// Returned when a job is submitted after the server has started to stop.
+var errServerStopping = errors.New("the server is shutting down")
+
+// Returned when none of a job's titles were selected.
+var errNoTitlesSelected = errors.New("no titles were selected")
+
+// Settings for serve mode.
+type serveConfig struct {
+	// The address the HTTP API listens on. Example: :8080
+	ListenAddress string `json:"listen_address,omitempty"`
+	// The bearer token clients must provide to use the API.
+	Token string `json:"token,omitempty"`
+}
+
+// Returns the listen address, using the default if unset.
+func (c *serveConfig) address() string {
+	if c.ListenAddress == "" {
+		return defaultServeListenAddress
+	}
+
+	return c.ListenAddress
+}
+
+// The state of a job submitted to the server.
+type jobState string
+
+const (
+	// The job's discs are being scanned, ripped or encoded.
+	jobRunning jobState = "running"
+	// Every title of the job was ripped and encoded.
+	jobCompleted jobState = "completed"
+	// The job stopped because of an error, or some of its titles failed.
+	jobFailed jobState = "failed"
+	// The job was cancelled or the server was stopped before it completed.
+	jobCancelled jobState = "cancelled"
+)
+
+// A rip and encode job submitted to the server. Fields other than the request are guarded by the server's mutex.
+type job struct {
+	id             string
+	drives         []int
+	profile        string
+	titleSelection string
+	createdAt      time.Time
+	cancel         context.CancelFunc
+
+	state      jobState
+	err        error
+	finishedAt time.Time
+	titles     []titleStatus
+	summary    *RunSummary
+}
+
+// The JSON representation of a job. The field names are part of the API and must not change.
+type jobView struct {
+	ID             string          `json:"id"`
+	Drives         []int           `json:"drives"`
+	Profile        string          `json:"profile,omitempty"`
+	TitleSelection string          `json:"title_selection"`
+	State          jobState        `json:"state"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+	Titles         []titleProgress `json:"titles"`
+	// The summary of the run, once processing has completed or stopped.
+	Summary *RunSummary `json:"summary,omitempty"`
+}
+
+// The JSON representation of the progress of a title. The field names are part of the API and must not change.
+type titleProgress struct {
+	JobID            string      `json:"job_id"`
+	DiscId           int         `json:"disc_id"`
+	TitleIndex       int         `json:"title_index"`
+	FileName         string      `json:"file_name"`
+	Ripping          statusValue `json:"ripping"`
+	Encoding         statusValue `json:"encoding"`
+	RipPercent       float64     `json:"rip_percent"`
+	RipOperation     string      `json:"rip_operation,omitempty"`
+	RipAttempts      int         `json:"rip_attempts"`
+	EncodePercent    float64     `json:"encode_percent"`
+	EncodeFPS        float64     `json:"encode_fps"`
+	EncodeAvgFPS     float64     `json:"encode_avg_fps"`
+	EncodeETASeconds float64     `json:"encode_eta_seconds"`
+	EncodeAttempts   int         `json:"encode_attempts"`
+	EncodeOperation  string      `json:"encode_operation,omitempty"`
+	Error            string      `json:"error,omitempty"`
+	LogPath          string      `json:"log_path,omitempty"`
+}
+
+// The body of a job submission.
+type jobRequest struct {
+	// The drives to rip from. Each is a drive index or an alias from the configuration file. Defaults to drive 0.
+	Drives []string `json:"drives"`
+	// The title selection applied to every disc. Defaults to the profile's selection, or all titles.
+	TitleSelection string `json:"title_selection"`
+	// The name of the configured profile to use. Empty for none.
+	Profile string `json:"profile"`
+	// If true, failed titles do not stop the job.
+	ContinueOnError bool `json:"continue_on_error"`
+}
+
+// An event sent to event stream clients.
+type serverEvent struct {
+	// The event type. Either "job" or "title".
+	name  string
+	jobId string
+	data  any
+}
+
+// An event stream client.
+type subscriber struct {
+	// The job whose events are sent to the client. Empty for all jobs.
+	jobId  string
+	events chan serverEvent
+}
+
+// Runs jobs submitted over the HTTP API and keeps track of their progress.
+type server struct {
+	// Cancelled when the server stops. Jobs are cancelled along with it.
+	ctx   context.Context
+	token string
+
+	mutex      sync.Mutex
+	jobs       []*job
+	nextJobId  int
+	busyDrives map[int]string
+	// The drives whose titles are being scanned for a title listing request.
+	scanningDrives map[int]struct{}
+	subscribers    map[*subscriber]struct{}
+	jobsRunning    sync.WaitGroup
+}
+
+func newServer(ctx context.Context, token string) *server {
+	return &server{
+		ctx:            ctx,
+		token:          token,
+		busyDrives:     make(map[int]string),
+		scanningDrives: make(map[int]struct{}),
+		subscribers:    make(map[*subscriber]struct{}),
+	}
+}
+
+// Serves the HTTP API until an interrupt or termination signal is received. Running jobs are cancelled when the
+// server stops, and their partial files are cleaned up before returning.
+func Serve() error {
+	config, err := ReadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if config.Serve.Token == "" {
+		return ErrServeTokenRequired
+	}
+
+	interrupts := handleInterrupts()
+	defer interrupts.stop()
+
+	ctx := interrupts.ctx
+
+	s := newServer(ctx, config.Serve.Token)
+
+	httpServer := &http.Server{
+		Addr:              config.Serve.address(),
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	// Closed once the MQTT bridge has published the final state of the jobs and disconnected
+	var mqttDone <-chan struct{}
+
+	if config.MQTT.Broker != "" {
+		mqttDone = newMQTTBridge(s, config.MQTT).start(ctx)
+	}
+
+	timestampedLog("Serving the HTTP API on %s. Press Ctrl-C to stop.", httpServer.Addr)
+
+	err = httpServer.ListenAndServe()
+
+	if err != http.ErrServerClosed {
+		return fmt.Errorf("an error occurred while serving the HTTP API - %w", err)
+	}
+
+	timestampedLog("Stopping. Waiting for running jobs to clean up...")
+	s.jobsRunning.Wait()
+
+	if mqttDone != nil {
+		<-mqttDone
+	}
+
+	return ErrInterrupted
+}
+
+// Returns the handler of the HTTP API, the dashboard and the metrics. The dashboard's files are served without a token,
+// the dashboard asks for the token and sends it with its API requests. Metrics are also served without a token
+// so that Prometheus can scrape them without extra configuration.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/profiles", s.handleListProfiles)
+	mux.HandleFunc("GET /api/drives", s.handleListDrives)
+	mux.HandleFunc("GET /api/drives/{drive}/titles", s.handleListTitles)
+	mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	mux.HandleFunc("POST /api/jobs", s.handleSubmitJob)
+	mux.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.handleCancelJob)
+	mux.HandleFunc("GET /api/jobs/{id}/events", s.handleJobEvents)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+
+	root := http.NewServeMux()
+
+	root.Handle("/api/", s.authorize(mux))
+	root.Handle("GET /metrics", metricsHandler())
+	root.Handle("/", dashboardHandler())
+
+	return root
+}
+
+// Rejects requests which do not carry the configured bearer token.
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="handymkv"`)
+			writeErrorResponse(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handleListProfiles(w http.ResponseWriter, r *http.Request) {
+	config, err := ReadConfig()
+
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, config.profileNames())
+}
+
+func (s *server) handleListDrives(w http.ResponseWriter, r *http.Request) {
+	drives, err := ListDrives()
+
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("an error occurred while listing the drives - %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, drives)
+}
+
+func (s *server) handleListTitles(w http.ResponseWriter, r *http.Request) {
+	drives, ok := resolveRequestDrives(w, []string{r.PathValue("drive")})
+
+	if !ok {
+		return
+	}
+
+	// makemkvcon cannot scan a disc which is being ripped, and a job must not start ripping a disc which is being scanned
+	s.mutex.Lock()
+	err := s.checkDriveFree(drives[0])
+
+	if err == nil {
+		s.scanningDrives[drives[0]] = struct{}{}
+	}
+
+	s.mutex.Unlock()
+
+	if err != nil {
+		writeErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	// The scan is stopped if the client goes away
+	titles, err := getTitles(r.Context(), drives[0])
+
+	s.mutex.Lock()
+	delete(s.scanningDrives, drives[0])
+	s.mutex.Unlock()
+
+	if err != nil {
+		var discErr *DiscError
+
+		if errors.As(err, &discErr) {
+			writeErrorResponse(w, http.StatusNotFound, discErr.Msg)
+			return
+		}
+
+		writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Only list the titles a job would offer for selection
+	if r.URL.Query().Get("filtered") == "true" {
+		options := ExecOptions{Profile: r.URL.Query().Get("profile")}
+
+		config, err := readRunConfig(&options)
+
+		if err == nil {
+			titles, _, err = config.TitleFilters.apply(titles)
+		}
+
+		if err != nil {
+			if errors.Is(err, ErrProfileNotFound) {
+				writeErrorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	writeResponse(w, http.StatusOK, titles)
+}
+
+func (s *server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	views := make([]jobView, 0, len(s.jobs))
+
+	for _, j := range s.jobs {
+		views = append(views, j.view())
+	}
+
+	writeResponse(w, http.StatusOK, views)
+}
+
+func (s *server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var request jobRequest
+
+	r.Body = http.MaxBytesReader(w, r.Body, serveMaxRequestBytes)
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		var tooLargeErr *http.MaxBytesError
+
+		if errors.As(err, &tooLargeErr) {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("job requests cannot be larger than %d bytes", tooLargeErr.Limit))
+			return
+		}
+
+		writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid job request - %v", err))
+		return
+	}
+
+	view, err := s.submitJob(request)
+
+	if err != nil {
+		var busyErr *driveBusyError
+
+		switch {
+		case errors.As(err, &busyErr):
+			writeErrorResponse(w, http.StatusConflict, err.Error())
+		case errors.Is(err, errServerStopping):
+			writeErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+		case errors.Is(err, ErrDriveNotFound):
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrProfileNotFound) || errors.Is(err, ErrInvalidInput):
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		default:
+			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	writeResponse(w, http.StatusAccepted, view)
+}
+
+// Returned when a job needs a drive which is in use by another job.
+type driveBusyError struct {
+	drive int
+	// Empty if the drive's titles are being scanned rather than used by a job.
+	jobId string
+}
+
+func (e *driveBusyError) Error() string {
+	if e.jobId == "" {
+		return fmt.Sprintf("drive %d is being scanned", e.drive)
+	}
+
+	return fmt.Sprintf("drive %d is in use by job %s", e.drive, e.jobId)
+}
+
+// Returns a driveBusyError if a job is using the drive or its titles are being scanned. The mutex must be held.
+func (s *server) checkDriveFree(drive int) error {
+	if jobId, busy := s.busyDrives[drive]; busy {
+		return &driveBusyError{drive: drive, jobId: jobId}
+	}
+
+	if _, scanning := s.scanningDrives[drive]; scanning {
+		return &driveBusyError{drive: drive}
+	}
+
+	return nil
+}
+
+// Starts a job for the request and returns its initial state. The job runs in the background.
+func (s *server) submitJob(request jobRequest) (jobView, error) {
+	if len(request.Drives) < 1 {
+		request.Drives = []string{"0"}
+	}
+
+	drives, err := ResolveDrives(request.Drives)
+
+	if err != nil {
+		return jobView{}, err
+	}
+
+	if len(drives) < 1 {
+		return jobView{}, fmt.Errorf("%w: no drives were given", ErrInvalidInput)
+	}
+
+	options := ExecOptions{
+		TitleSelections:       make(map[int]string),
+		DefaultTitleSelection: request.TitleSelection,
+		ContinueOnError:       request.ContinueOnError,
+		Profile:               request.Profile,
+	}
+
+	// The configuration is read for every job so that changes are picked up without restarting
+	config, err := readRunConfig(&options)
+
+	if err != nil {
+		return jobView{}, err
+	}
+
+	if options.DefaultTitleSelection == "" {
+		options.DefaultTitleSelection = defaultUnattendedTitleSelection
+	}
+
+	// Reject a malformed selection now rather than failing the job once its discs have been scanned
+	if _, err := selectTitles(options.DefaultTitleSelection, nil); err != nil {
+		return jobView{}, err
+	}
+
+	if err := createOutputDirectories(config); err != nil {
+		return jobView{}, err
+	}
+
+	s.mutex.Lock()
+
+	// Serve waits for the running jobs once the context is done, so no job may be added after that
+	if s.ctx.Err() != nil {
+		s.mutex.Unlock()
+		return jobView{}, errServerStopping
+	}
+
+	for _, drive := range drives {
+		if err := s.checkDriveFree(drive); err != nil {
+			s.mutex.Unlock()
+			return jobView{}, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	s.nextJobId++
+
+	j := &job{
+		id:             strconv.Itoa(s.nextJobId),
+		drives:         drives,
+		profile:        request.Profile,
+		titleSelection: options.DefaultTitleSelection,
+		createdAt:      time.Now(),
+		cancel:         cancel,
+		state:          jobRunning,
+		titles:         make([]titleStatus, 0),
+	}
+
+	s.jobs = append(s.jobs, j)
+
+	for _, drive := range drives {
+		s.busyDrives[drive] = j.id
+	}
+
+	view := j.view()
+	s.publish(serverEvent{name: "job", jobId: j.id, data: view})
+	s.jobsRunning.Add(1)
+
+	s.mutex.Unlock()
+
+	timestampedLog("Job %s submitted for drives %v", j.id, drives)
+
+	go s.runJob(ctx, j, config, options)
+
+	return view, nil
+}
+
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j := s.findJob(r.PathValue("id"))
+
+	if j == nil {
+		writeErrorResponse(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	writeResponse(w, http.StatusOK, j.view())
+}
+
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	j := s.findJob(r.PathValue("id"))
+	s.mutex.Unlock()
+
+	if j == nil {
+		writeErrorResponse(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	// The job's state changes once its partial files have been cleaned up
+	j.cancel()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeResponse(w, http.StatusAccepted, j.view())
+}
+
+func (s *server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	jobId := r.PathValue("id")
+
+	s.mutex.Lock()
+	j := s.findJob(jobId)
+	s.mutex.Unlock()
+
+	if j == nil {
+		writeErrorResponse(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	s.streamEvents(w, r, jobId)
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, "")
+}
+
+// Streams job and title events to the client as server-sent events. The current state of the matching jobs is sent
+// first. A stream for a single job ends once the job has finished.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, jobId string) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		writeErrorResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	sub, snapshot := s.subscribe(jobId)
+	defer s.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range snapshot {
+		if writeServerEvent(w, event) != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(serveKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		if jobId != "" && s.jobFinished(jobId) && len(sub.events) == 0 {
+			return
+		}
+
+		select {
+		case event, ok := <-sub.events:
+			// The client fell too far behind and was dropped, it will reconnect and receive the current state
+			if !ok {
+				return
+			}
+
+			if writeServerEvent(w, event) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// Registers an event stream client for the job, or for all jobs if the job id is empty. Returns the client along with
+// the events describing the current state of the matching jobs.
+func (s *server) subscribe(jobId string) (*subscriber, []serverEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub := &subscriber{
+		jobId:  jobId,
+		events: make(chan serverEvent, serveEventBufferSize),
+	}
+
+	s.subscribers[sub] = struct{}{}
+
+	snapshot := make([]serverEvent, 0)
+
+	for _, j := range s.jobs {
+		if jobId == "" || j.id == jobId {
+			snapshot = append(snapshot, serverEvent{name: "job", jobId: j.id, data: j.view()})
+		}
+	}
+
+	return sub, snapshot
+}
+
+// Removes an event stream client.
+func (s *server) unsubscribe(sub *subscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Sends the event to the matching event stream clients. Clients whose buffer is full are disconnected rather than
+// blocking the job. Must be called with the mutex held.
+func (s *server) publish(event serverEvent) {
+	for sub := range s.subscribers {
+		if sub.jobId != "" && sub.jobId != event.jobId {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Reports whether the job has finished.
+func (s *server) jobFinished(jobId string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j := s.findJob(jobId)
+
+	return j == nil || j.state != jobRunning
+}
+
+// Returns the job with the given id, or nil if there is none. Must be called with the mutex held.
+func (s *server) findJob(id string) *job {
+	for _, j := range s.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+
+	return nil
+}
+
+// Scans, rips and encodes the job's discs and records the outcome.
+func (s *server) runJob(ctx context.Context, j *job, config *handyMKVConfig, options ExecOptions) {
+	defer s.jobsRunning.Done()
+	defer j.cancel()
+
+	options.hooks = &runHooks{
+		ctx: ctx,
+		onStatus: func(status titleStatus) {
+			s.updateJobTitle(j, status)
+		},
+		onSummary: func(summary RunSummary) {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			j.summary = &summary
+		},
+	}
+
+	err := processJob(ctx, config, j.drives, options)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j.err = err
+	j.finishedAt = time.Now()
+
+	switch {
+	case err == nil:
+		j.state = jobCompleted
+	case err == ErrCancelled || err == ErrInterrupted:
+		j.state = jobCancelled
+	default:
+		j.state = jobFailed
+	}
+
+	for _, drive := range j.drives {
+		delete(s.busyDrives, drive)
+	}
+
+	s.publish(serverEvent{name: "job", jobId: j.id, data: j.view()})
+
+	if err != nil {
+		timestampedLog("Job %s %s - %v", j.id, j.state, err)
+	} else {
+		timestampedLog("Job %s %s", j.id, j.state)
+	}
+}
+
+// Reads and selects the titles of the discs and processes them.
+func processJob(ctx context.Context, config *handyMKVConfig, drives []int, options ExecOptions) error {
+	titles, err := readAndSelectTitles(ctx, config, drives, options)
+
+	// Scanning the discs is stopped when the job is cancelled
+	if err != nil && ctx.Err() != nil {
+		return ErrCancelled
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(titles) < 1 {
+		return errNoTitlesSelected
+	}
+
+	// Do not create a run directory for a job which was cancelled while its discs were being scanned
+	if ctx.Err() != nil {
+		return ErrCancelled
+	}
+
+	return startRun(config, titles, options)
+}
+
+// Records the new status of one of the job's titles and sends it to event stream clients.
+func (s *server) updateJobTitle(j *job, status titleStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	index := slices.IndexFunc(j.titles, func(t titleStatus) bool {
+		return t.DiscId == status.DiscId && t.TitleIndex == status.TitleIndex
+	})
+
+	if index < 0 {
+		j.titles = append(j.titles, status)
+	} else {
+		j.titles[index] = status
+	}
+
+	s.publish(serverEvent{name: "title", jobId: j.id, data: newTitleProgress(j.id, status)})
+}
+
+// Returns the JSON representation of the job. Must be called with the server's mutex held.
+func (j *job) view() jobView {
+	view := jobView{
+		ID:             j.id,
+		Drives:         j.drives,
+		Profile:        j.profile,
+		TitleSelection: j.titleSelection,
+		State:          j.state,
+		CreatedAt:      j.createdAt,
+		Titles:         make([]titleProgress, 0, len(j.titles)),
+		Summary:        j.summary,
+	}
+
+	if j.err != nil {
+		view.Error = j.err.Error()
+	}
+
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		view.FinishedAt = &finishedAt
+	}
+
+	for _, status := range j.titles {
+		view.Titles = append(view.Titles, newTitleProgress(j.id, status))
+	}
+
+	return view
+}
+
+// Returns the JSON representation of the status of a title.
+func newTitleProgress(jobId string, status titleStatus) titleProgress {
+	progress := titleProgress{
+		JobID:            jobId,
+		DiscId:           status.DiscId,
+		TitleIndex:       status.TitleIndex,
+		FileName:         status.Title,
+		Ripping:          status.Ripping,
+		Encoding:         status.Encoding,
+		RipPercent:       status.RipPercent,
+		RipOperation:     status.RipOperation,
+		RipAttempts:      status.RipAttempts,
+		EncodePercent:    status.EncodePercent,
+		EncodeFPS:        status.EncodeFPS,
+		EncodeAvgFPS:     status.EncodeAvgFPS,
+		EncodeETASeconds: status.EncodeETA.Seconds(),
+		EncodeAttempts:   status.EncodeAttempts,
+		EncodeOperation:  status.EncodeOperation,
+		LogPath:          status.LogPath,
+	}
+
+	if status.Err != nil {
+		progress.Error = status.Err.Error()
+	}
+
+	return progress
+}
+
+// Resolves the drive identifiers of a request, writing an error response if they cannot be resolved.
+func resolveRequestDrives(w http.ResponseWriter, ids []string) ([]int, bool) {
+	drives, err := ResolveDrives(ids)
+
+	if err != nil {
+		if errors.Is(err, ErrDriveNotFound) {
+			writeErrorResponse(w, http.StatusNotFound, err.Error())
+		} else if errors.Is(err, ErrInvalidInput) {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+
+		return nil, false
+	}
+
+	if len(drives) < 1 {
+		writeErrorResponse(w, http.StatusBadRequest, "no drives were given")
+		return nil, false
+	}
+
+	return drives, true
+}
+
+// Writes the value as a JSON response.
+func writeResponse(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	writeJSON(w, v)
+}
+
+// Writes a JSON error response.
+func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+	writeResponse(w, statusCode, map[string]string{"error": message})
+}
+
+// Writes the event in the server-sent events format.
+func writeServerEvent(w http.ResponseWriter, event serverEvent) error {
+	data, err := json.Marshal(event.data)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+
+	return err
+}
@@ -0,0 +1,395 @@
+package hmkv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServeToken = "test-token"
+
+// Starts the HTTP API of a server using the test configuration. Running jobs are cancelled and waited for at the end
+// of the test.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	useTestConfig(t, testConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newServer(ctx, testServeToken)
+	ts := httptest.NewServer(s.handler())
+
+	t.Cleanup(func() {
+		cancel()
+		s.jobsRunning.Wait()
+		ts.Close()
+	})
+
+	return ts
+}
+
+// Sends an authorized request to the test server and decodes the JSON response into v, if v is not nil.
+func serveRequest(t *testing.T, ts *httptest.Server, method, path, body string, v any) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+testServeToken)
+
+	response, err := ts.Client().Do(request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s - the response is not valid JSON - %v", method, path, err)
+		}
+	}
+
+	return response
+}
+
+// Polls the job until it has finished and returns its final state.
+func waitForJob(t *testing.T, ts *httptest.Server, jobId string) jobView {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		var view jobView
+
+		serveRequest(t, ts, http.MethodGet, "/api/jobs/"+jobId, "", &view)
+
+		if view.State != jobRunning {
+			return view
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s = %+v, want it to finish", jobId, view)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServeRequiresToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "no token"},
+		{name: "wrong token", authorization: "Bearer wrong"},
+		{name: "not a bearer token", authorization: testServeToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, ts.URL+"/api/jobs", nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			response, err := ts.Client().Do(request)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusUnauthorized || response.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("status = %d, want 401 with a WWW-Authenticate header", response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestServeSubmitJob(t *testing.T) {
+	ts := newTestServer(t)
+	r := newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+	)
+
+	var submitted jobView
+
+	response := serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"], "title_selection": "1"}`, &submitted)
+
+	if response.StatusCode != http.StatusAccepted || submitted.ID == "" || submitted.State != jobRunning {
+		t.Fatalf("submit = %d %+v, want 202 with a running job", response.StatusCode, submitted)
+	}
+
+	view := waitForJob(t, ts, submitted.ID)
+
+	if view.State != jobCompleted || len(view.Titles) != 1 || view.Titles[0].TitleIndex != 1 || view.Summary == nil {
+		t.Errorf("job = %+v, want title 1 completed with a summary", view)
+	}
+
+	if encodes := r.callsTo("HandBrakeCLI"); len(encodes) != 1 {
+		t.Errorf("HandBrakeCLI calls = %v, want the selected title encoded", encodes)
+	}
+}
+
+func TestServeSubmitInvalidJob(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{body: `not json`, want: http.StatusBadRequest},
+		{body: `{"drives": ["-1"]}`, want: http.StatusBadRequest},
+		{body: `{"profile": "missing"}`, want: http.StatusBadRequest},
+		{body: `{"title_selection": "5-2"}`, want: http.StatusBadRequest},
+		{body: `{"title_selection": "all,!"}`, want: http.StatusBadRequest},
+		{body: `{"title_selection": "` + strings.Repeat("0,", serveMaxRequestBytes) + `0"}`, want: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		response := serveRequest(t, ts, http.MethodPost, "/api/jobs", test.body, nil)
+
+		if response.StatusCode != test.want {
+			t.Errorf("submit %.40s = %d, want %d", test.body, response.StatusCode, test.want)
+		}
+	}
+
+	var jobs []jobView
+
+	if serveRequest(t, ts, http.MethodGet, "/api/jobs", "", &jobs); len(jobs) != 0 {
+		t.Errorf("jobs = %+v, want no job started for an invalid request", jobs)
+	}
+}
+
+func TestServeBusyDriveAndCancel(t *testing.T) {
+	ts := newTestServer(t)
+
+	// The rip does not finish until the job is cancelled
+	rip := fakeRip(0, "My Disc, Feature_t00.mkv")
+	rip.wait = make(chan struct{})
+
+	newFakeRunner(t, fakeInfo(fakeDiscInfo), rip)
+
+	var submitted jobView
+
+	serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"], "title_selection": "0"}`, &submitted)
+
+	var busy map[string]string
+
+	response := serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"]}`, &busy)
+
+	if response.StatusCode != http.StatusConflict || !strings.Contains(busy["error"], "job "+submitted.ID) {
+		t.Errorf("second submit = %d %v, want 409 naming the running job", response.StatusCode, busy)
+	}
+
+	response = serveRequest(t, ts, http.MethodPost, "/api/jobs/"+submitted.ID+"/cancel", "", nil)
+
+	if response.StatusCode != http.StatusAccepted {
+		t.Errorf("cancel = %d, want 202", response.StatusCode)
+	}
+
+	if view := waitForJob(t, ts, submitted.ID); view.State != jobCancelled {
+		t.Errorf("job = %+v, want it cancelled", view)
+	}
+
+	if response := serveRequest(t, ts, http.MethodPost, "/api/jobs/99/cancel", "", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel of an unknown job = %d, want 404", response.StatusCode)
+	}
+}
+
+// Checks that a drive is busy while its titles are scanned, and that the scan is stopped when the client goes away.
+func TestServeScanMarksDriveBusy(t *testing.T) {
+	ts := newTestServer(t)
+
+	// The scan does not finish until its request is cancelled
+	started := make(chan struct{})
+	scan := fakeInfo(fakeDiscInfo)
+	scan.wait = make(chan struct{})
+	scan.times = 1
+	scan.effect = func(args []string) error {
+		close(started)
+		return nil
+	}
+
+	rip := fakeRip(0, "My Disc, Feature_t00.mkv")
+	rip.wait = make(chan struct{})
+
+	newFakeRunner(t, scan, fakeInfo(fakeDiscInfo), rip)
+
+	ctx, cancelScan := context.WithCancel(context.Background())
+	defer cancelScan()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/drives/0/titles", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+testServeToken)
+
+	scanDone := make(chan struct{})
+
+	go func() {
+		defer close(scanDone)
+
+		if response, err := ts.Client().Do(request); err == nil {
+			response.Body.Close()
+		}
+	}()
+
+	<-started
+
+	var busy map[string]string
+
+	response := serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"], "title_selection": "0"}`, &busy)
+
+	if response.StatusCode != http.StatusConflict || !strings.Contains(busy["error"], "being scanned") {
+		t.Fatalf("submit during scan = %d %v, want 409 for the scan", response.StatusCode, busy)
+	}
+
+	cancelScan()
+	<-scanDone
+
+	// The drive is released once makemkvcon has been stopped
+	deadline := time.Now().Add(5 * time.Second)
+
+	var submitted jobView
+
+	for {
+		response = serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"], "title_selection": "0"}`, &submitted)
+
+		if response.StatusCode == http.StatusAccepted {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("submit after the scan was cancelled = %d, want 202", response.StatusCode)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	serveRequest(t, ts, http.MethodPost, "/api/jobs/"+submitted.ID+"/cancel", "", nil)
+
+	if view := waitForJob(t, ts, submitted.ID); view.State != jobCancelled {
+		t.Errorf("job = %+v, want it cancelled", view)
+	}
+}
+
+func TestServeJobEventsEndWhenJobFinishes(t *testing.T) {
+	ts := newTestServer(t)
+
+	// The rip does not finish until the stream is open, so that the stream sees the job's progress
+	rip := fakeRip(0, "My Disc, Feature_t00.mkv")
+	rip.wait = make(chan struct{})
+
+	newFakeRunner(t, fakeInfo(fakeDiscInfo), rip, fakeEncode())
+
+	var submitted jobView
+
+	serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"], "title_selection": "0"}`, &submitted)
+
+	request, err := http.NewRequest(http.MethodGet, ts.URL+"/api/jobs/"+submitted.ID+"/events", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+testServeToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := ts.Client().Do(request.WithContext(ctx))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", contentType)
+	}
+
+	close(rip.wait)
+
+	var names []string
+	var last jobView
+
+	scanner := bufio.NewScanner(response.Body)
+
+	// The body ends when the server closes the stream
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+
+		if data, ok := strings.CutPrefix(line, "data: "); ok && names[len(names)-1] == "job" {
+			if err := json.Unmarshal([]byte(data), &last); err != nil {
+				t.Fatalf("job event data is not valid JSON - %v", err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("the stream did not end when the job finished - %v", err)
+	}
+
+	if len(names) < 3 || names[0] != "job" || !strings.Contains(strings.Join(names, ","), "title") {
+		t.Errorf("events = %v, want the job followed by title events", names)
+	}
+
+	if last.State != jobCompleted {
+		t.Errorf("last job event = %+v, want the completed job", last)
+	}
+}
+
+func TestServeRejectsJobsWhileStopping(t *testing.T) {
+	useTestConfig(t, testConfig)
+
+	// No disc is scanned for a job submitted while stopping
+	r := newFakeRunner(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newServer(ctx, testServeToken)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	cancel()
+
+	var stopping map[string]string
+
+	response := serveRequest(t, ts, http.MethodPost, "/api/jobs", `{"drives": ["0"]}`, &stopping)
+
+	if response.StatusCode != http.StatusServiceUnavailable || stopping["error"] != errServerStopping.Error() {
+		t.Errorf("submit = %d %v, want 503 as the server is shutting down", response.StatusCode, stopping)
+	}
+
+	if len(s.jobs) != 0 || len(r.calls) != 0 {
+		t.Errorf("jobs = %d, calls = %v, want no job started", len(s.jobs), r.calls)
+	}
+}
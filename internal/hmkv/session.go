@@ -0,0 +1,245 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
+)
+
+// The number of titles which can wait to be encoded before ripping blocks. Sessions do not know up front how many
+// titles they will contain, and ripping must not wait for encodes or the drive would sit idle between discs.
+const sessionQueueCapacity = 1000
+
+var ErrSessionNeedsTerminal = errors.New("session mode asks for each disc and requires an interactive terminal")
+
+// Runs a session in which discs are inserted into a single drive one after another, such as the discs of a box set.
+// Once the titles of a disc are ripped the disc is ejected and the user is asked for the next one. The titles of every
+// disc land in one session output directory and feed one shared encode queue, which keeps running while discs are swapped.
+func Session(driveIndex int, options ExecOptions) error {
+	if !isTerminal(os.Stdin) {
+		return ErrSessionNeedsTerminal
+	}
+
+	return runSession(driveIndex, options)
+}
+
+// Runs the session once standard input is known to be an interactive terminal.
+func runSession(driveIndex int, options ExecOptions) error {
+	config, err := readRunConfig(&options)
+
+	if err != nil {
+		return err
+	}
+
+	pollInterval, settleTime, err := config.Watch.intervals()
+
+	if err != nil {
+		return err
+	}
+
+	err = createOutputDirectories(config)
+
+	if err != nil {
+		return err
+	}
+
+	printStaleTempFileCleanup(config.HBOutputDirectory)
+
+	titles, err := readAndSelectTitles(context.Background(), config, []int{driveIndex}, options)
+
+	if err != nil {
+		if err == errSelectionCancelled {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(titles) < 1 {
+		fmt.Printf("\nNo titles to process. Exiting.\n\n")
+		return nil
+	}
+
+	err = createRunDirectories(config)
+
+	if err != nil {
+		return err
+	}
+
+	discNames := make(map[string]bool)
+	numberSessionDisc(titles, 1, discNames)
+
+	// The journal starts out with the first disc so that the start of the run reports its titles
+	jrnl, err := newJournal(config, titles)
+
+	if err != nil {
+		return fmt.Errorf("an error occurred while creating the run journal: %w", err)
+	}
+
+	fmt.Println()
+
+	options.interrupts = handleInterrupts()
+	defer options.interrupts.stop()
+
+	p := newPipeline(config, jrnl, options, sessionQueueCapacity)
+	defer p.stop()
+
+	for _, title := range titles {
+		createTitleSubdirectories(&title, config)
+	}
+
+	p.startEncoders(options)
+
+	for discNumber := 1; ; {
+		p.ripDisc(titles, true)
+
+		if p.ctx.Err() != nil {
+			break
+		}
+
+		titles = p.nextSessionDisc(driveIndex, titles[0].DiscTitle, options, pollInterval, settleTime)
+
+		if len(titles) < 1 {
+			break
+		}
+
+		discNumber++
+		numberSessionDisc(titles, discNumber, discNames)
+		p.addTitles(titles)
+	}
+
+	return p.finish()
+}
+
+// Gives the titles of a disc the number of the disc within the session, as every disc is read from the same drive.
+// The titles of a disc which shares its name with an earlier disc of the session are kept in their own subdirectory.
+func numberSessionDisc(titles []TitleInfo, discNumber int, discNames map[string]bool) {
+	for i := range titles {
+		titles[i].DiscId = discNumber
+
+		if discNames[strings.ToLower(titles[i].DiscTitle)] {
+			titles[i].SetPrependDiscToSubdirectory(true)
+		}
+	}
+
+	discNames[strings.ToLower(titles[0].DiscTitle)] = true
+}
+
+// Asks for the next disc of the session, waits for it to be inserted, and reads and selects its titles.
+// The progress display is paused meanwhile. Returns no titles once the user ends the session or processing is cancelled.
+func (p *pipeline) nextSessionDisc(driveIndex int, previousDisc string, options ExecOptions, pollInterval, settleTime time.Duration) []TitleInfo {
+	p.tracker.setPaused(true)
+	defer p.tracker.setPaused(false)
+
+	for {
+		fmt.Printf("\nInsert the next disc into drive %d and press Enter, or type 'done' to finish the session once the remaining titles are encoded.\n\n", driveIndex)
+
+		input, ok := readLineContext(p.ctx)
+
+		if !ok || strings.EqualFold(strings.TrimSpace(input), "done") {
+			return nil
+		}
+
+		fmt.Printf("Waiting for a new disc in drive %d...\n\n", driveIndex)
+
+		if err := waitForDiscChange(p.ctx, driveIndex, previousDisc, pollInterval, settleTime); err != nil {
+			return nil
+		}
+
+		titles, err := readAndSelectTitles(p.ctx, p.config, []int{driveIndex}, options)
+
+		if err != nil {
+			// Reading the disc is stopped by an interrupt, which ends the session on the next wait
+			if err != errSelectionCancelled && p.ctx.Err() == nil {
+				fmt.Printf("\nAn error occurred while reading the disc - %v\n", err)
+			}
+
+			continue
+		}
+
+		if len(titles) < 1 {
+			fmt.Printf("\nNo titles were selected from the disc.\n")
+			continue
+		}
+
+		return titles
+	}
+}
+
+// Waits until a disc other than the previous one is inserted in the drive and has settled.
+func waitForDiscChange(ctx context.Context, driveIndex int, previousDisc string, pollInterval, settleTime time.Duration) error {
+	drive := &watchedDrive{Index: driveIndex, State: watchDone, DiscName: previousDisc}
+
+	for {
+		// Errors listing the drives are usually transient, the drive is checked again on the next poll
+		records, _ := listDrives()
+
+		for _, record := range records {
+			if record.Index != driveIndex {
+				continue
+			}
+
+			// A disc may be swapped without the drive ever being reported as empty
+			if drive.State == watchDone && record.DiscName != "" && record.DiscName != previousDisc {
+				drive.setState(watchEmpty)
+			}
+
+			if drive.observe(record, settleTime) {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Asks for the disc of the title when a resumed session has more than one disc left to rip in its drive, and waits
+// until it is inserted. The progress display is paused meanwhile. Returns false if processing is cancelled first.
+func (p *pipeline) waitForResumedDisc(title TitleInfo, previousDisc string) bool {
+	p.tracker.setPaused(true)
+	defer p.tracker.setPaused(false)
+
+	// The intervals are validated by Resume before processing starts
+	pollInterval, settleTime, _ := p.config.Watch.intervals()
+
+	fmt.Printf("\nInsert disc '%s' into drive %d and press Enter to rip its remaining titles.\n\n", title.DiscTitle, title.DriveIndex)
+
+	if _, ok := readLineContext(p.ctx); !ok {
+		return false
+	}
+
+	for {
+		fmt.Printf("Waiting for disc '%s' in drive %d...\n\n", title.DiscTitle, title.DriveIndex)
+
+		if err := waitForDiscChange(p.ctx, title.DriveIndex, previousDisc, pollInterval, settleTime); err != nil {
+			return false
+		}
+
+		drives, err := listDrives()
+
+		if err == nil {
+			err = checkInsertedDisc(drives, title.DriveIndex, title.DiscTitle)
+		}
+
+		if err == nil {
+			return true
+		}
+
+		fmt.Printf("%v\n\n", err)
+
+		// The wrong disc has to be swapped out before the drive is checked again
+		if idx := slices.IndexFunc(drives, func(drive mkvrobot.Drive) bool { return drive.Index == title.DriveIndex }); idx >= 0 {
+			previousDisc = drives[idx].DiscName
+		}
+	}
+}
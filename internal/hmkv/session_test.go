@@ -0,0 +1,322 @@
+package hmkv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers the prompts of the test with the lines in turn, in place of standard input.
+func useTestInput(t *testing.T, lines ...string) {
+	t.Helper()
+
+	// Keep standard input itself from being read
+	stdinReadStart.Do(func() {})
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	go func() {
+		for _, line := range lines {
+			select {
+			case stdinLines <- line:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// A configuration for sessions which ejects discs with a fake command and sends notifications to the webhook.
+func sessionTestConfig(webhookURL string) string {
+	return fmt.Sprintf(`{
+		"encoding_params": {"encoder": "x264", "quality": 20},
+		"mkv_output_directory": "mkv",
+		"handbrake_output_directory": "hb",
+		"eject_command": "fake-eject {device}",
+		"watch": {"poll_interval": "1ms", "settle_time": "1ms"},
+		"notifications": {"webhooks": [{"url": %q, "events": ["run_started"]}]}
+	}`, webhookURL)
+}
+
+func TestSessionReportsTitlesOfFirstDisc(t *testing.T) {
+	webhook := newTestWebhook(t)
+	useTestConfig(t, sessionTestConfig(webhook.URL))
+	useTestInput(t, "done")
+
+	newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+		&fakeCommand{name: "fake-eject"},
+	)
+
+	if err := runSession(0, ExecOptions{DefaultTitleSelection: "all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := webhook.received()
+
+	if len(bodies) != 1 {
+		t.Fatalf("notifications = %q, want run_started", bodies)
+	}
+
+	var started notification
+
+	if err := json.Unmarshal([]byte(bodies[0]), &started); err != nil {
+		t.Fatal(err)
+	}
+
+	if started.Event != notifyRunStarted || started.TitleCount != 2 {
+		t.Errorf("notification = %+v, want run_started with the 2 titles of the first disc", started)
+	}
+}
+
+// The fake disc info with a different disc inserted. The titles are the same as those of the fake disc.
+var otherDiscInfo = strings.Replace(fakeDiscInfo, `"MY DISC"`, `"OTHER DISC"`, 1)
+
+// Checks that the titles of every disc of a session are encoded by one shared queue, which keeps encoding the titles
+// of the first disc while the next one is ripped.
+func TestSessionSharesEncodeQueue(t *testing.T) {
+	webhook := newTestWebhook(t)
+	useTestConfig(t, sessionTestConfig(webhook.URL))
+
+	// Enter once the first disc is ripped, then done once the second is
+	useTestInput(t, "", "done")
+
+	// No title is encoded until the second disc has been ripped
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+
+	encode := fakeEncode()
+	encode.wait = release
+
+	lastRip := fakeRip(1, "My Disc_t01.mkv")
+	rip := lastRip.effect
+	lastRip.effect = func(args []string) error {
+		releaseOnce.Do(func() { close(release) })
+		return rip(args)
+	}
+
+	firstInfo := &fakeCommand{name: "makemkvcon", args: []string{"info", "disc:0"}, stdout: fakeDiscInfo, times: 1}
+	firstRips := []*fakeCommand{fakeRip(0, "My Disc, Feature_t00.mkv"), fakeRip(1, "My Disc_t01.mkv")}
+
+	for _, rip := range firstRips {
+		rip.times = 1
+	}
+
+	r := newFakeRunner(t,
+		firstInfo,
+		firstRips[0],
+		firstRips[1],
+		fakeInfo(otherDiscInfo),
+		fakeRip(0, "My Disc, Feature_t00.mkv"),
+		lastRip,
+		encode,
+		&fakeCommand{name: "fake-eject"},
+	)
+
+	if err := runSession(0, ExecOptions{DefaultTitleSelection: "all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, _ := filepath.Glob(filepath.Join("mkv", "handymkv_*"))
+
+	if len(runs) != 1 {
+		t.Fatalf("run directories = %v, want one for the whole session", runs)
+	}
+
+	jrnl, err := readJournal(runs[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discIds := make([]int, 0, len(jrnl.Entries))
+
+	for _, entry := range jrnl.Entries {
+		discIds = append(discIds, entry.Title.DiscId)
+
+		if entry.Ripping != Complete || entry.Encoding != Complete {
+			t.Errorf("disc %d title %d ripping %s, encoding %s, want both complete", entry.Title.DiscId, entry.Title.Index, entry.Ripping, entry.Encoding)
+		}
+
+		title := entry.title()
+
+		if params := encodingParamsFor(&title, &handyMKVConfig{HBOutputDirectory: jrnl.HBOutputDirectory}); !fileExists(params.HandBrakeOutputPath) {
+			t.Errorf("the encoded file %s is missing", params.HandBrakeOutputPath)
+		}
+	}
+
+	if !slices.Equal(discIds, []int{1, 1, 2, 2}) {
+		t.Errorf("journal disc ids = %v, want the two titles of each disc", discIds)
+	}
+
+	if encodes := r.callsTo("HandBrakeCLI"); len(encodes) != 4 {
+		t.Errorf("HandBrakeCLI calls = %d, want every title encoded", len(encodes))
+	}
+
+	if ejects := r.callsTo("fake-eject"); len(ejects) != 2 {
+		t.Errorf("eject calls = %v, want each disc ejected once ripped", ejects)
+	}
+}
+
+// Checks that resuming a session with two discs left to rip in the drive rips them one at a time, asking for the
+// second disc once the first has been ripped and ejected.
+func TestResumeSessionRipsDiscsInTurn(t *testing.T) {
+	webhook := newTestWebhook(t)
+	useTestConfig(t, sessionTestConfig(webhook.URL))
+
+	config, err := ReadConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createRunDirectories(config); err != nil {
+		t.Fatal(err)
+	}
+
+	discNames := make(map[string]bool)
+	var jrnl *journal
+
+	for discNumber, discInfo := range []string{fakeDiscInfo, otherDiscInfo} {
+		newFakeRunner(t, fakeInfo(discInfo))
+
+		titles, err := getTitlesFromDisc(context.Background(), 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		numberSessionDisc(titles, discNumber+1, discNames)
+
+		if jrnl == nil {
+			jrnl, err = newJournal(config, titles)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			jrnl.addEntries(titles)
+		}
+	}
+
+	// Enter once asked for the second disc
+	useTestInput(t, "")
+
+	// The drive holds the first disc until it is ejected, then the second
+	info := fakeInfo(fakeDiscInfo)
+	inserted := "MY DISC"
+
+	eject := &fakeCommand{name: "fake-eject", effect: func([]string) error {
+		info.stdout = otherDiscInfo
+		inserted = "OTHER DISC"
+		return nil
+	}}
+
+	// The disc in the drive and the directory ripped into for every rip
+	var ripsMutex sync.Mutex
+	var rippedFrom []string
+
+	rips := []*fakeCommand{fakeRip(0, "My Disc, Feature_t00.mkv"), fakeRip(1, "My Disc_t01.mkv")}
+
+	for _, rip := range rips {
+		ripFile := rip.effect
+		rip.effect = func(args []string) error {
+			ripsMutex.Lock()
+			rippedFrom = append(rippedFrom, inserted+" "+filepath.Base(args[len(args)-1]))
+			ripsMutex.Unlock()
+
+			return ripFile(args)
+		}
+	}
+
+	r := newFakeRunner(t, info, rips[0], rips[1], fakeEncode(), eject)
+
+	if err := Resume(config.MKVOutputDirectory, ExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"MY DISC MY_DISC", "MY DISC MY_DISC", "OTHER DISC OTHER_DISC", "OTHER DISC OTHER_DISC"}
+
+	if !slices.Equal(rippedFrom, want) {
+		t.Errorf("rips = %q, want the titles of each disc ripped while it is inserted", rippedFrom)
+	}
+
+	if ejects := r.callsTo("fake-eject"); len(ejects) != 1 {
+		t.Errorf("eject calls = %v, want only the first disc ejected", ejects)
+	}
+
+	resumed, err := readJournal(config.MKVOutputDirectory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range resumed.Entries {
+		if entry.Ripping != Complete || entry.Encoding != Complete {
+			t.Errorf("disc %d title %d ripping %s, encoding %s, want both complete", entry.Title.DiscId, entry.Title.Index, entry.Ripping, entry.Encoding)
+		}
+	}
+}
+
+// Reports whether the file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+func TestWaitForDiscChange(t *testing.T) {
+	const (
+		myDisc    = `DRV:0,2,999,12,"BD-RE","MY DISC","/dev/sr0"` + "\n"
+		otherDisc = `DRV:0,2,999,12,"BD-RE","OTHER DISC","/dev/sr0"` + "\n"
+		noDisc    = `DRV:0,0,999,0,"BD-RE","","/dev/sr0"` + "\n"
+		loading   = `DRV:0,3,999,0,"BD-RE","","/dev/sr0"` + "\n"
+	)
+
+	tests := []struct {
+		name string
+		// The drive listings in turn. The last one repeats.
+		listings   []string
+		wantChange bool
+	}{
+		{name: "swapped without being reported empty", listings: []string{myDisc, otherDisc}, wantChange: true},
+		{name: "removed and inserted again", listings: []string{myDisc, noDisc, loading, myDisc}, wantChange: true},
+		{name: "renamed while loading", listings: []string{noDisc, `DRV:0,2,999,12,"BD-RE","LOADING","/dev/sr0"` + "\n", otherDisc}, wantChange: true},
+		{name: "left in the drive", listings: []string{myDisc}},
+		{name: "removed", listings: []string{myDisc, noDisc}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listings := make([]*fakeCommand, len(test.listings))
+
+			for i, listing := range test.listings {
+				listings[i] = &fakeCommand{name: "makemkvcon", args: []string{"disc:9999"}, stdout: listing, times: 1}
+			}
+
+			listings[len(listings)-1].times = 0
+
+			newFakeRunner(t, listings...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			err := waitForDiscChange(ctx, 0, "MY DISC", time.Millisecond, time.Millisecond)
+
+			if changed := err == nil; changed != test.wantChange {
+				t.Errorf("waitForDiscChange() = %v, want a change: %t", err, test.wantChange)
+			}
+		})
+	}
+}
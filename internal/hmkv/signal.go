@@ -0,0 +1,80 @@
+package hmkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// The exit status used when the process is stopped by an interrupt, matching the convention used by shells.
+const InterruptExitStatus = 130
+
+// Exits the process on a second signal. Replaced by tests.
+var exitProcess = os.Exit
+
+var ErrInterrupted = errors.New("processing was interrupted")
+
+// Returned when processing is cancelled by the caller rather than by a signal.
+var ErrCancelled = errors.New("processing was cancelled")
+
+// Cancels processing when an interrupt or termination signal is received. Installed once by each command which runs
+// in the foreground, including serve and watch. A second signal exits the process immediately without waiting for cleanup.
+type interruptHandler struct {
+	// Done once a signal is received.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	signals     chan os.Signal
+	done        chan struct{}
+	interrupted atomic.Bool
+}
+
+// Starts listening for interrupt and termination signals. The handler's context is cancelled when the first signal is received.
+func handleInterrupts() *interruptHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h := &interruptHandler{
+		ctx:     ctx,
+		cancel:  cancel,
+		signals: make(chan os.Signal, 2),
+		done:    make(chan struct{}),
+	}
+
+	signal.Notify(h.signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-h.signals:
+		case <-h.done:
+			return
+		}
+
+		h.interrupted.Store(true)
+		h.cancel()
+
+		select {
+		case <-h.signals:
+			// Leave the terminal in a usable state as the display may have been mid-redraw
+			fmt.Printf("%s\n\nForced exit. Partial files have not been cleaned up.\n\n", colorReset)
+			exitProcess(InterruptExitStatus)
+		case <-h.done:
+		}
+	}()
+
+	return h
+}
+
+// Stops listening for signals. Signals received afterwards use the default behavior.
+func (h *interruptHandler) stop() {
+	signal.Stop(h.signals)
+	close(h.done)
+	h.cancel()
+}
+
+// Reports whether a signal was received.
+func (h *interruptHandler) wasInterrupted() bool {
+	return h.interrupted.Load()
+}
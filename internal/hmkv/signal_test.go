@@ -0,0 +1,46 @@
+package hmkv
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInterruptHandlerSecondSignalExits(t *testing.T) {
+	exitCodes := make(chan int, 1)
+
+	exitProcess = func(code int) { exitCodes <- code }
+	t.Cleanup(func() { exitProcess = os.Exit })
+
+	h := handleInterrupts()
+	defer h.stop()
+
+	h.signals <- os.Interrupt
+
+	select {
+	case <-h.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context was not cancelled by the first signal")
+	}
+
+	if !h.wasInterrupted() {
+		t.Error("wasInterrupted() = false after a signal")
+	}
+
+	select {
+	case code := <-exitCodes:
+		t.Fatalf("exited with %d on the first signal, want processing cancelled only", code)
+	default:
+	}
+
+	h.signals <- os.Interrupt
+
+	select {
+	case code := <-exitCodes:
+		if code != InterruptExitStatus {
+			t.Errorf("exit status = %d, want %d", code, InterruptExitStatus)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second signal did not exit")
+	}
+}
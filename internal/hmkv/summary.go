@@ -0,0 +1,163 @@
+package hmkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The outcome of a run as a whole.
+type runStatus string
+
+const (
+	// Every title was ripped and encoded.
+	runCompleted runStatus = "completed"
+	// Processing continued past titles which failed.
+	runCompletedWithFailures runStatus = "completed_with_failures"
+	// Processing stopped because a title failed.
+	runFailed runStatus = "failed"
+	// Processing was stopped by an interrupt.
+	runInterrupted runStatus = "interrupted"
+	// Processing was cancelled by the caller, such as a server job being cancelled.
+	runCancelled runStatus = "cancelled"
+)
+
+// Machine readable summary of a run. The field names are part of the JSON output and must not change.
+type RunSummary struct {
+	Status runStatus `json:"status"`
+	// The error which stopped or failed the run. Empty if the run completed.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// The time processing completed or stopped.
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// The directory of the run's raw mkv files and journal. Resuming the run uses this directory.
+	MKVOutputDirectory string `json:"mkv_output_directory"`
+	HBOutputDirectory  string `json:"handbrake_output_directory"`
+	// The total size of the raw files of the titles which were ripped.
+	RawSizeBytes int64 `json:"raw_size_bytes"`
+	// The total size of the encoded files of the titles which were encoded.
+	EncodedSizeBytes int64 `json:"encoded_size_bytes"`
+	// True if the raw files were deleted once encoding completed.
+	RawFilesDeleted bool           `json:"raw_files_deleted"`
+	Titles          []TitleSummary `json:"titles"`
+}
+
+// Machine readable summary of a single title of a run.
+type TitleSummary struct {
+	DiscId     int    `json:"disc_id"`
+	DiscTitle  string `json:"disc_title"`
+	TitleIndex int    `json:"title_index"`
+	FileName   string `json:"file_name"`
+	// The playing time of the title.
+	LengthSeconds  float64     `json:"length_seconds"`
+	Ripping        statusValue `json:"ripping"`
+	Encoding       statusValue `json:"encoding"`
+	RipAttempts    int         `json:"rip_attempts"`
+	EncodeAttempts int         `json:"encode_attempts"`
+	// The time spent ripping the title, including retries. Zero if the title was not ripped by this run.
+	RipSeconds float64 `json:"rip_seconds"`
+	// The time spent encoding the title, including retries. Zero if the title was not encoded by this run.
+	EncodeSeconds    float64 `json:"encode_seconds"`
+	RawPath          string  `json:"raw_path"`
+	EncodedPath      string  `json:"encoded_path"`
+	RawSizeBytes     int64   `json:"raw_size_bytes"`
+	EncodedSizeBytes int64   `json:"encoded_size_bytes"`
+	// The error which caused the title to fail. Empty if it did not fail.
+	Error string `json:"error,omitempty"`
+	// The log file containing the output of the failed process, if any.
+	LogPath string `json:"log_path,omitempty"`
+}
+
+// Builds the summary of the run from the state of its titles.
+func (p *pipeline) summary(status runStatus, runErr error, rawFilesDeleted bool) RunSummary {
+	finishedAt := time.Now()
+
+	summary := RunSummary{
+		Status:             status,
+		StartedAt:          p.startTime,
+		FinishedAt:         finishedAt,
+		DurationSeconds:    finishedAt.Sub(p.startTime).Round(time.Second).Seconds(),
+		MKVOutputDirectory: p.config.MKVOutputDirectory,
+		HBOutputDirectory:  p.config.HBOutputDirectory,
+		RawFilesDeleted:    rawFilesDeleted,
+		Titles:             make([]TitleSummary, 0, len(p.tracker.statuses)),
+	}
+
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	p.tracker.mutex.Lock()
+	defer p.tracker.mutex.Unlock()
+
+	for i, status := range p.tracker.statuses {
+		entry := p.journal.Entries[i]
+		title := entry.title()
+		params := encodingParamsFor(&title, p.config)
+
+		titleSummary := TitleSummary{
+			DiscId:         status.DiscId,
+			DiscTitle:      title.DiscTitle,
+			TitleIndex:     status.TitleIndex,
+			FileName:       status.Title,
+			LengthSeconds:  title.Duration().Seconds(),
+			Ripping:        status.Ripping,
+			Encoding:       status.Encoding,
+			RipAttempts:    status.RipAttempts,
+			EncodeAttempts: status.EncodeAttempts,
+			RipSeconds:     status.RipDuration.Round(time.Second).Seconds(),
+			EncodeSeconds:  status.EncodeDuration.Round(time.Second).Seconds(),
+			RawPath:        params.MKVOutputPath,
+			EncodedPath:    params.HandBrakeOutputPath,
+			LogPath:        status.LogPath,
+		}
+
+		if status.Ripping == Complete {
+			titleSummary.RawSizeBytes = entry.RawFileSize
+			summary.RawSizeBytes += entry.RawFileSize
+		}
+
+		if status.Encoding == Complete {
+			titleSummary.EncodedSizeBytes, _ = getFileSize(params.HandBrakeOutputPath)
+			summary.EncodedSizeBytes += titleSummary.EncodedSizeBytes
+		}
+
+		if status.Err != nil {
+			titleSummary.Error = status.Err.Error()
+		}
+
+		summary.Titles = append(summary.Titles, titleSummary)
+	}
+
+	return summary
+}
+
+// Writes the summary of the run as JSON if a summary output was requested, passes it to the run hooks
+// and sends it to the event sinks.
+func (p *pipeline) writeSummary(status runStatus, runErr error, rawFilesDeleted bool) {
+	summary := p.summary(status, runErr, rawFilesDeleted)
+
+	p.emit(runEvent{kind: eventRunFinished, err: runErr, summary: &summary})
+
+	if p.hooks != nil && p.hooks.onSummary != nil {
+		p.hooks.onSummary(summary)
+	}
+
+	if p.summaryOutput == nil {
+		return
+	}
+
+	if err := writeJSON(p.summaryOutput, summary); err != nil {
+		fmt.Printf("\nAn error occurred while writing the run summary - %v\n", err)
+	}
+}
+
+// Writes the value as indented JSON followed by a new line.
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
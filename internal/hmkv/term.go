@@ -1,15 +1,21 @@
 package hmkv
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ANSI color codes
 const (
 	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
 	colorGreen  = "\033[32m"
 	colorYellow = "\033[33m"
 	colorBlue   = "\033[36m"
@@ -33,9 +39,61 @@ func clear() {
 	fmt.Print("\033[H\033[2J") // Clear the terminal
 }
 
+// Lines read from standard input, without the trailing newline. Closed once standard input ends.
+// A single goroutine started by the first read owns standard input, so a prompt which gives up waiting never leaves
+// a reader behind to swallow the next line typed. A line typed while no prompt is waiting is kept for the next one.
+var (
+	stdinLines     = make(chan string)
+	stdinReadStart sync.Once
+)
+
+// Reads standard input into stdinLines until it ends.
+func readStdinLines() {
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if line != "" {
+			stdinLines <- strings.TrimRight(line, "\r\n")
+		}
+
+		if err != nil {
+			close(stdinLines)
+			return
+		}
+	}
+}
+
+// Reads a line from standard input. The trailing newline is removed. Returns an empty string once standard input has ended,
+// and gives up and returns false if the context is cancelled first.
+func readLineContext(ctx context.Context) (string, bool) {
+	stdinReadStart.Do(func() {
+		go readStdinLines()
+	})
+
+	select {
+	case line := <-stdinLines:
+		return line, true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// Reports whether the file is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
 // colorize wraps a string in the specified color
-func colorize(text statusValue, color string) string {
-	return fmt.Sprintf("%s%s%s", color, text.String(), colorReset)
+func colorize(text string, color string) string {
+	return fmt.Sprintf("%s%s%s", color, text, colorReset)
 }
 
 // Pad a string to a specific width, accounting for visible length.
@@ -205,3 +263,8 @@ func promptForBool(prompt, explain string, defaultValue bool) bool {
 
 	return defaultValue
 }
+
+// Prints a message prefixed with the current time, for the modes which run unattended and log what they do.
+func timestampedLog(format string, args ...any) {
+	fmt.Printf("%s - %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
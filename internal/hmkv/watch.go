@@ -0,0 +1,391 @@
+package hmkv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
+)
+
+const (
+	defaultWatchPollInterval = 5 * time.Second
+	defaultWatchSettleTime   = 10 * time.Second
+)
+
+// Settings for watch mode.
+type watchConfig struct {
+	// How often the drives are checked for disc changes. Example: 5s
+	PollInterval string `json:"poll_interval,omitempty"`
+	// How long a disc must be reported as inserted before it is scanned. Example: 10s
+	SettleTime string `json:"settle_time,omitempty"`
+	// If set, Prometheus metrics are served at /metrics on this address while watching. Example: :9100
+	MetricsAddress string `json:"metrics_address,omitempty"`
+}
+
+// Checks that the watch settings are valid.
+func (c *watchConfig) validate() error {
+	_, _, err := c.intervals()
+	return err
+}
+
+// Parses the poll interval and settle time, using the defaults for unset values.
+func (c *watchConfig) intervals() (time.Duration, time.Duration, error) {
+	pollInterval := defaultWatchPollInterval
+	settleTime := defaultWatchSettleTime
+
+	if c.PollInterval != "" {
+		d, err := time.ParseDuration(c.PollInterval)
+
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("%w: invalid watch poll_interval '%s'", ErrInvalidInput, c.PollInterval)
+		}
+
+		pollInterval = d
+	}
+
+	if c.SettleTime != "" {
+		d, err := time.ParseDuration(c.SettleTime)
+
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("%w: invalid watch settle_time '%s'", ErrInvalidInput, c.SettleTime)
+		}
+
+		settleTime = d
+	}
+
+	return pollInterval, settleTime, nil
+}
+
+// The state of a drive in watch mode.
+type watchState uint8
+
+const (
+	// No disc is inserted.
+	watchEmpty watchState = iota
+	// A disc is being loaded or is waiting to settle before it is scanned.
+	watchLoading
+	// The titles on the disc are being read.
+	watchScanning
+	// The disc's titles are being ripped and encoded.
+	watchRipping
+	// The disc has been processed. The drive stays in this state until the disc is removed.
+	watchDone
+	// The disc could not be processed. The drive stays in this state until the disc is removed.
+	watchError
+)
+
+// String representation of the watchState.
+func (s watchState) String() string {
+	switch s {
+	case watchEmpty:
+		return "empty"
+	case watchLoading:
+		return "loading"
+	case watchScanning:
+		return "scanning"
+	case watchRipping:
+		return "ripping"
+	case watchDone:
+		return "done"
+	case watchError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// A drive being watched for newly inserted discs.
+type watchedDrive struct {
+	Index      int
+	DevicePath string
+	DriveName  string
+	State      watchState
+	DiscName   string
+	// When the current disc was first reported as inserted. Zero while no readable disc has been seen.
+	insertedAt time.Time
+	// Incremented whenever a disc is removed. A run which outlives its disc, such as one still encoding after the disc
+	// was ejected, no longer changes the state of the drive once it has moved on.
+	generation int
+}
+
+// Moves the drive to a new state and logs the transition.
+func (d *watchedDrive) setState(state watchState) {
+	if d.State == state {
+		return
+	}
+
+	timestampedLog("Drive %d (%s) - %s -> %s", d.Index, d.DevicePath, d.State, state)
+	d.State = state
+}
+
+// Updates the state of the drive from its DRV record. Returns true once a newly inserted disc has been
+// reported with the same name for at least the settle time and is ready to be scanned.
+func (d *watchedDrive) observe(drive mkvrobot.Drive, settleTime time.Duration) bool {
+	d.DevicePath = drive.DevicePath
+	d.DriveName = drive.DriveName
+
+	switch {
+	case drive.State == mkvrobot.DriveLoading:
+		if d.State == watchEmpty {
+			d.setState(watchLoading)
+		}
+	case drive.State == mkvrobot.DriveInserted && drive.DiscName != "":
+		switch d.State {
+		case watchEmpty:
+			d.setState(watchLoading)
+			d.DiscName = drive.DiscName
+			d.insertedAt = time.Now()
+		case watchLoading:
+			// A disc which is still spinning up may briefly be reported under a different name
+			if d.insertedAt.IsZero() || drive.DiscName != d.DiscName {
+				d.DiscName = drive.DiscName
+				d.insertedAt = time.Now()
+				break
+			}
+
+			return time.Since(d.insertedAt) >= settleTime
+		}
+		// Discs being processed, or which have been processed, are left alone until they are removed so they are not ripped twice
+	case drive.State == mkvrobot.DriveInserted:
+		// makemkvcon reports discs it cannot read, such as audio CDs, without a name
+		if d.State == watchEmpty || d.State == watchLoading {
+			timestampedLog("Drive %d (%s) - the inserted disc could not be read", d.Index, d.DevicePath)
+			d.setState(watchError)
+		}
+	default:
+		if d.State != watchEmpty {
+			d.setState(watchEmpty)
+			d.DiscName = ""
+			d.insertedAt = time.Time{}
+			d.generation++
+		}
+	}
+
+	return false
+}
+
+// A disc being processed in watch mode.
+type watchedDisc struct {
+	drive *watchedDrive
+	// The generation of the drive when processing started.
+	generation int
+	Name       string
+	DevicePath string
+}
+
+// Tracks the watched drives and the discs being processed. Each disc is processed in its own goroutine so that the
+// drives are still polled, and other discs can be started, while a disc is being ripped and encoded.
+type watcher struct {
+	// Processing is cancelled when the context is done.
+	ctx     context.Context
+	options ExecOptions
+	drives  map[int]*watchedDrive
+	// Guards the state of the drives, which is changed by both the poll loop and the processing of discs.
+	mutex sync.Mutex
+	discs sync.WaitGroup
+}
+
+// Watches the drives for newly inserted discs. Each disc is scanned, its titles are selected using the title selection
+// from the options or profile (or all titles if neither provides one), ripped and encoded, and the disc is then ejected.
+// Runs until an interrupt is received, then waits for the discs being processed to stop.
+func Watch(options ExecOptions) error {
+	config, err := readRunConfig(&options)
+
+	if err != nil {
+		return err
+	}
+
+	pollInterval, settleTime, err := config.Watch.intervals()
+
+	if err != nil {
+		return err
+	}
+
+	if options.DefaultTitleSelection == "" {
+		options.DefaultTitleSelection = defaultUnattendedTitleSelection
+	}
+
+	if config.Watch.MetricsAddress != "" {
+		if err := startMetricsServer(config.Watch.MetricsAddress); err != nil {
+			return err
+		}
+
+		timestampedLog("Serving metrics on %s/metrics", config.Watch.MetricsAddress)
+	}
+
+	interrupts := handleInterrupts()
+	defer interrupts.stop()
+
+	ctx := interrupts.ctx
+
+	w := &watcher{
+		ctx:     ctx,
+		options: options,
+		drives:  make(map[int]*watchedDrive),
+	}
+
+	fmt.Printf("Watching for discs. Press Ctrl-C to stop.\n\n")
+
+	for {
+		w.poll(settleTime)
+
+		select {
+		case <-ctx.Done():
+			timestampedLog("Stopping - waiting for the discs being processed to stop")
+			w.discs.Wait()
+			return ErrInterrupted
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Lists the drives, updates their states and starts processing the discs which are ready to be scanned.
+func (w *watcher) poll(settleTime time.Duration) {
+	records, err := listDrives()
+
+	if err != nil {
+		timestampedLog("An error occurred while listing the drives - %v", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, record := range records {
+		drive, ok := w.drives[record.Index]
+
+		if !ok {
+			drive = &watchedDrive{Index: record.Index, State: watchEmpty}
+			w.drives[record.Index] = drive
+
+			timestampedLog("Watching drive %d - %s (%s)", record.Index, record.DriveName, record.DevicePath)
+		}
+
+		if !drive.observe(record, settleTime) || w.ctx.Err() != nil {
+			continue
+		}
+
+		drive.setState(watchScanning)
+
+		disc := watchedDisc{
+			drive:      drive,
+			generation: drive.generation,
+			Name:       drive.DiscName,
+			DevicePath: drive.DevicePath,
+		}
+
+		w.discs.Add(1)
+
+		go func() {
+			defer w.discs.Done()
+			w.processDisc(disc)
+		}()
+	}
+}
+
+// Moves the drive of the disc to a new state, unless the disc has since been removed from the drive.
+func (w *watcher) setState(disc watchedDisc, state watchState) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if disc.drive.generation == disc.generation {
+		disc.drive.setState(state)
+	}
+}
+
+// Scans, rips and encodes the disc, then ejects it. The configuration is read again for every disc so that changes
+// are picked up without restarting. Errors are logged and move the drive to the error state.
+func (w *watcher) processDisc(disc watchedDisc) {
+	index := disc.drive.Index
+	options := w.options
+
+	config, err := readRunConfig(&options)
+
+	if err == nil {
+		err = createOutputDirectories(config)
+	}
+
+	var titles []TitleInfo
+
+	if err == nil {
+		titles, err = readAndSelectTitles(w.ctx, config, []int{index}, options)
+	}
+
+	// Reading the disc is stopped by an interrupt
+	if err != nil && w.ctx.Err() != nil {
+		timestampedLog("Drive %d (%s) - processing disc '%s' was interrupted", index, disc.DevicePath, disc.Name)
+		return
+	}
+
+	if err != nil {
+		timestampedLog("Drive %d (%s) - an error occurred while reading disc '%s' - %v", index, disc.DevicePath, disc.Name, err)
+		w.setState(disc, watchError)
+		return
+	}
+
+	if len(titles) > 0 {
+		w.setState(disc, watchRipping)
+
+		err = startRun(config, titles, w.runOptions(disc, options))
+
+		if err == ErrInterrupted {
+			timestampedLog("Drive %d (%s) - processing disc '%s' was interrupted", index, disc.DevicePath, disc.Name)
+			return
+		}
+
+		if err != nil {
+			timestampedLog("Drive %d (%s) - processing disc '%s' failed - %v", index, disc.DevicePath, disc.Name, err)
+			w.setState(disc, watchError)
+			return
+		}
+
+		timestampedLog("Drive %d (%s) - disc '%s' has been processed", index, disc.DevicePath, disc.Name)
+	} else {
+		timestampedLog("Drive %d (%s) - no titles on disc '%s' were selected", index, disc.DevicePath, disc.Name)
+	}
+
+	w.setState(disc, watchDone)
+
+	// The disc has already been ejected if there were titles to rip
+	if config.EjectAfterRip && len(titles) > 0 {
+		return
+	}
+
+	if err := ejectDisc(context.Background(), config.EjectCommand, disc.DevicePath); err != nil {
+		timestampedLog("Drive %d (%s) - %v", index, disc.DevicePath, err)
+	}
+}
+
+// Returns the options for the run of the disc. The progress display is not drawn, as watch mode usually runs
+// unattended with its output going to a log, and the ripping and encoding state of each title is logged instead.
+func (w *watcher) runOptions(disc watchedDisc, options ExecOptions) ExecOptions {
+	type stages struct {
+		ripping  statusValue
+		encoding statusValue
+	}
+
+	// Status changes are passed on one at a time, so the last logged stages need no lock of their own
+	logged := make(map[[2]int]stages)
+
+	options.hooks = &runHooks{
+		ctx: w.ctx,
+		// The context is only done once an interrupt is received, so the run can be resumed
+		interrupted: func() bool { return w.ctx.Err() != nil },
+		onStatus: func(status titleStatus) {
+			key := [2]int{status.DiscId, status.TitleIndex}
+			current := stages{ripping: status.Ripping, encoding: status.Encoding}
+
+			if last, ok := logged[key]; ok && last == current {
+				return
+			}
+
+			logged[key] = current
+
+			timestampedLog("Drive %d (%s) - title %d (%s) - ripping %s, encoding %s", disc.drive.Index, disc.DevicePath,
+				status.TitleIndex, status.Title, status.Ripping, status.Encoding)
+		},
+	}
+
+	return options
+}
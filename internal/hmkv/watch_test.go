@@ -0,0 +1,102 @@
+package hmkv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dmars8047/handymkv/internal/mkvrobot"
+)
+
+func TestWatchedDriveObserve(t *testing.T) {
+	drive := &watchedDrive{Index: 0}
+	inserted := mkvrobot.Drive{State: mkvrobot.DriveInserted, DiscName: "MY DISC", DevicePath: "/dev/sr0"}
+
+	if drive.observe(inserted, 0) || drive.State != watchLoading {
+		t.Fatalf("state after insertion = %s, want loading without scanning", drive.State)
+	}
+
+	if !drive.observe(inserted, 0) {
+		t.Fatal("the settled disc is not ready to be scanned")
+	}
+
+	drive.setState(watchRipping)
+
+	if drive.observe(inserted, 0) || drive.State != watchRipping {
+		t.Errorf("state of the disc being ripped = %s, want it left alone", drive.State)
+	}
+
+	drive.observe(mkvrobot.Drive{State: mkvrobot.DriveEmptyClosed}, 0)
+
+	if drive.State != watchEmpty || drive.generation != 1 {
+		t.Errorf("state after removal = %s, generation %d, want empty with a new generation", drive.State, drive.generation)
+	}
+}
+
+// Checks that the drives are still polled while a disc is being ripped, and that the disc is ejected once it has been
+// processed.
+func TestWatcherPollsWhileProcessing(t *testing.T) {
+	useTestConfig(t, `{
+		"encoding_params": {"encoder": "x264", "quality": 20},
+		"mkv_output_directory": "mkv",
+		"handbrake_output_directory": "hb",
+		"eject_command": "fake-eject {device}"
+	}`)
+
+	rip := fakeRip(0, "My Disc, Feature_t00.mkv")
+	rip.wait = make(chan struct{})
+
+	r := newFakeRunner(t,
+		fakeInfo(fakeDiscInfo),
+		rip,
+		fakeRip(1, "My Disc_t01.mkv"),
+		fakeEncode(),
+		&fakeCommand{name: "fake-eject"},
+	)
+
+	w := &watcher{
+		ctx:     context.Background(),
+		options: ExecOptions{DefaultTitleSelection: "all"},
+		drives:  make(map[int]*watchedDrive),
+	}
+
+	// The first poll sees the disc and the second finds it settled and starts processing it
+	w.poll(0)
+	w.poll(0)
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for len(r.callsTo("makemkvcon")) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("makemkvcon calls = %v, want the disc to be ripping", r.callsTo("makemkvcon"))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// The rip is blocked, so this only returns if polling does not wait for processing
+	w.poll(0)
+
+	if calls := r.callsTo("makemkvcon"); len(calls) != 5 {
+		t.Errorf("makemkvcon calls = %v, want the drives to be listed while ripping", calls)
+	}
+
+	w.mutex.Lock()
+	state := w.drives[0].State
+	w.mutex.Unlock()
+
+	if state != watchRipping {
+		t.Errorf("state while ripping = %s, want ripping", state)
+	}
+
+	close(rip.wait)
+	w.discs.Wait()
+
+	if state := w.drives[0].State; state != watchDone {
+		t.Errorf("state once processed = %s, want done", state)
+	}
+
+	if ejects := r.callsTo("fake-eject"); len(ejects) != 1 || ejects[0][0] != "/dev/sr0" {
+		t.Errorf("eject calls = %v, want the disc in /dev/sr0 ejected", ejects)
+	}
+}
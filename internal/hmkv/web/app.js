@@ -0,0 +1,503 @@
+"use strict";
+
+// The dashboard talks to the same HTTP API as any other client. The token is kept in local storage so that it
+// survives reloads, and is sent as a bearer token with every request.
+const tokenKey = "handymkv-token";
+const reconnectDelay = 3000;
+const driveRefreshInterval = 10000;
+
+const jobs = new Map();
+let scannedDrive = null;
+let renderPending = false;
+let eventStream = null;
+
+// Creates an element with the given attributes and children. Strings are added as text so that disc and title
+// names are never interpreted as markup.
+function el(tag, attributes = {}, ...children) {
+  const element = document.createElement(tag);
+
+  for (const [name, value] of Object.entries(attributes)) {
+    if (name.startsWith("on")) {
+      element.addEventListener(name.slice(2), value);
+    } else if (value === true) {
+      element.setAttribute(name, "");
+    } else if (value !== false && value !== null && value !== undefined) {
+      element.setAttribute(name, value);
+    }
+  }
+
+  for (const child of children.flat()) {
+    if (child !== null && child !== undefined) {
+      element.append(child instanceof Node ? child : String(child));
+    }
+  }
+
+  return element;
+}
+
+function token() {
+  return localStorage.getItem(tokenKey);
+}
+
+class UnauthorizedError extends Error {}
+
+// Calls the API and returns the decoded JSON response. Shows the sign in form if the token is rejected.
+async function api(path, options = {}) {
+  const response = await fetch(path, {
+    ...options,
+    headers: { "Authorization": `Bearer ${token()}`, ...(options.headers || {}) },
+  });
+
+  if (response.status === 401) {
+    showLogin("The token was not accepted.");
+    throw new UnauthorizedError("unauthorized");
+  }
+
+  const body = await response.json();
+
+  if (!response.ok) {
+    throw new Error(body.error || `request failed with status ${response.status}`);
+  }
+
+  return body;
+}
+
+function showLogin(message = "") {
+  localStorage.removeItem(tokenKey);
+
+  if (eventStream) {
+    eventStream.abort();
+    eventStream = null;
+  }
+
+  document.getElementById("login").hidden = false;
+  document.getElementById("app").hidden = true;
+  document.getElementById("sign-out").hidden = true;
+  document.getElementById("login-error").textContent = message;
+  setConnected(false);
+}
+
+function showApp() {
+  document.getElementById("login").hidden = true;
+  document.getElementById("app").hidden = false;
+  document.getElementById("sign-out").hidden = false;
+
+  loadDrives();
+  loadProfiles();
+  streamEvents();
+}
+
+function setConnected(connected) {
+  const badge = document.getElementById("connection");
+  badge.textContent = connected ? "Live" : "Disconnected";
+  badge.className = connected ? "badge connected" : "badge";
+}
+
+function formatBytes(bytes) {
+  const units = ["Bytes", "KB", "MB", "GB", "TB"];
+  let value = bytes;
+  let unit = 0;
+
+  while (Math.abs(value) >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+
+  return unit === 0 ? `${value} ${units[unit]}` : `${value.toFixed(2)} ${units[unit]}`;
+}
+
+function formatDuration(seconds) {
+  const hours = Math.floor(seconds / 3600);
+  const minutes = Math.floor((seconds % 3600) / 60);
+  const remaining = Math.floor(seconds % 60);
+
+  return hours > 0 ? `${hours}h${minutes}m${remaining}s` : `${minutes}m${remaining}s`;
+}
+
+// Drives
+
+async function loadDrives() {
+  let drives;
+
+  try {
+    drives = await api("/api/drives");
+  } catch (err) {
+    if (!(err instanceof UnauthorizedError)) {
+      document.getElementById("drives").replaceChildren(el("tr", {}, el("td", { colspan: 7, class: "error" }, err.message)));
+    }
+
+    return;
+  }
+
+  const rows = drives.map((drive) => el("tr", {},
+    el("td", {}, drive.index),
+    el("td", {}, drive.disc_name || el("span", { class: "muted" }, "None")),
+    el("td", {}, drive.state),
+    el("td", {}, drive.media_type),
+    el("td", {}, drive.model),
+    el("td", {}, drive.device_path),
+    el("td", {}, drive.disc_name ? el("button", { class: "secondary", onclick: () => scanDrive(drive) }, "Select titles") : null),
+  ));
+
+  if (rows.length === 0) {
+    rows.push(el("tr", {}, el("td", { colspan: 7, class: "muted" }, "No drives found.")));
+  }
+
+  document.getElementById("drives").replaceChildren(...rows);
+}
+
+async function loadProfiles() {
+  try {
+    const profiles = await api("/api/profiles");
+    const select = document.getElementById("profile");
+
+    select.replaceChildren(el("option", { value: "" }, "None"), ...profiles.map((name) => el("option", { value: name }, name)));
+  } catch (err) {
+    // The profile list is optional, jobs can still be submitted without one
+  }
+}
+
+// Title selection
+
+async function scanDrive(drive) {
+  scannedDrive = drive;
+
+  const picker = document.getElementById("title-picker");
+  const profile = document.getElementById("profile").value;
+
+  picker.hidden = false;
+  document.getElementById("title-picker-heading").textContent = `Titles on ${drive.disc_name} (drive ${drive.index})`;
+  document.getElementById("job-error").textContent = "";
+  document.getElementById("titles").replaceChildren(el("tr", {}, el("td", { colspan: 8, class: "muted" }, "Reading titles from the disc...")));
+
+  let titles;
+
+  try {
+    titles = await api(`/api/drives/${drive.index}/titles?filtered=true&profile=${encodeURIComponent(profile)}`);
+  } catch (err) {
+    document.getElementById("titles").replaceChildren(el("tr", {}, el("td", { colspan: 8, class: "error" }, err.message)));
+    return;
+  }
+
+  const rows = titles.map((title) => el("tr", {},
+    el("td", {}, el("input", { type: "checkbox", class: "title-checkbox", value: title.index })),
+    el("td", {}, title.index),
+    el("td", {}, title.file_name),
+    el("td", {}, title.length),
+    el("td", {}, title.file_size),
+    el("td", {}, title.chapters),
+    el("td", {}, title.source_file_name),
+    el("td", { class: "streams" }, (title.streams || []).map((stream) => el("div", {}, describeStream(stream)))),
+  ));
+
+  if (rows.length === 0) {
+    rows.push(el("tr", {}, el("td", { colspan: 8, class: "muted" }, "Every title on the disc was hidden by the title filters.")));
+  }
+
+  document.getElementById("select-all").checked = false;
+  document.getElementById("titles").replaceChildren(...rows);
+}
+
+function describeStream(stream) {
+  const parts = [stream.codec_long || stream.codec];
+
+  if (stream.type === "Video") {
+    parts.push(stream.resolution, stream.frame_rate);
+  } else {
+    parts.push(stream.lang_name);
+
+    if (stream.type === "Audio") {
+      parts.push(stream.channel_layout || (stream.channels ? `${stream.channels}ch` : ""));
+    }
+  }
+
+  return `${stream.type}: ${parts.filter(Boolean).join(" ")}`;
+}
+
+async function submitJob(event) {
+  event.preventDefault();
+
+  const selected = [...document.querySelectorAll(".title-checkbox:checked")].map((checkbox) => checkbox.value);
+  const errorText = document.getElementById("job-error");
+
+  if (selected.length === 0) {
+    errorText.textContent = "Select at least one title.";
+    return;
+  }
+
+  try {
+    await api("/api/jobs", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        drives: [String(scannedDrive.index)],
+        title_selection: selected.join(","),
+        profile: document.getElementById("profile").value,
+        continue_on_error: document.getElementById("continue-on-error").checked,
+      }),
+    });
+  } catch (err) {
+    errorText.textContent = err.message;
+    return;
+  }
+
+  document.getElementById("title-picker").hidden = true;
+}
+
+// Runs
+
+async function cancelJob(id) {
+  try {
+    await api(`/api/jobs/${id}/cancel`, { method: "POST" });
+  } catch (err) {
+    alert(`The run could not be cancelled - ${err.message}`);
+  }
+}
+
+function scheduleRender() {
+  if (renderPending) {
+    return;
+  }
+
+  renderPending = true;
+
+  requestAnimationFrame(() => {
+    renderPending = false;
+    renderJobs();
+  });
+}
+
+function renderJobs() {
+  const container = document.getElementById("jobs");
+
+  if (jobs.size === 0) {
+    container.replaceChildren(el("p", { class: "muted" }, "No runs have been started since the server started."));
+    return;
+  }
+
+  const sorted = [...jobs.values()].sort((a, b) => Number(b.id) - Number(a.id));
+
+  container.replaceChildren(...sorted.map(renderJob));
+}
+
+function renderJob(job) {
+  const discs = [...new Set(job.titles.map((title) => title.disc_id))];
+
+  return el("div", { class: "job" },
+    el("div", { class: "job-header" },
+      el("strong", {}, `Run ${job.id}`),
+      el("span", { class: `badge ${job.state}` }, job.state),
+      el("span", { class: "muted" }, `Drives ${job.drives.join(", ")}`),
+      job.profile ? el("span", { class: "muted" }, `Profile ${job.profile}`) : null,
+      el("span", { class: "muted" }, new Date(job.created_at).toLocaleString()),
+      el("span", { class: "actions" },
+        job.state === "running" ? el("button", { class: "danger", onclick: () => cancelJob(job.id) }, "Cancel") : null),
+    ),
+    job.error ? el("p", { class: "error" }, job.error) : null,
+    job.titles.length === 0 && job.state === "running" ? el("p", { class: "muted" }, "Reading titles from the disc...") : null,
+    job.titles.length > 0 ? el("table", {},
+      el("thead", {}, el("tr", {},
+        el("th", {}, "Title"), discs.length > 1 ? el("th", {}, "Disc") : null,
+        el("th", {}, "Ripping"), el("th", {}, "Encoding"), el("th", {}, "FPS"), el("th", {}, "ETA"))),
+      el("tbody", {}, job.titles.map((title) => el("tr", {},
+        el("td", {}, title.file_name, title.error ? el("div", { class: "error" }, title.error) : null),
+        discs.length > 1 ? el("td", {}, title.disc_id) : null,
+        el("td", {}, progressBar(title.ripping, title.rip_percent, title.rip_attempts), title.rip_operation && (title.ripping === "In Progress" || title.ripping === "Retrying") ? el("div", { class: "muted" }, title.rip_operation) : null),
+        el("td", {}, progressBar(title.encoding, title.encode_percent, title.encode_attempts), title.encode_operation && title.encoding === "Retrying" ? el("div", { class: "muted" }, title.encode_operation) : null),
+        el("td", {}, title.encoding === "In Progress" && title.encode_avg_fps > 0 ? `${title.encode_fps.toFixed(1)} (avg ${title.encode_avg_fps.toFixed(1)})` : ""),
+        el("td", {}, title.encoding === "In Progress" && title.encode_avg_fps > 0 ? formatDuration(title.encode_eta_seconds) : ""),
+      ))),
+    ) : null,
+    job.summary ? renderSummary(job.summary) : null,
+  );
+}
+
+function progressBar(status, percent, attempts) {
+  let width = 0;
+  let className = "progress";
+  let label = status;
+
+  if (status === "In Progress") {
+    width = percent;
+    label = `${percent.toFixed(0)}%`;
+  } else if (status === "Complete") {
+    width = 100;
+    className += " complete";
+  } else if (status === "Failed") {
+    width = 100;
+    className += " failed";
+  } else if (status === "Retrying") {
+    width = 100;
+    className += " retrying";
+  }
+
+  if (attempts > 1) {
+    label += ` #${attempts}`;
+  }
+
+  return el("div", { class: className },
+    el("div", { class: "bar", style: `width: ${width}%` }),
+    el("div", { class: "label" }, label),
+  );
+}
+
+function renderSummary(summary) {
+  const saved = summary.raw_size_bytes - summary.encoded_size_bytes;
+  const parts = [
+    `Time elapsed ${formatDuration(summary.duration_seconds)}`,
+    `Raw ${formatBytes(summary.raw_size_bytes)}`,
+    `Encoded ${formatBytes(summary.encoded_size_bytes)}`,
+  ];
+
+  if (saved > 0 && summary.encoded_size_bytes > 0) {
+    parts.push(`Saved ${formatBytes(saved)}`);
+  }
+
+  return el("div", { class: "summary" },
+    el("div", {}, parts.join(" · ")),
+    el("div", {}, "Encoded files: ", el("code", {}, summary.handbrake_output_directory)),
+  );
+}
+
+// Events
+
+function applyEvent(name, data) {
+  if (name === "job") {
+    jobs.set(data.id, data);
+
+    // A finished run frees its drives and may have ejected its disc
+    if (data.state !== "running") {
+      loadDrives();
+    }
+  } else if (name === "title") {
+    const job = jobs.get(data.job_id);
+
+    if (!job) {
+      return;
+    }
+
+    const index = job.titles.findIndex((title) => title.disc_id === data.disc_id && title.title_index === data.title_index);
+
+    if (index < 0) {
+      job.titles.push(data);
+    } else {
+      job.titles[index] = data;
+    }
+  }
+
+  scheduleRender();
+}
+
+// Follows the event stream of every run. EventSource cannot send an Authorization header, so the stream is read
+// with fetch and parsed here. The stream is reopened if it ends, and starts with the current state of every run.
+async function streamEvents() {
+  const controller = new AbortController();
+  eventStream = controller;
+
+  try {
+    const response = await fetch("/api/events", {
+      headers: { "Authorization": `Bearer ${token()}` },
+      signal: controller.signal,
+    });
+
+    if (response.status === 401) {
+      showLogin("The token was not accepted.");
+      return;
+    }
+
+    if (!response.ok) {
+      throw new Error(`event stream failed with status ${response.status}`);
+    }
+
+    setConnected(true);
+    jobs.clear();
+
+    const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = "";
+
+    for (;;) {
+      const { value, done } = await reader.read();
+
+      if (done) {
+        break;
+      }
+
+      buffer += value;
+
+      let end;
+
+      while ((end = buffer.indexOf("\n\n")) >= 0) {
+        const block = buffer.slice(0, end);
+        buffer = buffer.slice(end + 2);
+
+        let name = "message";
+        let data = "";
+
+        for (const line of block.split("\n")) {
+          if (line.startsWith("event: ")) {
+            name = line.slice(7);
+          } else if (line.startsWith("data: ")) {
+            data += line.slice(6);
+          }
+        }
+
+        if (data) {
+          applyEvent(name, JSON.parse(data));
+        }
+      }
+    }
+  } catch (err) {
+    if (controller.signal.aborted) {
+      return;
+    }
+  }
+
+  setConnected(false);
+
+  if (eventStream === controller) {
+    setTimeout(() => {
+      if (eventStream === controller) {
+        streamEvents();
+      }
+    }, reconnectDelay);
+  }
+}
+
+// Start up
+
+document.getElementById("login-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  localStorage.setItem(tokenKey, document.getElementById("token").value);
+  document.getElementById("token").value = "";
+  showApp();
+});
+
+document.getElementById("sign-out").addEventListener("click", () => showLogin());
+document.getElementById("refresh-drives").addEventListener("click", loadDrives);
+document.getElementById("close-titles").addEventListener("click", () => {
+  document.getElementById("title-picker").hidden = true;
+});
+document.getElementById("job-form").addEventListener("submit", submitJob);
+document.getElementById("profile").addEventListener("change", () => {
+  // Profiles can change the title filters, so the list of titles is read again
+  if (scannedDrive && !document.getElementById("title-picker").hidden) {
+    scanDrive(scannedDrive);
+  }
+});
+document.getElementById("select-all").addEventListener("change", (event) => {
+  for (const checkbox of document.querySelectorAll(".title-checkbox")) {
+    checkbox.checked = event.target.checked;
+  }
+});
+
+setInterval(() => {
+  if (token() && !document.getElementById("app").hidden) {
+    loadDrives();
+  }
+}, driveRefreshInterval);
+
+if (token()) {
+  showApp();
+} else {
+  showLogin();
+}
@@ -0,0 +1,17 @@
+package mkvrobot
+
+/*
+package mkvrobot parses the robot mode (-r) output of makemkvcon.
+
+# Overview
+
+Each line of robot mode output is a record. A record starts with a kind prefix followed by a colon and a comma delimited list of fields.
+Fields are either bare integers or double quoted strings. Quoted strings may contain commas, and double quotes and backslashes inside them are escaped with a backslash.
+
+	DRV:0,2,999,12,"BD-RE HL-DT-ST BD-RE  BH16NS40 1.05 KLZK7UI0426","STAR TREK TNG S4 D2","/dev/sr0"
+	TINFO:0,27,0,"Star Trek, TNG_t00.mkv"
+
+Parse() - Parses a single line into one of the typed records (Drive, TitleCount, DiscAttribute, TitleAttribute, StreamAttribute, Message, ProgressValue, ProgressTitle).
+
+ParseAll() - Parses every line read from a reader, skipping lines that are not robot mode records. Malformed records are skipped and returned separately.
+*/
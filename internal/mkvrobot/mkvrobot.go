@@ -0,0 +1,484 @@
+package mkvrobot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Returned by Parse when the line does not start with a known record kind.
+var ErrUnknownRecord = errors.New("unknown robot mode record")
+
+// A parsed robot mode record. One of Drive, TitleCount, DiscAttribute, TitleAttribute, StreamAttribute, Message, ProgressValue or ProgressTitle.
+type Record interface {
+	// The kind prefix of the record. Example: DRV
+	Kind() string
+}
+
+// DRV:index,state,unknown,flags,"drive name","disc name","device path"
+type Drive struct {
+	Index      int
+	State      DriveState
+	Unknown    int
+	Flags      DiscFlags
+	DriveName  string
+	DiscName   string
+	DevicePath string
+}
+
+func (Drive) Kind() string { return "DRV" }
+
+// The state of a drive as reported in the DRV state field.
+type DriveState int
+
+const (
+	DriveEmptyClosed DriveState = 0
+	DriveEmptyOpen   DriveState = 1
+	DriveInserted    DriveState = 2
+	DriveLoading     DriveState = 3
+	// Reported for unused drive slots.
+	DriveNoDrive    DriveState = 256
+	DriveUnmounting DriveState = 257
+)
+
+// String representation of the DriveState.
+func (s DriveState) String() string {
+	switch s {
+	case DriveEmptyClosed:
+		return "no disc"
+	case DriveEmptyOpen:
+		return "tray open"
+	case DriveInserted:
+		return "inserted"
+	case DriveLoading:
+		return "loading"
+	case DriveNoDrive:
+		return "no drive"
+	case DriveUnmounting:
+		return "unmounting"
+	default:
+		return "unknown"
+	}
+}
+
+// Marshals the drive state as its string representation.
+func (s DriveState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// The file systems found on the disc as reported in the DRV flags field.
+type DiscFlags int
+
+const (
+	DiscFlagDVDFiles    DiscFlags = 1
+	DiscFlagHDDVDFiles  DiscFlags = 2
+	DiscFlagBlurayFiles DiscFlags = 4
+	DiscFlagAACSFiles   DiscFlags = 8
+	DiscFlagBDSVMFiles  DiscFlags = 16
+	// Set for UHD Blu-ray discs, which are protected with AACS 2.
+	DiscFlagAACS2Files DiscFlags = 32
+)
+
+// The name of each flag in the order they are listed.
+var discFlagNames = []struct {
+	flag DiscFlags
+	name string
+}{
+	{DiscFlagDVDFiles, "dvd_files"},
+	{DiscFlagHDDVDFiles, "hddvd_files"},
+	{DiscFlagBlurayFiles, "bluray_files"},
+	{DiscFlagAACSFiles, "aacs_files"},
+	{DiscFlagBDSVMFiles, "bdsvm_files"},
+	{DiscFlagAACS2Files, "aacs2_files"},
+}
+
+// Returns the names of the flags which are set.
+func (f DiscFlags) Names() []string {
+	names := make([]string, 0)
+
+	for _, flag := range discFlagNames {
+		if f&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+
+	return names
+}
+
+// Returns the type of media the flags indicate. Example: Blu-ray
+// Blu-ray discs with AACS 2 files are reported as UHD Blu-ray. Returns an empty string if no disc is inserted.
+func (f DiscFlags) MediaType() string {
+	switch {
+	case f&DiscFlagBlurayFiles != 0 && f&DiscFlagAACS2Files != 0:
+		return "UHD Blu-ray"
+	case f&DiscFlagBlurayFiles != 0:
+		return "Blu-ray"
+	case f&DiscFlagHDDVDFiles != 0:
+		return "HD DVD"
+	case f&DiscFlagDVDFiles != 0:
+		return "DVD"
+	default:
+		return ""
+	}
+}
+
+// TCOUNT:count. The robot mode documentation names the record TCOUT, but makemkvcon writes TCOUNT.
+// Both are accepted.
+type TitleCount struct {
+	Count int
+}
+
+func (TitleCount) Kind() string { return "TCOUNT" }
+
+// CINFO:id,code,"value"
+type DiscAttribute struct {
+	Id    int
+	Code  int
+	Value string
+}
+
+func (DiscAttribute) Kind() string { return "CINFO" }
+
+// TINFO:title,id,code,"value"
+type TitleAttribute struct {
+	Title int
+	Id    int
+	Code  int
+	Value string
+}
+
+func (TitleAttribute) Kind() string { return "TINFO" }
+
+// SINFO:title,stream,id,code,"value"
+type StreamAttribute struct {
+	Title  int
+	Stream int
+	Id     int
+	Code   int
+	Value  string
+}
+
+func (StreamAttribute) Kind() string { return "SINFO" }
+
+// MSG:code,flags,count,"message","format","param0","param1",...
+type Message struct {
+	Code   int
+	Flags  int
+	Count  int
+	Text   string
+	Format string
+	Params []string
+}
+
+func (Message) Kind() string { return "MSG" }
+
+// PRGV:current,total,max
+type ProgressValue struct {
+	Current int
+	Total   int
+	Max     int
+}
+
+func (ProgressValue) Kind() string { return "PRGV" }
+
+// Returns the total progress as a percentage.
+func (p ProgressValue) TotalPercent() float64 {
+	if p.Max <= 0 {
+		return 0
+	}
+
+	return float64(p.Total) / float64(p.Max) * 100
+}
+
+// Returns the current operation progress as a percentage.
+func (p ProgressValue) CurrentPercent() float64 {
+	if p.Max <= 0 {
+		return 0
+	}
+
+	return float64(p.Current) / float64(p.Max) * 100
+}
+
+// PRGC:code,id,"name" (current operation) or PRGT:code,id,"name" (total operation)
+type ProgressTitle struct {
+	// True for PRGT (total operation) records, false for PRGC (current operation) records.
+	IsTotal bool
+	Code    int
+	Id      int
+	Name    string
+}
+
+func (p ProgressTitle) Kind() string {
+	if p.IsTotal {
+		return "PRGT"
+	}
+
+	return "PRGC"
+}
+
+// Parses a single line of robot mode output.
+// Returns ErrUnknownRecord if the line is not a robot mode record.
+func Parse(line string) (Record, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	kind, rest, found := strings.Cut(line, ":")
+
+	if !found {
+		return nil, ErrUnknownRecord
+	}
+
+	switch kind {
+	case "DRV", "TCOUNT", "TCOUT", "CINFO", "TINFO", "SINFO", "MSG", "PRGV", "PRGC", "PRGT":
+	default:
+		return nil, ErrUnknownRecord
+	}
+
+	fields, err := splitFields(rest)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s record: %w", kind, err)
+	}
+
+	f := fieldReader{kind: kind, fields: fields}
+
+	var record Record
+
+	switch kind {
+	case "DRV":
+		record = Drive{
+			Index:      f.int(0),
+			State:      DriveState(f.int(1)),
+			Unknown:    f.int(2),
+			Flags:      DiscFlags(f.int(3)),
+			DriveName:  f.string(4),
+			DiscName:   f.string(5),
+			DevicePath: f.string(6),
+		}
+	case "TCOUNT", "TCOUT":
+		record = TitleCount{Count: f.int(0)}
+	case "CINFO":
+		record = DiscAttribute{
+			Id:    f.int(0),
+			Code:  f.int(1),
+			Value: f.string(2),
+		}
+	case "TINFO":
+		record = TitleAttribute{
+			Title: f.int(0),
+			Id:    f.int(1),
+			Code:  f.int(2),
+			Value: f.string(3),
+		}
+	case "SINFO":
+		record = StreamAttribute{
+			Title:  f.int(0),
+			Stream: f.int(1),
+			Id:     f.int(2),
+			Code:   f.int(3),
+			Value:  f.string(4),
+		}
+	case "MSG":
+		msg := Message{
+			Code:   f.int(0),
+			Flags:  f.int(1),
+			Count:  f.int(2),
+			Text:   f.string(3),
+			Format: f.string(4),
+		}
+
+		for i := 5; i < len(fields); i++ {
+			msg.Params = append(msg.Params, f.string(i))
+		}
+
+		record = msg
+	case "PRGV":
+		record = ProgressValue{
+			Current: f.int(0),
+			Total:   f.int(1),
+			Max:     f.int(2),
+		}
+	case "PRGC", "PRGT":
+		record = ProgressTitle{
+			IsTotal: kind == "PRGT",
+			Code:    f.int(0),
+			Id:      f.int(1),
+			Name:    f.string(2),
+		}
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return record, nil
+}
+
+// A line which has the prefix of a robot mode record but could not be parsed.
+type MalformedRecord struct {
+	// The number of the line in the output, starting at 1.
+	Line int
+	// The kind prefix of the record. Example: MSG
+	Kind string
+	Text string
+	Err  error
+}
+
+func (m MalformedRecord) Error() string {
+	return fmt.Sprintf("malformed robot mode record on line %d: %v", m.Line, m.Err)
+}
+
+func (m MalformedRecord) Unwrap() error {
+	return m.Err
+}
+
+// Parses every robot mode record read from r. Lines which are not robot mode records are skipped.
+// Malformed records are skipped as well and returned separately, so that a single odd line does not prevent the rest
+// of the output from being read. Callers decide whether the malformed records affect the kinds of record they need.
+// An error is only returned if r cannot be read.
+func ParseAll(r io.Reader) ([]Record, []MalformedRecord, error) {
+	records := make([]Record, 0)
+	malformed := make([]MalformedRecord, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		record, err := Parse(scanner.Text())
+
+		if err != nil {
+			if errors.Is(err, ErrUnknownRecord) {
+				continue
+			}
+
+			kind, _, _ := strings.Cut(scanner.Text(), ":")
+
+			malformed = append(malformed, MalformedRecord{
+				Line: line,
+				Kind: kind,
+				Text: scanner.Text(),
+				Err:  err,
+			})
+
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return records, malformed, fmt.Errorf("error reading robot mode output: %w", err)
+	}
+
+	return records, malformed, nil
+}
+
+// Splits the comma delimited fields of a record, unquoting and unescaping quoted fields.
+func splitFields(s string) ([]string, error) {
+	fields := make([]string, 0, 8)
+
+	if s == "" {
+		return fields, nil
+	}
+
+	var sb strings.Builder
+
+	for i := 0; ; {
+		if i < len(s) && s[i] == '"' {
+			// Quoted field
+			sb.Reset()
+			i++
+
+			closed := false
+
+			for i < len(s) {
+				c := s[i]
+
+				if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					sb.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+
+				sb.WriteByte(c)
+				i++
+			}
+
+			if !closed {
+				return nil, fmt.Errorf("unterminated quoted field at offset %d", i)
+			}
+
+			fields = append(fields, sb.String())
+
+			if i == len(s) {
+				return fields, nil
+			}
+
+			if s[i] != ',' {
+				return nil, fmt.Errorf("unexpected character %q after quoted field at offset %d", s[i], i)
+			}
+
+			i++
+			continue
+		}
+
+		// Bare field
+		end := strings.IndexByte(s[i:], ',')
+
+		if end < 0 {
+			fields = append(fields, s[i:])
+			return fields, nil
+		}
+
+		fields = append(fields, s[i:i+end])
+		i += end + 1
+	}
+}
+
+// Reads typed values from the fields of a record, keeping the first error encountered.
+type fieldReader struct {
+	kind   string
+	fields []string
+	err    error
+}
+
+func (f *fieldReader) int(i int) int {
+	if f.err != nil {
+		return 0
+	}
+
+	if i >= len(f.fields) {
+		f.err = fmt.Errorf("%s record: missing field %d", f.kind, i)
+		return 0
+	}
+
+	value, err := strconv.Atoi(f.fields[i])
+
+	if err != nil {
+		f.err = fmt.Errorf("%s record: field %d is not an integer: %w", f.kind, i, err)
+		return 0
+	}
+
+	return value
+}
+
+func (f *fieldReader) string(i int) string {
+	if f.err != nil {
+		return ""
+	}
+
+	if i >= len(f.fields) {
+		f.err = fmt.Errorf("%s record: missing field %d", f.kind, i)
+		return ""
+	}
+
+	return f.fields[i]
+}
@@ -0,0 +1,276 @@
+package mkvrobot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the output of the parser")
+
+// Formats the parsed records and malformed records one per line, as written to the golden files.
+func formatRecords(t *testing.T, records []Record, malformed []MalformedRecord) string {
+	var sb strings.Builder
+
+	for _, record := range records {
+		var data bytes.Buffer
+
+		encoder := json.NewEncoder(&data)
+		encoder.SetEscapeHTML(false)
+
+		if err := encoder.Encode(record); err != nil {
+			t.Fatal(err)
+		}
+
+		// The encoder ends the record with a newline
+		fmt.Fprintf(&sb, "%s %s", record.Kind(), data.Bytes())
+	}
+
+	for _, record := range malformed {
+		fmt.Fprintf(&sb, "malformed %s line %d: %v\n", record.Kind, record.Line, record.Err)
+	}
+
+	return sb.String()
+}
+
+// Parses each captured makemkvcon output in testdata and compares the records with its golden file.
+// Run with -update to rewrite the golden files after an intended change to the parser.
+func TestParseAllGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inputs) == 0 {
+		t.Fatal("no captured output found in testdata")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".txt")
+
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			records, malformed, err := ParseAll(bytes.NewReader(data))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := formatRecords(t, records, malformed)
+			goldenPath := filepath.Join("testdata", name+".golden")
+
+			if *update {
+				if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			want, err := os.ReadFile(goldenPath)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != string(want) {
+				t.Errorf("records of %s do not match %s\ngot:\n%s\nwant:\n%s", input, goldenPath, got, want)
+			}
+		})
+	}
+}
+
+// Guards against the Windows capture being normalized to \n line endings, which would stop it from covering \r\n.
+func TestWindowsCaptureHasCRLF(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "info_dvd_windows.txt"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(data, []byte("\r\n")) {
+		t.Error("info_dvd_windows.txt has lost its \\r\\n line endings")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Record
+	}{
+		{
+			name: "comma inside quotes",
+			line: `TINFO:0,27,0,"Star Trek, TNG_t00.mkv"`,
+			want: TitleAttribute{Title: 0, Id: 27, Code: 0, Value: "Star Trek, TNG_t00.mkv"},
+		},
+		{
+			name: "escaped quote",
+			line: `CINFO:2,0,"The \"Best\" of TV"`,
+			want: DiscAttribute{Id: 2, Code: 0, Value: `The "Best" of TV`},
+		},
+		{
+			name: "escaped backslash",
+			line: `DRV:0,2,999,1,"drive","C:\\DISC\\","E:"`,
+			want: Drive{Index: 0, State: DriveInserted, Unknown: 999, Flags: DiscFlagDVDFiles, DriveName: "drive", DiscName: `C:\DISC\`, DevicePath: "E:"},
+		},
+		{
+			name: "carriage return line ending",
+			line: "PRGV:1,2,3\r\n",
+			want: ProgressValue{Current: 1, Total: 2, Max: 3},
+		},
+		{
+			name: "empty quoted fields",
+			line: `DRV:1,256,999,0,"","",""`,
+			want: Drive{Index: 1, State: DriveNoDrive, Unknown: 999},
+		},
+		{
+			name: "message parameters",
+			line: `MSG:5036,260,1,"Copy complete. 1 titles saved.","Copy complete. %1 titles saved.","1"`,
+			want: Message{Code: 5036, Flags: 260, Count: 1, Text: "Copy complete. 1 titles saved.", Format: "Copy complete. %1 titles saved.", Params: []string{"1"}},
+		},
+		{
+			name: "documented title count",
+			line: "TCOUT:3",
+			want: TitleCount{Count: 3},
+		},
+		{
+			name: "title count",
+			line: "TCOUNT:3",
+			want: TitleCount{Count: 3},
+		},
+		{
+			name: "total progress title",
+			line: `PRGT:5018,0,"Opening disc"`,
+			want: ProgressTitle{IsTotal: true, Code: 5018, Id: 0, Name: "Opening disc"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.line)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", test.line, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		unknown bool
+	}{
+		{name: "not a record", line: "Use: makemkvcon [switches] Command [Parameters]", unknown: true},
+		{name: "unknown kind", line: "XYZ:1,2,3", unknown: true},
+		{name: "empty line", line: "", unknown: true},
+		{name: "unterminated quote", line: `MSG:1,0,0,"unterminated`},
+		{name: "text after quote", line: `TINFO:0,27,0,"name"x`},
+		{name: "not an integer", line: `PRGV:a,2,3`},
+		{name: "missing field", line: `PRGV:1,2`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record, err := Parse(test.line)
+
+			if err == nil {
+				t.Fatalf("Parse(%q) = %+v, want an error", test.line, record)
+			}
+
+			if errors.Is(err, ErrUnknownRecord) != test.unknown {
+				t.Errorf("Parse(%q) error = %v, unknown record %t", test.line, err, test.unknown)
+			}
+		})
+	}
+}
+
+func TestParseAllContinuesPastMalformedRecords(t *testing.T) {
+	output := "MSG:1,0,0,\"unterminated\nDRV:0,2,999,12,\"drive\",\"DISC, ONE\",\"/dev/sr0\"\r\nPRGV:x\nTCOUNT:1\n"
+
+	records, malformed, err := ParseAll(strings.NewReader(output))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[0].Kind() != "DRV" || records[1].Kind() != "TCOUNT" {
+		t.Errorf("records = %+v, want the DRV and TCOUNT records", records)
+	}
+
+	if len(malformed) != 2 || malformed[0].Line != 1 || malformed[0].Kind != "MSG" || malformed[1].Line != 3 || malformed[1].Kind != "PRGV" {
+		t.Errorf("malformed = %+v, want the MSG on line 1 and PRGV on line 3", malformed)
+	}
+}
+
+func TestDiscFlags(t *testing.T) {
+	flags := DiscFlagBlurayFiles | DiscFlagAACSFiles
+
+	tests := []struct {
+		flags DiscFlags
+		want  string
+	}{
+		{flags: flags, want: "Blu-ray"},
+		{flags: flags | DiscFlagBDSVMFiles, want: "Blu-ray"},
+		{flags: flags | DiscFlagAACS2Files, want: "UHD Blu-ray"},
+		{flags: DiscFlagDVDFiles, want: "DVD"},
+		{flags: DiscFlagHDDVDFiles, want: "HD DVD"},
+	}
+
+	for _, test := range tests {
+		if got := test.flags.MediaType(); got != test.want {
+			t.Errorf("MediaType() of %v = %q, want %q", test.flags.Names(), got, test.want)
+		}
+	}
+
+	if got := flags.Names(); !reflect.DeepEqual(got, []string{"bluray_files", "aacs_files"}) {
+		t.Errorf("Names() = %v", got)
+	}
+
+	if got := DiscFlags(0).MediaType(); got != "" {
+		t.Errorf("MediaType() of no flags = %q, want empty", got)
+	}
+}
+
+func TestDiscFlagsOfUHDCapture(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "info_uhd.txt"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, _, err := ParseAll(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drive, ok := records[1].(Drive)
+
+	if !ok {
+		t.Fatalf("record = %+v, want the DRV record of the drive holding the disc", records[1])
+	}
+
+	if got := drive.Flags.MediaType(); got != "UHD Blu-ray" {
+		t.Errorf("MediaType() = %q, want UHD Blu-ray", got)
+	}
+}